@@ -46,4 +46,6 @@ func init() {
 	env.InitUpdate(envCmd)
 	env.InitDefault(envCmd)
 	env.InitPullRecipes(envCmd)
+	env.InitRecipeFromWorkflow(envCmd)
+	env.InitNode(envCmd)
 }
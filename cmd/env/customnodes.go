@@ -0,0 +1,503 @@
+package env
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+	util "github.com/richinsley/comfycli/pkg"
+	kinda "github.com/richinsley/kinda/pkg"
+)
+
+// the state of a custom node repository in an environment
+type CustomNodeStatus struct {
+	Name     string `json:"name"`
+	GitURL   string `json:"git_url,omitempty"`
+	Branch   string `json:"branch,omitempty"`
+	Commit   string `json:"commit,omitempty"`
+	Path     string `json:"path"`
+	Recorded bool   `json:"recorded"`
+	Error    string `json:"error,omitempty"`
+}
+
+// get the folder name of a custom node in custom_nodes, as cloned by kinda.NewGitRepo
+func customNodeFolder(gitURL string) string {
+	name, err := kinda.ExtractURLComponent(gitURL)
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+// check if two custom nodes refer to the same repository
+func sameCustomNode(a CustomNode, b CustomNode) bool {
+	if a.GitURL != "" && b.GitURL != "" {
+		return util.NormalizeRepoURL(a.GitURL) == util.NormalizeRepoURL(b.GitURL)
+	}
+	return a.Name == b.Name
+}
+
+// get the default branch of a remote repository
+func remoteDefaultBranch(gitURL string) (string, error) {
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{gitURL},
+	})
+	refs, err := remote.List(&git.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("error listing remote %s: %v", gitURL, err)
+	}
+
+	var head *plumbing.Reference
+	for _, r := range refs {
+		if r.Name() == plumbing.HEAD {
+			head = r
+			break
+		}
+	}
+	if head == nil {
+		return "", fmt.Errorf("remote %s has no HEAD", gitURL)
+	}
+	if head.Type() == plumbing.SymbolicReference {
+		return head.Target().Short(), nil
+	}
+	// the server did not report the symbolic ref, find the branch HEAD points to
+	for _, r := range refs {
+		if r.Name().IsBranch() && r.Hash() == head.Hash() {
+			return r.Name().Short(), nil
+		}
+	}
+	return "", fmt.Errorf("could not find the default branch of %s", gitURL)
+}
+
+// checkout a commit, leaving the repository with a detached HEAD
+func checkoutCommit(repo *git.Repository, commit string) (string, error) {
+	hash, err := repo.ResolveRevision(plumbing.Revision(commit))
+	if err != nil {
+		return "", fmt.Errorf("commit %s not found: %v", commit, err)
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		return "", err
+	}
+	err = w.Checkout(&git.CheckoutOptions{Hash: *hash, Force: true})
+	if err != nil {
+		return "", fmt.Errorf("error checking out commit %s: %v", commit, err)
+	}
+	return hash.String(), nil
+}
+
+// checkout a branch, creating it from the remote branch if it does not exist locally
+func checkoutBranch(repo *git.Repository, branch string) error {
+	w, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	err = w.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(branch), Force: true})
+	if err == nil {
+		return nil
+	}
+	remoteRef, rerr := repo.Reference(plumbing.NewRemoteReferenceName("origin", branch), true)
+	if rerr != nil {
+		return fmt.Errorf("error checking out branch %s: %v", branch, err)
+	}
+	return w.Checkout(&git.CheckoutOptions{
+		Branch: plumbing.NewBranchReferenceName(branch),
+		Hash:   remoteRef.Hash(),
+		Create: true,
+		Force:  true,
+	})
+}
+
+// pip install the requirements of a custom node if requirements.txt exists
+func installCustomNodeRequirements(env *kinda.Environment, repoPath string, feedback kinda.CreateEnvironmentOptions) error {
+	customReqPath := path.Join(repoPath, "requirements.txt")
+	if _, err := os.Stat(customReqPath); err == nil {
+		err = env.PipInstallRequirmements(customReqPath, feedback)
+		if err != nil {
+			return fmt.Errorf("error installing requirements: %v", err)
+		}
+	}
+	return nil
+}
+
+// clone a custom node into the custom_nodes folder of ComfyUI and install its requirements
+// returns the custom node as installed, with its branch and commit resolved
+func installCustomNode(env *kinda.Environment, comfyFolder string, node CustomNode, feedback kinda.CreateEnvironmentOptions) (CustomNode, string, error) {
+	if node.Name == "" {
+		node.Name = customNodeFolder(node.GitURL)
+	}
+	if node.Branch == "" {
+		branch, err := remoteDefaultBranch(node.GitURL)
+		if err != nil {
+			return node, "", err
+		}
+		node.Branch = branch
+	}
+
+	repo, repoPath, err := kinda.NewGitRepo(node.GitURL, filepath.Join(comfyFolder, "custom_nodes"), node.Branch)
+	if err != nil {
+		return node, "", fmt.Errorf("error cloning custom node: %v", err)
+	}
+
+	if node.Commit != "" {
+		node.Commit, err = checkoutCommit(repo, node.Commit)
+		if err != nil {
+			return node, repoPath, err
+		}
+	}
+
+	err = installCustomNodeRequirements(env, repoPath, feedback)
+	return node, repoPath, err
+}
+
+// fetch a custom node and move it to its pinned commit, or to the head of its branch
+// returns the custom node as updated, and whether the checked out commit changed
+func updateCustomNodeRepo(env *kinda.Environment, repoPath string, node CustomNode, feedback kinda.CreateEnvironmentOptions) (CustomNode, bool, error) {
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return node, false, err
+	}
+	before, err := repo.Head()
+	if err != nil {
+		return node, false, err
+	}
+
+	err = repo.Fetch(&git.FetchOptions{RemoteName: "origin"})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return node, false, fmt.Errorf("error fetching: %v", err)
+	}
+
+	if node.Commit != "" {
+		node.Commit, err = checkoutCommit(repo, node.Commit)
+		if err != nil {
+			return node, false, err
+		}
+	} else {
+		if node.Branch == "" {
+			if before.Name().IsBranch() {
+				node.Branch = before.Name().Short()
+			} else if node.Branch, err = remoteDefaultBranch(node.GitURL); err != nil {
+				return node, false, err
+			}
+		}
+		err = checkoutBranch(repo, node.Branch)
+		if err != nil {
+			return node, false, err
+		}
+		w, err := repo.Worktree()
+		if err != nil {
+			return node, false, err
+		}
+		err = w.Pull(&git.PullOptions{RemoteName: "origin", ReferenceName: plumbing.NewBranchReferenceName(node.Branch)})
+		if err != nil && err != git.NoErrAlreadyUpToDate {
+			return node, false, fmt.Errorf("error pulling: %v", err)
+		}
+	}
+
+	after, err := repo.Head()
+	if err != nil {
+		return node, false, err
+	}
+	if after.Hash() == before.Hash() {
+		return node, false, nil
+	}
+	err = installCustomNodeRequirements(env, repoPath, feedback)
+	return node, true, err
+}
+
+// get the state of a custom node repository
+func customNodeStatus(repoPath string) CustomNodeStatus {
+	status := CustomNodeStatus{Name: filepath.Base(repoPath), Path: repoPath}
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	if remote, err := repo.Remote("origin"); err == nil && len(remote.Config().URLs) > 0 {
+		status.GitURL = remote.Config().URLs[0]
+	}
+	head, err := repo.Head()
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	if head.Name().IsBranch() {
+		status.Branch = head.Name().Short()
+	}
+	status.Commit = head.Hash().String()
+	return status
+}
+
+// get the path of the custom_nodes folder of the environment
+func (c *ComfyEnvironment) CustomNodesPath() string {
+	return filepath.Join(c.ComfyUIPath, "custom_nodes")
+}
+
+// find a recorded custom node by name, folder or git url
+func (c *ComfyEnvironment) FindCustomNode(name string) (CustomNode, bool) {
+	for _, v := range c.CustomNodes {
+		if v.Name == name || customNodeFolder(v.GitURL) == name || sameCustomNode(v, CustomNode{GitURL: name}) {
+			return v, true
+		}
+	}
+	return CustomNode{}, false
+}
+
+// get the state of every git repository in the custom_nodes folder
+func (c *ComfyEnvironment) ListCustomNodes() ([]CustomNodeStatus, error) {
+	entries, err := os.ReadDir(c.CustomNodesPath())
+	if err != nil {
+		return nil, err
+	}
+	retv := make([]CustomNodeStatus, 0)
+	for _, v := range entries {
+		if !v.IsDir() {
+			continue
+		}
+		repoPath := filepath.Join(c.CustomNodesPath(), v.Name())
+		if _, err := os.Stat(filepath.Join(repoPath, ".git")); err != nil {
+			// not a git repo
+			continue
+		}
+		status := customNodeStatus(repoPath)
+		if node, ok := c.FindCustomNode(v.Name()); ok {
+			status.Recorded = true
+			status.Name = node.Name
+		}
+		retv = append(retv, status)
+	}
+	return retv, nil
+}
+
+// move a custom node back to the branch and commit it was at
+func restoreCustomNode(repoPath string, status CustomNodeStatus) error {
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return err
+	}
+	if status.Branch == "" {
+		_, err = checkoutCommit(repo, status.Commit)
+		return err
+	}
+	err = checkoutBranch(repo, status.Branch)
+	if err != nil {
+		return err
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	return w.Reset(&git.ResetOptions{Commit: plumbing.NewHash(status.Commit), Mode: git.HardReset})
+}
+
+// find an installed custom node by name, folder or git url
+// returns the custom node and its folder in custom_nodes, or an empty folder if it is not installed
+func (c *ComfyEnvironment) InstalledCustomNode(name string) (CustomNode, string) {
+	if node, ok := c.FindCustomNode(name); ok {
+		folder := customNodeFolder(node.GitURL)
+		if _, err := os.Stat(filepath.Join(c.CustomNodesPath(), folder)); err == nil {
+			return node, folder
+		}
+		return node, ""
+	}
+
+	// custom nodes that were not recorded, such as those installed by ComfyUI-Manager
+	folder := name
+	if strings.Contains(name, "://") {
+		folder = customNodeFolder(name)
+	}
+	if folder == "" || strings.ContainsAny(folder, `/\`) {
+		return CustomNode{}, ""
+	}
+	status := customNodeStatus(filepath.Join(c.CustomNodesPath(), folder))
+	if status.Error != "" {
+		return CustomNode{}, ""
+	}
+	return CustomNode{Name: status.Name, GitURL: status.GitURL, Branch: status.Branch}, folder
+}
+
+// install a custom node into the environment and record it
+func (c *ComfyEnvironment) AddCustomNode(node CustomNode, feedback kinda.CreateEnvironmentOptions) (CustomNode, error) {
+	folder := customNodeFolder(node.GitURL)
+	if folder == "" {
+		return node, fmt.Errorf("invalid git url: %s", node.GitURL)
+	}
+	repoPath := filepath.Join(c.CustomNodesPath(), folder)
+	if _, err := os.Stat(repoPath); err == nil {
+		return node, fmt.Errorf("custom node %s already installed", folder)
+	}
+
+	// fail before cloning if the change can not be recorded
+	if _, err := c.environmentRecipe(); err != nil {
+		return node, err
+	}
+
+	node, _, err := installCustomNode(c.Environment, c.ComfyUIPath, node, feedback)
+	if err == nil {
+		err = c.RecordCustomNode(node, false)
+	}
+	if err != nil {
+		// don't leave a custom node behind that is not recorded
+		os.RemoveAll(repoPath)
+		return node, err
+	}
+	return node, nil
+}
+
+// remove a custom node from the environment and its records
+func (c *ComfyEnvironment) RemoveCustomNode(name string) (CustomNode, error) {
+	node, folder := c.InstalledCustomNode(name)
+	if folder == "" {
+		return node, fmt.Errorf("custom node %s not found", name)
+	}
+	_, recorded := c.FindCustomNode(name)
+
+	// record the removal first, so it can be recorded back if the custom node can not be removed
+	err := c.RecordCustomNode(node, true)
+	if err != nil {
+		return node, err
+	}
+	err = os.RemoveAll(filepath.Join(c.CustomNodesPath(), folder))
+	if err != nil {
+		if recorded {
+			if rerr := c.RecordCustomNode(node, false); rerr != nil {
+				return node, fmt.Errorf("%v, and the custom node could not be recorded back: %v", err, rerr)
+			}
+		}
+		return node, err
+	}
+	return node, nil
+}
+
+// update an installed custom node to the head of its branch, or to a commit, and record it
+// returns the custom node as updated, and whether the checked out commit changed
+func (c *ComfyEnvironment) UpdateCustomNode(name string, branch string, commit string, feedback kinda.CreateEnvironmentOptions) (CustomNode, bool, error) {
+	node, folder := c.InstalledCustomNode(name)
+	if folder == "" {
+		return node, false, fmt.Errorf("custom node %s not found", name)
+	}
+	if branch != "" {
+		node.Branch = branch
+	}
+	// without a commit, a pinned custom node moves back to the head of its branch
+	node.Commit = commit
+
+	// fail before updating if the change can not be recorded
+	if _, err := c.environmentRecipe(); err != nil {
+		return node, false, err
+	}
+
+	repoPath := filepath.Join(c.CustomNodesPath(), folder)
+	before := customNodeStatus(repoPath)
+	if before.Error != "" {
+		return node, false, errors.New(before.Error)
+	}
+	node, changed, err := updateCustomNodeRepo(c.Environment, repoPath, node, feedback)
+	if err == nil {
+		err = c.RecordCustomNode(node, false)
+	}
+	if err != nil {
+		after := customNodeStatus(repoPath)
+		if after.Commit != before.Commit || after.Branch != before.Branch {
+			if rerr := restoreCustomNode(repoPath, before); rerr != nil {
+				return node, false, fmt.Errorf("%v, and the custom node could not be restored: %v", err, rerr)
+			}
+		}
+		return node, false, err
+	}
+	return node, changed, nil
+}
+
+// get the recipe of the environment to record custom nodes into
+// environments without a recipe on disk get one rebuilt from what was recorded in kinda_env.json
+func (c *ComfyEnvironment) environmentRecipe() (*EnvRecipe, error) {
+	if c.RecipePath != "" {
+		recipe, err := RecipeFromPath(c.RecipePath)
+		if err == nil {
+			return recipe, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("error reading recipe %s: %v", c.RecipePath, err)
+		}
+	}
+
+	recipe := &EnvRecipe{
+		Name:          c.Name,
+		Description:   c.Description,
+		RecipeFormat:  CurrentRecipeFormat,
+		Version:       "1.0",
+		PythonVersion: c.PythonVersion,
+		Inherits:      []string{"default"},
+		CustomNodes:   append([]CustomNode{}, c.CustomNodes...),
+		ParamSets:     c.ParamSets,
+	}
+	if c.Channel != "" {
+		channel := c.Channel
+		recipe.Channel = &channel
+	}
+	return recipe, nil
+}
+
+// record a custom node into kinda_env.json and the recipe of the environment, or remove it
+func (c *ComfyEnvironment) RecordCustomNode(node CustomNode, remove bool) error {
+	recipe, err := c.environmentRecipe()
+	if err != nil {
+		return err
+	}
+	recipe.CustomNodes = setCustomNode(recipe.CustomNodes, node, remove)
+
+	// never modify a recipe outside of the environment, keep a copy with the environment instead
+	recipePath := c.RecipePath
+	if recipePath == "" || filepath.Dir(recipePath) != filepath.Clean(c.Environment.EnvPath) {
+		recipePath = path.Join(c.Environment.EnvPath, c.Name+".json")
+	}
+	previous, perr := os.ReadFile(recipePath)
+	err = recipe.WriteRecipe(recipePath, true)
+	if err != nil {
+		return err
+	}
+
+	oldRecipePath, oldNodes := c.RecipePath, c.CustomNodes
+	c.RecipePath = recipePath
+	c.CustomNodes = setCustomNode(c.CustomNodes, node, remove)
+	err = c.Save()
+	if err != nil {
+		// keep the recipe and kinda_env.json in agreement
+		c.RecipePath, c.CustomNodes = oldRecipePath, oldNodes
+		if perr == nil {
+			os.WriteFile(recipePath, previous, 0644)
+		} else {
+			os.Remove(recipePath)
+		}
+		return err
+	}
+	return nil
+}
+
+// replace, add or remove a custom node in a list of custom nodes
+func setCustomNode(nodes []CustomNode, node CustomNode, remove bool) []CustomNode {
+	retv := make([]CustomNode, 0, len(nodes)+1)
+	found := false
+	for _, v := range nodes {
+		if !sameCustomNode(v, node) {
+			retv = append(retv, v)
+			continue
+		}
+		if !remove && !found {
+			retv = append(retv, node)
+		}
+		found = true
+	}
+	if !remove && !found {
+		retv = append(retv, node)
+	}
+	return retv
+}
@@ -0,0 +1,183 @@
+package env
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	kinda "github.com/richinsley/kinda/pkg"
+)
+
+// create a git repository with one commit to install as a custom node
+func newTestNodeRepo(t *testing.T, name string) string {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), name)
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "__init__.py"), []byte("NODE_CLASS_MAPPINGS = {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Add("__init__.py"); err != nil {
+		t.Fatal(err)
+	}
+	sig := &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+	if _, err := w.Commit("initial", &git.CommitOptions{Author: sig}); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// create an environment as 'env create --recipe' leaves it, without python or ComfyUI
+func newTestEnvironment(t *testing.T, recipePath string, recipe *EnvRecipe) *ComfyEnvironment {
+	t.Helper()
+	envPath := filepath.Join(t.TempDir(), "envs", "myenv")
+	comfyFolder := filepath.Join(envPath, "comfyui")
+	if err := os.MkdirAll(filepath.Join(comfyFolder, "custom_nodes"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if recipe != nil {
+		recipePath = filepath.Join(envPath, recipePath)
+		if err := recipe.WriteRecipe(recipePath, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := &ComfyEnvironment{
+		Name:          "myenv",
+		RecipePath:    recipePath,
+		PythonVersion: "3.10",
+		ComfyUIPath:   comfyFolder,
+		Environment:   &kinda.Environment{Name: "myenv", EnvPath: envPath},
+	}
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// read kinda_env.json and the recipe of an environment back from disk
+func readTestEnvironment(t *testing.T, c *ComfyEnvironment) (*ComfyEnvironment, *EnvRecipe) {
+	t.Helper()
+	jdata, err := os.ReadFile(filepath.Join(c.Environment.EnvPath, "kinda_env.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := &ComfyEnvironment{}
+	if err := json.Unmarshal(jdata, saved); err != nil {
+		t.Fatal(err)
+	}
+	recipe, err := RecipeFromPath(saved.RecipePath)
+	if err != nil {
+		t.Fatalf("reading recipe %q: %v", saved.RecipePath, err)
+	}
+	return saved, recipe
+}
+
+func customNodeNames(nodes []CustomNode) []string {
+	names := make([]string, len(nodes))
+	for i, v := range nodes {
+		names[i] = v.Name
+	}
+	return names
+}
+
+func TestAddRemoveCustomNode(t *testing.T) {
+	named := &EnvRecipe{
+		Name:         "SD15",
+		RecipeFormat: CurrentRecipeFormat,
+		Version:      "1.0",
+		Inherits:     []string{"default"},
+		CustomNodes:  []CustomNode{{Name: "ComfyUI-Manager", GitURL: "https://github.com/ltdrdata/ComfyUI-Manager.git", Branch: "main"}},
+	}
+
+	tests := []struct {
+		name       string
+		recipePath string
+		recipe     *EnvRecipe
+		keep       []string // custom nodes of the recipe that must survive add and rm
+	}{
+		{"recipe in environment", "myenv.json", named, []string{"ComfyUI-Manager"}},
+		{"no recipe path", "", nil, []string{}},
+		{"missing recipe", "/nonexistent/SD15.json", nil, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestEnvironment(t, tt.recipePath, tt.recipe)
+			url := newTestNodeRepo(t, "ComfyUI_test")
+
+			node, err := c.AddCustomNode(CustomNode{GitURL: url}, kinda.ShowNothing)
+			if err != nil {
+				t.Fatalf("AddCustomNode: %v", err)
+			}
+			if node.Name != "ComfyUI_test" || node.Branch != "master" {
+				t.Errorf("added node = %+v, want name ComfyUI_test on master", node)
+			}
+			if _, err := os.Stat(filepath.Join(c.CustomNodesPath(), "ComfyUI_test", "__init__.py")); err != nil {
+				t.Errorf("custom node not cloned: %v", err)
+			}
+			saved, recipe := readTestEnvironment(t, c)
+			if filepath.Dir(saved.RecipePath) != c.Environment.EnvPath {
+				t.Errorf("recipe path = %q, want a copy in %q", saved.RecipePath, c.Environment.EnvPath)
+			}
+			if got := customNodeNames(saved.CustomNodes); len(got) != 1 || got[0] != "ComfyUI_test" {
+				t.Errorf("kinda_env.json custom nodes = %v", got)
+			}
+			want := append(append([]string{}, tt.keep...), "ComfyUI_test")
+			if got := customNodeNames(recipe.CustomNodes); len(got) != len(want) || got[len(got)-1] != "ComfyUI_test" {
+				t.Errorf("recipe custom nodes = %v, want %v", got, want)
+			}
+
+			if _, err := c.AddCustomNode(CustomNode{GitURL: url}, kinda.ShowNothing); err == nil {
+				t.Errorf("adding an installed custom node should fail")
+			}
+
+			if _, err := c.RemoveCustomNode("ComfyUI_test"); err != nil {
+				t.Fatalf("RemoveCustomNode: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(c.CustomNodesPath(), "ComfyUI_test")); !os.IsNotExist(err) {
+				t.Errorf("custom node folder not removed: %v", err)
+			}
+			saved, recipe = readTestEnvironment(t, c)
+			if len(saved.CustomNodes) != 0 {
+				t.Errorf("kinda_env.json custom nodes = %v, want none", customNodeNames(saved.CustomNodes))
+			}
+			if got := customNodeNames(recipe.CustomNodes); len(got) != len(tt.keep) {
+				t.Errorf("recipe custom nodes = %v, want %v", got, tt.keep)
+			}
+
+			if _, err := c.RemoveCustomNode("ComfyUI_test"); err == nil {
+				t.Errorf("removing a missing custom node should fail")
+			}
+		})
+	}
+}
+
+func TestAddCustomNodeRollback(t *testing.T) {
+	// a recipe that can not be read must fail the add before anything is cloned
+	c := newTestEnvironment(t, "", nil)
+	c.RecipePath = filepath.Join(c.Environment.EnvPath, "broken.json")
+	if err := os.WriteFile(c.RecipePath, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	url := newTestNodeRepo(t, "ComfyUI_test")
+
+	if _, err := c.AddCustomNode(CustomNode{GitURL: url}, kinda.ShowNothing); err == nil {
+		t.Fatalf("AddCustomNode should fail with a broken recipe")
+	}
+	if _, err := os.Stat(filepath.Join(c.CustomNodesPath(), "ComfyUI_test")); !os.IsNotExist(err) {
+		t.Errorf("custom node was left behind: %v", err)
+	}
+	if len(c.CustomNodes) != 0 {
+		t.Errorf("custom node was recorded: %v", customNodeNames(c.CustomNodes))
+	}
+}
@@ -89,6 +89,7 @@ type CustomNode struct {
 	Name   string `json:"name"`
 	GitURL string `json:"git_url"`
 	Branch string `json:"branch,omitempty"`
+	Commit string `json:"commit,omitempty"`
 }
 
 // based off of ComfyUI-Manager format
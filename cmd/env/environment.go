@@ -33,6 +33,8 @@ type ComfyEnvironment struct {
 	ParamSets map[string][]string `json:"paramsets,omitempty"`
 	// Using shared models
 	SharedModels bool `json:"SharedModels,omitempty"`
+	// custom nodes installed in the environment
+	CustomNodes []CustomNode `json:"CustomNodes,omitempty"`
 }
 
 func GetComfyEnvironments() ([]string, error) {
@@ -161,27 +163,18 @@ func NewComfyEnvironmentFromRecipe(name string, recipe *EnvRecipe, recipePath st
 	}
 
 	// install custom nodes if specified
+	customNodes := make([]CustomNode, 0)
 	if recipe.CustomNodes != nil {
 		for _, v := range recipe.CustomNodes {
 			if feedback != kinda.ShowNothing {
 				fmt.Printf("Installing Custom Node: %v\n", v.Name)
 			}
-			repo, repoPath, err := kinda.NewGitRepo(v.GitURL, filepath.Join(comfyFolder, "custom_nodes"), v.Branch)
+			node, _, err := installCustomNode(env, comfyFolder, v, feedback)
 			if err != nil {
-				fmt.Printf("Error cloning custom node: %v\n", err)
+				fmt.Printf("Error installing custom node: %v\n", err)
 				return nil, err
 			}
-			fmt.Println(repo)
-
-			// install the pip requirements if requirements.txt exists
-			customReqPath := path.Join(repoPath, "requirements.txt")
-			if _, err := os.Stat(customReqPath); err == nil {
-				err = env.PipInstallRequirmements(customReqPath, feedback)
-				if err != nil {
-					fmt.Printf("Error installing requirements: %v\n", err)
-					return nil, err
-				}
-			}
+			customNodes = append(customNodes, node)
 		}
 	}
 
@@ -308,20 +301,15 @@ func NewComfyEnvironmentFromRecipe(name string, recipe *EnvRecipe, recipePath st
 		ComfyUIPath:   comfyFolder,
 		ParamSets:     recipe.ParamSets,
 		SharedModels:  useshared,
+		CustomNodes:   customNodes,
 	}
 
 	if recipe.Channel != nil {
 		retv.Channel = *recipe.Channel
 	}
 
-	// write the environment to disk as a json file
-	jdata, err := util.ToJson(retv, true)
-	if err != nil {
-		return nil, err
-	}
-
 	// write the environment to disk
-	err = os.WriteFile(envfile, []byte(jdata), 0644)
+	err = retv.Save()
 	if err != nil {
 		return nil, err
 	}
@@ -329,6 +317,15 @@ func NewComfyEnvironmentFromRecipe(name string, recipe *EnvRecipe, recipePath st
 	return retv, nil
 }
 
+// write the environment descriptor to kinda_env.json
+func (c *ComfyEnvironment) Save() error {
+	jdata, err := util.ToJson(c, true)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(c.Environment.EnvPath, "kinda_env.json"), []byte(jdata), 0644)
+}
+
 func (c *ComfyEnvironment) DeleteEnvironment() error {
 	// delete the environment
 	err := os.RemoveAll(c.Environment.EnvPath)
@@ -0,0 +1,232 @@
+/*
+Copyright © 2024 Rich Insley <richinsley@gmail.com>
+*/
+package env
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	util "github.com/richinsley/comfycli/pkg"
+	kinda "github.com/richinsley/kinda/pkg"
+	"github.com/spf13/cobra"
+)
+
+var nodeEnvName string
+var nodeBranch string
+var nodeCommit string
+
+// nodeCmd represents the node command
+var nodeCmd = &cobra.Command{
+	Use:   "node",
+	Short: "Manage the custom nodes of an environment",
+	Long: `Manage the custom nodes of an environment, without a running ComfyUI.
+	Changes are recorded into the environment and its recipe.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := cmd.Help(); err != nil {
+			slog.Error("Error", "error", err)
+		}
+		os.Exit(1)
+	},
+}
+
+// nodeAddCmd represents the node add command
+var nodeAddCmd = &cobra.Command{
+	Use:   "add [git url]...",
+	Short: "Install custom nodes into an environment",
+	Long: `Clone custom nodes into an environment and install their pip requirements.
+	examples:
+	# install a custom node into the myenv environment
+	comfycli env node add --env myenv https://github.com/cubiq/ComfyUI_essentials
+
+	# install a custom node at a given commit
+	comfycli env node add --env myenv --branch main --commit 1a2b3c4 https://github.com/cubiq/ComfyUI_essentials`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			if err := cmd.Help(); err != nil {
+				slog.Error("Error", "error", err)
+			}
+			os.Exit(1)
+		}
+		env := nodeEnvironment()
+
+		for _, url := range args {
+			fmt.Printf("Installing Custom Node: %v\n", url)
+			node, err := env.AddCustomNode(CustomNode{GitURL: url, Branch: nodeBranch, Commit: nodeCommit}, kinda.ShowProgressBar)
+			if err != nil {
+				slog.Error("error installing custom node", "url", url, "error", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Installed %s (%s) into %s\n", node.Name, node.Branch, env.Name)
+		}
+	},
+}
+
+// nodeRmCmd represents the node rm command
+var nodeRmCmd = &cobra.Command{
+	Use:   "rm [name or git url]...",
+	Short: "Remove custom nodes from an environment",
+	Long: `Remove custom nodes from an environment.  Pip packages installed for the custom nodes are not removed.
+	examples:
+	comfycli env node rm --env myenv ComfyUI_essentials`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			if err := cmd.Help(); err != nil {
+				slog.Error("Error", "error", err)
+			}
+			os.Exit(1)
+		}
+		env := nodeEnvironment()
+
+		for _, name := range args {
+			node, err := env.RemoveCustomNode(name)
+			if err != nil {
+				slog.Error("error removing custom node", "node", name, "error", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Removed %s from %s\n", node.Name, env.Name)
+		}
+	},
+}
+
+// nodeLsCmd represents the node ls command
+var nodeLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List the custom nodes of an environment",
+	Long: `List the custom nodes of an environment, with their git url, branch and commit.
+	examples:
+	comfycli env node ls --env myenv`,
+	Run: func(cmd *cobra.Command, args []string) {
+		env := nodeEnvironment()
+		nodes, err := env.ListCustomNodes()
+		if err != nil {
+			slog.Error("error listing custom nodes", "error", err)
+			os.Exit(1)
+		}
+
+		if CLIOptions.Json {
+			j, _ := util.ToJson(nodes, CLIOptions.PrettyJson)
+			fmt.Println(j)
+			return
+		}
+		for _, v := range nodes {
+			if v.Error != "" {
+				fmt.Printf("%s: %s\n", v.Name, v.Error)
+				continue
+			}
+			ref := v.Branch
+			if ref == "" {
+				ref = "detached"
+			}
+			recorded := ""
+			if !v.Recorded {
+				recorded = " (not recorded)"
+			}
+			fmt.Printf("%s\t%s\t%s\t%s%s\n", v.Name, v.GitURL, ref, shortCommit(v.Commit), recorded)
+		}
+	},
+}
+
+// nodeUpdateCmd represents the node update command
+var nodeUpdateCmd = &cobra.Command{
+	Use:   "update [name or git url]...",
+	Short: "Update the custom nodes of an environment",
+	Long: `Update custom nodes to the head of their branch, or to a commit with --commit, and install their pip requirements.
+	Every custom node is updated when none are named.
+	examples:
+	# update every custom node of myenv
+	comfycli env node update --env myenv
+
+	# move a custom node to a commit
+	comfycli env node update --env myenv --commit 1a2b3c4 ComfyUI_essentials`,
+	Run: func(cmd *cobra.Command, args []string) {
+		env := nodeEnvironment()
+		if nodeCommit != "" && len(args) != 1 {
+			slog.Error("--commit requires exactly one custom node")
+			os.Exit(1)
+		}
+
+		if len(args) == 0 {
+			nodes, err := env.ListCustomNodes()
+			if err != nil {
+				slog.Error("error listing custom nodes", "error", err)
+				os.Exit(1)
+			}
+			for _, v := range nodes {
+				args = append(args, filepath.Base(v.Path))
+			}
+		}
+
+		failed := false
+		for _, name := range args {
+			fmt.Printf("Updating custom node: %s\n", name)
+			node, changed, err := env.UpdateCustomNode(name, nodeBranch, nodeCommit, kinda.ShowProgressBar)
+			if err != nil {
+				slog.Error("error updating custom node", "node", name, "error", err)
+				failed = true
+				continue
+			}
+			if changed {
+				_, folder := env.InstalledCustomNode(name)
+				fmt.Printf("Updated %s to %s\n", node.Name, shortCommit(customNodeStatus(filepath.Join(env.CustomNodesPath(), folder)).Commit))
+			} else {
+				fmt.Printf("Custom node %s already up-to-date\n", node.Name)
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// load the environment named by --env, or the only environment
+func nodeEnvironment() *ComfyEnvironment {
+	name := nodeEnvName
+	if name == "" {
+		envlist, err := GetComfyEnvironments()
+		if err != nil {
+			slog.Error("error getting environment list", "error", err)
+			os.Exit(1)
+		}
+		if len(envlist) != 1 {
+			slog.Error("error: no environment specified, use --env")
+			os.Exit(1)
+		}
+		name = envlist[0]
+	}
+
+	env, err := NewComfyEnvironmentFromExisting(name)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "environment not found") {
+			fmt.Printf("Environment '%s' not found.  Create new environment with 'comfycli env create %s'\n", name, name)
+		} else {
+			slog.Error("error getting environment", "error", err)
+		}
+		os.Exit(1)
+	}
+	return env
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
+
+func InitNode(envCmd *cobra.Command) {
+	envCmd.AddCommand(nodeCmd)
+	nodeCmd.AddCommand(nodeAddCmd)
+	nodeCmd.AddCommand(nodeRmCmd)
+	nodeCmd.AddCommand(nodeLsCmd)
+	nodeCmd.AddCommand(nodeUpdateCmd)
+
+	nodeCmd.PersistentFlags().StringVar(&nodeEnvName, "env", "", "Environment to manage, defaults to the only environment")
+	nodeAddCmd.Flags().StringVar(&nodeBranch, "branch", "", "Branch to clone, defaults to the default branch of the repository")
+	nodeAddCmd.Flags().StringVar(&nodeCommit, "commit", "", "Commit to checkout")
+	nodeUpdateCmd.Flags().StringVar(&nodeBranch, "branch", "", "Branch to follow")
+	nodeUpdateCmd.Flags().StringVar(&nodeCommit, "commit", "", "Commit to checkout")
+}
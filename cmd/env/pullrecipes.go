@@ -118,7 +118,7 @@ func PullRecipeRepoManifest(manifesturl string) (*RecipeRepoManifest, error) {
 func pullRecipesFromManifestURL(manifesturl string) {
 	manifest, err := PullRecipeRepoManifest(manifesturl)
 	if err != nil {
-		slog.Error("error: %v", err)
+		slog.Error("error pulling recipe manifest", "error", err)
 		os.Exit(1)
 	}
 
@@ -135,16 +135,16 @@ func pullRecipesFromManifestURL(manifesturl string) {
 		// decode the existing file
 		repofile, err := os.Open(repofilepath)
 		if err != nil {
-			slog.Warn("error opening existing repo file: %v", err)
+			slog.Warn("error opening existing repo file", "error", err)
 		} else {
 			repodata, err := io.ReadAll(repofile)
 			if err != nil {
-				slog.Warn("error reading existing repo file: %v", err)
+				slog.Warn("error reading existing repo file", "error", err)
 			} else {
 				var existingRepo RecipeRepoManifest
 				err = json.Unmarshal(repodata, &existingRepo)
 				if err != nil {
-					slog.Warn("error parsing existing repo file: %v", err)
+					slog.Warn("error parsing existing repo file", "error", err)
 				} else {
 					// check if they are from the same origin
 					if existingRepo.Origin == manifest.Origin {
@@ -174,15 +174,15 @@ func pullRecipesFromManifestURL(manifesturl string) {
 						// write the updated manifest back to the file
 						repofile, err := os.Create(repofilepath)
 						if err != nil {
-							slog.Warn("error creating repo file: %v", err)
+							slog.Warn("error creating repo file", "error", err)
 						} else {
 							repodata, err := json.Marshal(existingRepo)
 							if err != nil {
-								slog.Warn("error marshalling repo data: %v", err)
+								slog.Warn("error marshalling repo data", "error", err)
 							} else {
 								_, err = repofile.Write(repodata)
 								if err != nil {
-									slog.Warn("error writing repo data: %v", err)
+									slog.Warn("error writing repo data", "error", err)
 								}
 							}
 						}
@@ -201,15 +201,15 @@ func pullRecipesFromManifestURL(manifesturl string) {
 						// write the new manifest to the file
 						repofile, err := os.Create(repofilepath)
 						if err != nil {
-							slog.Warn("error creating repo file: %v", err)
+							slog.Warn("error creating repo file", "error", err)
 						} else {
 							repodata, err := json.Marshal(manifest)
 							if err != nil {
-								slog.Warn("error marshalling repo data: %v", err)
+								slog.Warn("error marshalling repo data", "error", err)
 							} else {
 								_, err = repofile.Write(repodata)
 								if err != nil {
-									slog.Warn("error writing repo data: %v", err)
+									slog.Warn("error writing repo data", "error", err)
 								}
 							}
 						}
@@ -221,15 +221,15 @@ func pullRecipesFromManifestURL(manifesturl string) {
 		// write the new manifest to the file
 		repofile, err := os.Create(repofilepath)
 		if err != nil {
-			slog.Warn("error creating repo file: %v", err)
+			slog.Warn("error creating repo file", "error", err)
 		} else {
 			repodata, err := json.Marshal(manifest)
 			if err != nil {
-				slog.Warn("error marshalling repo data: %v", err)
+				slog.Warn("error marshalling repo data", "error", err)
 			} else {
 				_, err = repofile.Write(repodata)
 				if err != nil {
-					slog.Warn("error writing repo data: %v", err)
+					slog.Warn("error writing repo data", "error", err)
 				}
 			}
 		}
@@ -239,18 +239,18 @@ func pullRecipesFromManifestURL(manifesturl string) {
 	// open and decode the manifest file
 	repofile, err := os.Open(repofilepath)
 	if err != nil {
-		slog.Error("error opening repo file: %v", err)
+		slog.Error("error opening repo file", "error", err)
 		os.Exit(1)
 	}
 	repodata, err := io.ReadAll(repofile)
 	if err != nil {
-		slog.Error("error reading repo file: %v", err)
+		slog.Error("error reading repo file", "error", err)
 		os.Exit(1)
 	}
 	var repo RecipeRepoManifest
 	err = json.Unmarshal(repodata, &repo)
 	if err != nil {
-		slog.Error("error parsing repo file: %v", err)
+		slog.Error("error parsing repo file", "error", err)
 		os.Exit(1)
 	}
 
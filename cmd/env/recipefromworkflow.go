@@ -0,0 +1,104 @@
+/*
+Copyright © 2024 Rich Insley <richinsley@gmail.com>
+*/
+package env
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	util "github.com/richinsley/comfycli/pkg"
+	"github.com/spf13/cobra"
+)
+
+var recipeFromWorkflowName string
+var recipeFromWorkflowOutput string
+var recipeFromWorkflowDatabases []string
+
+// recipeFromWorkflowCmd
+var recipeFromWorkflowCmd = &cobra.Command{
+	Use:   "recipe-from-workflow [workflow file path]",
+	Short: "Create a recipe with the custom nodes and models of a workflow",
+	Long: `Create a recipe with the custom nodes and models of a workflow.
+	The node types and model files of the workflow are read from the workflow alone, and resolved to custom node repos and model downloads
+	with the repos and model urls the workflow records, the local recipes, and ComfyUI-Manager style database files given with --db.
+	The recipe inherits the default recipe, and is written to stdout unless --output is given.
+
+	examples:
+	# write the recipe of a workflow to stdout
+	comfycli env recipe-from-workflow wf.json
+
+	# resolve the nodes with the node map of ComfyUI-Manager, write the recipe and create an environment from it
+	comfycli env recipe-from-workflow wf.json --db https://raw.githubusercontent.com/ltdrdata/ComfyUI-Manager/main/extension-node-map.json -o wf_recipe.json
+	comfycli env create --file wf_recipe.json --name wf`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			if err := cmd.Help(); err != nil {
+				slog.Error("Error", "error", err)
+			}
+			os.Exit(1)
+		}
+		workflowPath := args[0]
+
+		req, err := util.GetWorkflowRequirements(workflowPath)
+		if err != nil {
+			slog.Error("error reading workflow", "error", err)
+			os.Exit(1)
+		}
+
+		resolver, err := NewResolver(recipeFromWorkflowDatabases)
+		if err != nil {
+			slog.Error("error loading resolver", "error", err)
+			os.Exit(1)
+		}
+		resolver.AddWorkflow(req)
+
+		name := recipeFromWorkflowName
+		if name == "" {
+			name = strings.TrimSuffix(filepath.Base(workflowPath), filepath.Ext(workflowPath))
+		}
+		res := resolver.Resolve(name, req.NodeTypes, req.Models)
+
+		if recipeFromWorkflowOutput != "" {
+			if err := res.Recipe.WriteRecipe(recipeFromWorkflowOutput, true); err != nil {
+				slog.Error("error writing recipe", "error", err)
+				os.Exit(1)
+			}
+		}
+
+		if CLIOptions.Json {
+			j, _ := util.ToJson(res, CLIOptions.PrettyJson)
+			fmt.Println(j)
+		} else if recipeFromWorkflowOutput == "" {
+			j, _ := util.ToJson(res.Recipe, true)
+			fmt.Println(j)
+		} else {
+			fmt.Printf("recipe %s written to %s with %d custom nodes and %d models\n", name, recipeFromWorkflowOutput, len(res.Recipe.CustomNodes), len(res.Recipe.Models))
+		}
+
+		// what is left over goes to stderr, so the recipe can be redirected to a file
+		for t, repos := range res.Ambiguous {
+			slog.Warn("node type is provided by several repos", "node", t, "repos", strings.Join(repos, ", "))
+		}
+		for m, folders := range res.AmbiguousModels {
+			slog.Warn("model file is in several folders", "model", m, "folders", strings.Join(folders, ", "))
+		}
+		if len(res.UnresolvedNodes) > 0 {
+			slog.Warn("no repo found for nodes, they may be ComfyUI core nodes", "nodes", strings.Join(res.UnresolvedNodes, ", "))
+		}
+		if len(res.UnresolvedModels) > 0 {
+			slog.Warn("no download found for models", "models", strings.Join(res.UnresolvedModels, ", "))
+		}
+	},
+}
+
+func InitRecipeFromWorkflow(envCmd *cobra.Command) {
+	envCmd.AddCommand(recipeFromWorkflowCmd)
+
+	recipeFromWorkflowCmd.Flags().StringVar(&recipeFromWorkflowName, "name", "", "Name of the recipe, the name of the workflow file when empty")
+	recipeFromWorkflowCmd.Flags().StringVarP(&recipeFromWorkflowOutput, "output", "o", "", "Path to write the recipe to")
+	recipeFromWorkflowCmd.Flags().StringSliceVar(&recipeFromWorkflowDatabases, "db", nil, "ComfyUI-Manager style database file or url to resolve nodes and models with (extension-node-map.json, custom-node-list.json, model-list.json)")
+}
@@ -0,0 +1,321 @@
+package env
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+
+	util "github.com/richinsley/comfycli/pkg"
+)
+
+// Resolver maps node types to the custom node repos that provide them, and model filenames to
+// the models that download them.  It is built from the recipes on disk and from ComfyUI-Manager
+// style database files.
+type Resolver struct {
+	// node types to repos
+	Mappings *util.NodeMappings
+	// custom nodes by normalized git url
+	nodes map[string]CustomNode
+	// recipes installing a custom node by normalized git url
+	nodeRecipes map[string][]string
+	// models by folder and filename
+	models map[string]Models
+	// model folders by filename
+	modelFolders map[string][]string
+	// node types to the repo or comfy registry id a workflow records for them
+	repos map[string]string
+	packs map[string]string
+}
+
+// Resolution is a recipe that installs the custom nodes and models a workflow needs, and what
+// could not be resolved
+type Resolution struct {
+	Recipe           *EnvRecipe          `json:"recipe"`
+	UnresolvedNodes  []string            `json:"unresolved_nodes,omitempty"`
+	UnresolvedModels []string            `json:"unresolved_models,omitempty"`
+	Ambiguous        map[string][]string `json:"ambiguous,omitempty"`        // node types provided by several repos
+	AmbiguousModels  map[string][]string `json:"ambiguous_models,omitempty"` // model files in several folders
+}
+
+// coreRepo is the repo of the ComfyUI nodes, which every environment has
+const coreRepo = "github.com/comfyanonymous/comfyui"
+
+// NewResolver returns a resolver that knows the custom nodes and models of the recipes on disk
+// and of the database files.  A database file can be a path or a url of the
+// extension-node-map.json, custom-node-list.json or model-list.json files of ComfyUI-Manager.
+func NewResolver(databases []string) (*Resolver, error) {
+	r := &Resolver{
+		Mappings:     util.NewNodeMappings(),
+		nodes:        make(map[string]CustomNode),
+		nodeRecipes:  make(map[string][]string),
+		models:       make(map[string]Models),
+		modelFolders: make(map[string][]string),
+		repos:        make(map[string]string),
+		packs:        make(map[string]string),
+	}
+	if CLIOptions.RecipesPath != "" {
+		r.indexRecipes()
+	}
+	for _, db := range databases {
+		if err := r.AddDatabase(db); err != nil {
+			return nil, fmt.Errorf("database %s: %v", db, err)
+		}
+	}
+	return r, nil
+}
+
+// indexRecipes adds the custom nodes and models of every recipe on disk
+func (r *Resolver) indexRecipes() {
+	names, err := GetRecipeList(CLIOptions.RecipesPath)
+	if err != nil {
+		slog.Debug("error getting recipe list", "error", err)
+		return
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p, err := RecipePathFromName(name)
+		if err != nil {
+			continue
+		}
+		recipe, err := RecipeFromPath(p)
+		if err != nil {
+			slog.Warn("error reading recipe", "recipe", name, "error", err)
+			continue
+		}
+		for _, c := range recipe.CustomNodes {
+			url := util.NormalizeRepoURL(c.GitURL)
+			if _, ok := r.nodes[url]; !ok {
+				r.nodes[url] = c
+			}
+			r.nodeRecipes[url] = append(r.nodeRecipes[url], name)
+		}
+		for _, m := range recipe.Models {
+			r.addModel(m, false)
+		}
+	}
+}
+
+// AddDatabase adds the node mappings, custom nodes or models of a ComfyUI-Manager style
+// database file
+func (r *Resolver) AddDatabase(db string) error {
+	data, err := fetchData(db)
+	if err != nil {
+		return err
+	}
+	var lists struct {
+		CustomNodes []struct {
+			Title       string   `json:"title"`
+			Reference   string   `json:"reference"`
+			Files       []string `json:"files"`
+			InstallType string   `json:"install_type"`
+		} `json:"custom_nodes"`
+		Models []Models `json:"models"`
+	}
+	if err := json.Unmarshal([]byte(data), &lists); err == nil && (lists.CustomNodes != nil || lists.Models != nil) {
+		for _, c := range lists.CustomNodes {
+			if c.InstallType != "git-clone" || len(c.Files) == 0 {
+				continue
+			}
+			url := util.NormalizeRepoURL(c.Files[0])
+			if _, ok := r.nodes[url]; !ok {
+				r.nodes[url] = CustomNode{Name: c.Title, GitURL: c.Files[0]}
+			}
+		}
+		for _, m := range lists.Models {
+			// ComfyUI-Manager names model types rather than folders, such as "checkpoint" or "VAE"
+			m.Type = util.ModelFolder(strings.ToLower(m.Type))
+			r.addModel(m, false)
+		}
+		return nil
+	}
+
+	mappings, err := util.ParseNodeMappings([]byte(data))
+	if err != nil {
+		return err
+	}
+	r.Mappings.Merge(mappings)
+	return nil
+}
+
+// modelFolder returns the model folder a model downloads to
+func modelFolder(m Models) string {
+	if m.SavePath == "default" || m.SavePath == "" {
+		return m.Type
+	}
+	return strings.SplitN(path.Clean(strings.ReplaceAll(m.SavePath, "\\", "/")), "/", 2)[0]
+}
+
+// addModel adds a model, keeping the first model of a file in a folder unless replace is set
+func (r *Resolver) addModel(m Models, replace bool) {
+	if m.Filename == "" || m.URL == "" {
+		return
+	}
+	folder := modelFolder(m)
+	key := folder + "/" + m.Filename
+	if _, ok := r.models[key]; !ok {
+		r.modelFolders[m.Filename] = append(r.modelFolders[m.Filename], folder)
+	} else if !replace {
+		return
+	}
+	r.models[key] = m
+}
+
+// AddWorkflow adds the node repos, node packs and model urls a workflow records.  They are
+// preferred over the recipes and databases.
+func (r *Resolver) AddWorkflow(req *util.WorkflowRequirements) {
+	for t, repo := range req.Repos {
+		r.repos[t] = repo
+	}
+	for t, pack := range req.Packs {
+		r.packs[t] = pack
+	}
+	for _, m := range req.Models {
+		if m.URL == "" || m.Directory == "" {
+			continue
+		}
+		name := strings.ReplaceAll(m.Name, "\\", "/")
+		model := Models{
+			Name:     path.Base(name),
+			Type:     m.Directory,
+			SavePath: "default",
+			Filename: path.Base(name),
+			URL:      m.URL,
+		}
+		// models in a sub folder of the model folder
+		if dir := path.Dir(name); dir != "." {
+			model.SavePath = path.Join(m.Directory, dir)
+		}
+		r.addModel(model, true)
+	}
+}
+
+// RecipesByRepo returns the recipes that install each custom node repo, by normalized git url
+func (r *Resolver) RecipesByRepo() map[string][]string {
+	return r.nodeRecipes
+}
+
+// CustomNode returns the custom node that installs a repo
+func (r *Resolver) CustomNode(repo string) CustomNode {
+	if c, ok := r.nodes[util.NormalizeRepoURL(repo)]; ok {
+		return c
+	}
+	name := strings.TrimSuffix(path.Base(strings.TrimSuffix(repo, "/")), ".git")
+	return CustomNode{Name: name, GitURL: repo}
+}
+
+// Model returns the model that downloads a model file to a folder.  The value of a combo may
+// name the file in a sub folder of the model folder.  When the folder is not known, the file
+// is looked up in every folder, and the folders having the file are returned as well.
+func (r *Resolver) Model(folder string, value string) (Models, []string, bool) {
+	filename := path.Base(strings.ReplaceAll(value, "\\", "/"))
+	if folder != "" {
+		m, ok := r.models[folder+"/"+filename]
+		return m, nil, ok
+	}
+	folders := r.modelFolders[filename]
+	if len(folders) == 0 {
+		return Models{}, nil, false
+	}
+	return r.models[folders[0]+"/"+filename], folders, true
+}
+
+// Resolve returns a recipe that installs the repos providing the node types and the model
+// files.  Models without a directory are looked up in every model folder.  The recipe inherits the default recipe, so an environment created from it has
+// ComfyUI and the custom nodes of the default recipe as well.
+func (r *Resolver) Resolve(name string, nodeTypes []string, models []util.WorkflowModel) *Resolution {
+	res := &Resolution{
+		Recipe: &EnvRecipe{
+			Name:         name,
+			Description:  fmt.Sprintf("Custom nodes and models of %s", name),
+			RecipeFormat: CurrentRecipeFormat,
+			Version:      "1.0",
+			Inherits:     []string{"default"},
+			CustomNodes:  make([]CustomNode, 0),
+			Models:       make([]Models, 0),
+		},
+		UnresolvedNodes:  make([]string, 0),
+		UnresolvedModels: make([]string, 0),
+	}
+
+	added := make(map[string]bool)
+	for _, t := range nodeTypes {
+		repos := r.Mappings.Repos(t)
+		if repo, ok := r.repos[t]; ok {
+			repos = []string{repo}
+		} else if repo, ok := r.packRepo(r.packs[t]); ok && len(repos) == 0 {
+			repos = []string{repo}
+		}
+		if len(repos) == 0 {
+			res.UnresolvedNodes = append(res.UnresolvedNodes, t)
+			continue
+		}
+		repo := r.preferredRepo(repos)
+		url := util.NormalizeRepoURL(repo)
+		if url == coreRepo {
+			continue
+		}
+		if len(repos) > 1 {
+			if res.Ambiguous == nil {
+				res.Ambiguous = make(map[string][]string)
+			}
+			res.Ambiguous[t] = repos
+		}
+		if !added[url] {
+			added[url] = true
+			res.Recipe.CustomNodes = append(res.Recipe.CustomNodes, r.CustomNode(repo))
+		}
+	}
+
+	for _, wm := range models {
+		m, folders, ok := r.Model(wm.Directory, wm.Name)
+		if !ok {
+			res.UnresolvedModels = append(res.UnresolvedModels, wm.Name)
+			continue
+		}
+		if len(folders) > 1 {
+			if res.AmbiguousModels == nil {
+				res.AmbiguousModels = make(map[string][]string)
+			}
+			res.AmbiguousModels[wm.Name] = folders
+		}
+		key := "model:" + modelFolder(m) + "/" + m.Filename
+		if !added[key] {
+			added[key] = true
+			res.Recipe.Models = append(res.Recipe.Models, m)
+		}
+	}
+	return res
+}
+
+// packRepo returns the repo of a known custom node whose name is a comfy registry id, which
+// usually is the repo name in lower case
+func (r *Resolver) packRepo(pack string) (string, bool) {
+	if pack == "" {
+		return "", false
+	}
+	for url, c := range r.nodes {
+		if strings.EqualFold(path.Base(url), pack) || strings.EqualFold(c.Name, pack) {
+			return c.GitURL, true
+		}
+	}
+	return "", false
+}
+
+// preferredRepo picks the repo of a node type when several provide it, preferring the core
+// nodes, then repos a recipe installs
+func (r *Resolver) preferredRepo(repos []string) string {
+	for _, repo := range repos {
+		if util.NormalizeRepoURL(repo) == coreRepo {
+			return repo
+		}
+	}
+	for _, repo := range repos {
+		if len(r.nodeRecipes[util.NormalizeRepoURL(repo)]) > 0 {
+			return repo
+		}
+	}
+	return repos[0]
+}
@@ -0,0 +1,105 @@
+package env
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	util "github.com/richinsley/comfycli/pkg"
+)
+
+const testModelList = `{"models": [
+	{"name": "sd15", "type": "checkpoint", "base": "SD1.5", "save_path": "default", "filename": "v1-5.safetensors", "url": "https://example.com/v1-5.safetensors"},
+	{"name": "detail", "type": "lora", "base": "SD1.5", "save_path": "default", "filename": "detail.safetensors", "url": "https://example.com/detail.safetensors"},
+	{"name": "esrgan", "type": "upscale", "base": "upscale", "save_path": "default", "filename": "4x.pth", "url": "https://example.com/4x.pth"},
+	{"name": "taesd", "type": "TAESD", "base": "SD1.x", "save_path": "vae_approx", "filename": "taesd_decoder.pth", "url": "https://example.com/taesd_decoder.pth"},
+	{"name": "sdxl vae", "type": "VAE", "base": "SDXL", "save_path": "default", "filename": "diffusion_pytorch_model.safetensors", "url": "https://example.com/vae"},
+	{"name": "sdxl unet", "type": "unet", "base": "SDXL", "save_path": "default", "filename": "diffusion_pytorch_model.safetensors", "url": "https://example.com/unet"}
+]}`
+
+func newTestResolver(t *testing.T) *Resolver {
+	t.Helper()
+	CLIOptions = &util.ComfyOptions{}
+	db := filepath.Join(t.TempDir(), "model-list.json")
+	if err := os.WriteFile(db, []byte(testModelList), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewResolver([]string{db})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestResolverManagerModelTypes(t *testing.T) {
+	r := newTestResolver(t)
+	tests := []struct {
+		file   string
+		folder string
+	}{
+		{"v1-5.safetensors", "checkpoints"},
+		{"SD1.5/detail.safetensors", "loras"},
+		{"4x.pth", "upscale_models"},
+		{"taesd_decoder.pth", "vae_approx"},
+	}
+	for _, tt := range tests {
+		m, _, ok := r.Model("", tt.file)
+		if !ok {
+			t.Errorf("model %s not found", tt.file)
+			continue
+		}
+		if folder := modelFolder(m); folder != tt.folder {
+			t.Errorf("model %s downloads to %s, want %s", tt.file, folder, tt.folder)
+		}
+	}
+}
+
+func TestResolverModelFolders(t *testing.T) {
+	r := newTestResolver(t)
+	const name = "diffusion_pytorch_model.safetensors"
+
+	m, _, ok := r.Model("diffusion_models", name)
+	if !ok || m.URL != "https://example.com/unet" {
+		t.Errorf("diffusion_models/%s = %+v, want the unet", name, m)
+	}
+	m, _, ok = r.Model("vae", name)
+	if !ok || m.URL != "https://example.com/vae" {
+		t.Errorf("vae/%s = %+v, want the vae", name, m)
+	}
+	if _, _, ok := r.Model("loras", name); ok {
+		t.Errorf("loras/%s should not resolve", name)
+	}
+
+	res := r.Resolve("test", nil, []util.WorkflowModel{
+		{Name: name},
+		{Name: "v1-5.safetensors", Directory: "checkpoints"},
+		{Name: "missing.safetensors"},
+	})
+	if want := []string{"vae", "diffusion_models"}; !reflect.DeepEqual(res.AmbiguousModels[name], want) {
+		t.Errorf("ambiguous folders of %s = %v, want %v", name, res.AmbiguousModels[name], want)
+	}
+	if len(res.Recipe.Models) != 2 {
+		t.Errorf("recipe has %d models, want 2", len(res.Recipe.Models))
+	}
+	if !reflect.DeepEqual(res.UnresolvedModels, []string{"missing.safetensors"}) {
+		t.Errorf("unresolved models = %v", res.UnresolvedModels)
+	}
+}
+
+func TestResolverWorkflowModels(t *testing.T) {
+	r := newTestResolver(t)
+	// a url recorded by the workflow replaces the database's model in the same folder
+	r.AddWorkflow(&util.WorkflowRequirements{Models: []util.WorkflowModel{
+		{Name: "v1-5.safetensors", URL: "https://example.com/mirror", Directory: "checkpoints"},
+		{Name: "SD1.5\\detail.safetensors", URL: "https://example.com/lora", Directory: "loras"},
+	}})
+	m, _, _ := r.Model("checkpoints", "v1-5.safetensors")
+	if m.URL != "https://example.com/mirror" {
+		t.Errorf("checkpoint url = %s, want the workflow's url", m.URL)
+	}
+	m, _, _ = r.Model("loras", "SD1.5/detail.safetensors")
+	if m.SavePath != "loras/SD1.5" {
+		t.Errorf("lora save path = %s, want loras/SD1.5", m.SavePath)
+	}
+}
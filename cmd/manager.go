@@ -6,27 +6,36 @@ package cmd
 import (
 	"log"
 
+	"github.com/richinsley/comfycli/cmd/manager"
 	"github.com/spf13/cobra"
 )
 
-// managerCmd represents the system command
+// managerCmd represents the manager command
 var managerCmd = &cobra.Command{
 	Use:   "manager",
 	Short: "ComfyUI-Manager operations",
-	Long:  `Perform operations with the ComfyUI-Manager system.`,
+	Long:  `Perform operations with the ComfyUI-Manager of a ComfyUI instance.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		log.Println("system called")
+		if len(args) == 0 {
+			if err := cmd.Help(); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			return
+		}
+		log.Println("manager called with args: ", args)
 	},
 }
 
-// TODO
 func init() {
-	// rootCmd.AddCommand(managerCmd)
+	rootCmd.AddCommand(managerCmd)
 
-	// // hand over cli options to the system package
-	// CLIOptions.ApplyEnvironment()
-	// manager.SetLocalOptions(&CLIOptions)
+	// hand over cli options to the manager package
+	CLIOptions.ApplyEnvironment()
+	manager.SetLocalOptions(&CLIOptions)
 
-	// add system subcommands
-	// manager.InitInfo(managerCmd)
+	// add manager subcommands
+	manager.InitLs(managerCmd)
+	manager.InitPacks(managerCmd)
+	manager.InitInstallMissing(managerCmd)
+	manager.InitRestart(managerCmd)
 }
@@ -0,0 +1,99 @@
+/*
+Copyright © 2024 Rich Insley <richinsley@gmail.com>
+*/
+package manager
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/richinsley/comfycli/pkg"
+	"github.com/spf13/cobra"
+)
+
+var lsInstalled bool
+var lsAvailable bool
+var lsSearch string
+var lsMode string
+
+// hostPacks are the custom node packs of a host
+type hostPacks struct {
+	Host  string                `json:"host"`
+	Error string                `json:"error,omitempty"`
+	Packs []*pkg.CustomNodePack `json:"packs"`
+}
+
+// lsCmd represents the ls command
+var lsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List the installed and available custom nodes",
+	Long: `List the custom node packs ComfyUI-Manager knows, with their state: enabled, disabled, update (enabled with an update available), import-fail or not-installed.
+	examples:
+	# list the installed custom nodes
+	comfycli manager ls --installed
+	# search the available custom nodes for "impact"
+	comfycli manager ls --available --search impact`,
+	Run: func(cmd *cobra.Command, args []string) {
+		search := strings.ToLower(lsSearch)
+		results := make([]*hostPacks, len(CLIOptions.Host))
+		failed := 0
+		for i := range CLIOptions.Host {
+			m := pkg.NewManagerClient(CLIOptions.Host[i], CLIOptions.Port[i])
+			results[i] = &hostPacks{Host: m.Address(), Packs: make([]*pkg.CustomNodePack, 0)}
+			packs, err := m.List(lsMode)
+			if err != nil {
+				results[i].Error = err.Error()
+				failed++
+				continue
+			}
+			for _, p := range packs {
+				if lsInstalled && !p.Installed() || lsAvailable && p.Installed() {
+					continue
+				}
+				if search != "" && !strings.Contains(strings.ToLower(p.ID+"\n"+p.Title+"\n"+p.Author+"\n"+p.Description), search) {
+					continue
+				}
+				results[i].Packs = append(results[i].Packs, p)
+			}
+		}
+
+		if CLIOptions.Json {
+			j, _ := pkg.ToJson(results, CLIOptions.PrettyJson)
+			fmt.Println(j)
+		} else {
+			for i, r := range results {
+				if len(results) > 1 {
+					if i > 0 {
+						fmt.Println()
+					}
+					fmt.Printf("Host %s:\n", r.Host)
+				}
+				if r.Error != "" {
+					slog.Error("error listing custom nodes", "host", r.Host, "error", r.Error)
+					continue
+				}
+				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "ID\tTITLE\tVERSION\tSTATE")
+				for _, p := range r.Packs {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.ID, p.Title, p.Version, p.State)
+				}
+				w.Flush()
+			}
+		}
+		if failed == len(results) {
+			os.Exit(1)
+		}
+	},
+}
+
+func InitLs(managerCmd *cobra.Command) {
+	managerCmd.AddCommand(lsCmd)
+
+	lsCmd.Flags().BoolVar(&lsInstalled, "installed", false, "Only list the installed custom nodes")
+	lsCmd.Flags().BoolVar(&lsAvailable, "available", false, "Only list the custom nodes that are not installed")
+	lsCmd.Flags().StringVar(&lsSearch, "search", "", "Only list the custom nodes whose id, title, author or description contains the text")
+	lsCmd.Flags().StringVar(&lsMode, "mode", "cache", "Where the manager reads the list from (local, cache, remote)")
+}
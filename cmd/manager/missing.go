@@ -0,0 +1,145 @@
+/*
+Copyright © 2024 Rich Insley <richinsley@gmail.com>
+*/
+package manager
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/richinsley/comfycli/pkg"
+	"github.com/spf13/cobra"
+)
+
+var missingDryRun bool
+
+// missingPack is a pack that provides missing node types of a workflow
+type missingPack struct {
+	ID    string   `json:"id"`
+	Title string   `json:"title"`
+	Repo  string   `json:"repo,omitempty"`
+	Nodes []string `json:"nodes"`
+}
+
+// hostMissing are the packs a host needs to run a workflow
+type hostMissing struct {
+	Host       string              `json:"host"`
+	Error      string              `json:"error,omitempty"`
+	Packs      []missingPack       `json:"packs"`
+	Unresolved []string            `json:"unresolved,omitempty"` // missing node types no pack provides
+	Results    []pkg.ManagerResult `json:"results,omitempty"`
+}
+
+// installMissingCmd represents the install-missing command
+var installMissingCmd = &cobra.Command{
+	Use:   "install-missing [workflow file path]",
+	Short: "Install the custom nodes a workflow needs",
+	Long: `Install the custom nodes a workflow needs, on every host.  The node types of the workflow a host does not have are looked up in the node map of its ComfyUI-Manager,
+	and in the node packs recorded by the workflow.
+	ComfyUI must be restarted to load the changes, see 'comfycli manager restart'.
+	examples:
+	# list the custom nodes that would be installed
+	comfycli manager install-missing --dry-run workflow.json
+	# install the missing custom nodes and restart ComfyUI
+	comfycli manager install-missing workflow.json && comfycli manager restart --wait 120`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			if err := cmd.Help(); err != nil {
+				slog.Error("Error", "error", err)
+			}
+			os.Exit(1)
+		}
+
+		results := make([]*hostMissing, len(CLIOptions.Host))
+		failed := false
+		for i := range CLIOptions.Host {
+			m := pkg.NewManagerClient(CLIOptions.Host[i], CLIOptions.Port[i])
+			results[i] = installMissing(m, args[0])
+			if results[i].Error != "" || len(results[i].Unresolved) > 0 {
+				failed = true
+			}
+			for _, r := range results[i].Results {
+				if r.Error != "" {
+					failed = true
+				}
+			}
+		}
+
+		if CLIOptions.Json {
+			j, _ := pkg.ToJson(results, CLIOptions.PrettyJson)
+			fmt.Println(j)
+		} else {
+			installed := false
+			for _, r := range results {
+				displayMissing(r)
+				for _, res := range r.Results {
+					installed = installed || res.Error == ""
+				}
+			}
+			if installed {
+				fmt.Println("restart ComfyUI to load the changes with 'comfycli manager restart'")
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+func installMissing(m *pkg.ManagerClient, workflowPath string) *hostMissing {
+	result := &hostMissing{Host: m.Address(), Packs: make([]missingPack, 0)}
+	packs, err := m.List("cache")
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	toInstall, provides, unresolved, err := m.MissingNodePacks(workflowPath, packs)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Unresolved = unresolved
+
+	names := make([]string, len(toInstall))
+	for i, p := range toInstall {
+		names[i] = p.ID
+		result.Packs = append(result.Packs, missingPack{ID: p.ID, Title: p.Title, Repo: p.Repo(), Nodes: provides[p.ID]})
+	}
+	if !missingDryRun && len(names) > 0 {
+		result.Results = actOnPacks(m, "install", packs, names)
+	}
+	return result
+}
+
+func displayMissing(r *hostMissing) {
+	if r.Error != "" {
+		fmt.Printf("%s: %s\n", r.Host, r.Error)
+		return
+	}
+	if len(r.Packs) == 0 && len(r.Unresolved) == 0 {
+		fmt.Printf("%s: no custom nodes are missing\n", r.Host)
+		return
+	}
+	for _, p := range r.Packs {
+		fmt.Printf("%s: %s (%s) provides %s\n", r.Host, p.ID, p.Title, strings.Join(p.Nodes, ", "))
+	}
+	if len(r.Unresolved) > 0 {
+		fmt.Printf("%s: no custom node found for %s\n", r.Host, strings.Join(r.Unresolved, ", "))
+	}
+	for _, res := range r.Results {
+		if res.Error != "" {
+			fmt.Printf("%s: failed to install %s: %s\n", r.Host, res.Pack, res.Error)
+		} else {
+			fmt.Printf("%s: installed %s\n", r.Host, res.Pack)
+		}
+	}
+}
+
+func InitInstallMissing(managerCmd *cobra.Command) {
+	managerCmd.AddCommand(installMissingCmd)
+
+	installMissingCmd.Flags().BoolVar(&missingDryRun, "dry-run", false, "Only list the custom nodes that would be installed")
+	installMissingCmd.Flags().IntVar(&packTimeout, "timeout", 600, "Seconds to wait for ComfyUI-Manager to finish")
+}
@@ -0,0 +1,169 @@
+/*
+Copyright © 2024 Rich Insley <richinsley@gmail.com>
+*/
+package manager
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/richinsley/comfycli/pkg"
+	"github.com/spf13/cobra"
+)
+
+var packTimeout int
+var updateAll bool
+
+// packActionCmd returns the command of an action on custom node packs
+func packActionCmd(action string, short string, example string) *cobra.Command {
+	return &cobra.Command{
+		Use:   action + " [id, title or git url of custom node]...",
+		Short: short,
+		Long: short + `, on every host.  Custom nodes are named by their ComfyUI-Manager id, title or git url.
+	ComfyUI must be restarted to load the changes, see 'comfycli manager restart'.
+	examples:
+	` + example,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 && !(action == "update" && updateAll) {
+				if err := cmd.Help(); err != nil {
+					slog.Error("Error", "error", err)
+				}
+				os.Exit(1)
+			}
+
+			results := make([]pkg.ManagerResult, 0)
+			for i := range CLIOptions.Host {
+				m := pkg.NewManagerClient(CLIOptions.Host[i], CLIOptions.Port[i])
+				results = append(results, runPackAction(m, action, args)...)
+			}
+			displayResults(results)
+		},
+	}
+}
+
+// runPackAction runs an action on the packs of a host, and waits until the manager is done
+func runPackAction(m *pkg.ManagerClient, action string, names []string) []pkg.ManagerResult {
+	if action == "update" && updateAll {
+		result := pkg.ManagerResult{Host: m.Address(), Action: action, Pack: "all"}
+		queued, err := m.UpdateAll()
+		if err == nil && queued {
+			err = m.Start(time.Duration(packTimeout) * time.Second)
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		return []pkg.ManagerResult{result}
+	}
+
+	results := make([]pkg.ManagerResult, 0, len(names))
+	packs, err := m.List("cache")
+	if err != nil {
+		for _, name := range names {
+			results = append(results, pkg.ManagerResult{Host: m.Address(), Action: action, Pack: name, Error: err.Error()})
+		}
+		return results
+	}
+	return actOnPacks(m, action, packs, names)
+}
+
+// actOnPacks runs an action on the named packs, and waits until the manager is done
+func actOnPacks(m *pkg.ManagerClient, action string, packs []*pkg.CustomNodePack, names []string) []pkg.ManagerResult {
+	results := make([]pkg.ManagerResult, len(names))
+	queued := false
+	for i, name := range names {
+		results[i] = pkg.ManagerResult{Host: m.Address(), Action: action, Pack: name}
+		pack := pkg.FindPack(packs, name)
+		if pack == nil {
+			if action == "install" && strings.Contains(name, "://") {
+				// a repo the manager doesn't list
+				if err := m.InstallGitURL(name); err != nil {
+					results[i].Error = err.Error()
+				}
+				continue
+			}
+			results[i].Error = fmt.Sprintf("custom node %s not found", name)
+			continue
+		}
+		results[i].Pack = pack.ID
+		results[i].Title = pack.Title
+		if action != "install" && !pack.Installed() {
+			results[i].Error = fmt.Sprintf("custom node %s is not installed", pack.ID)
+			continue
+		}
+		if action == "disable" && pack.State == pkg.PackDisabled {
+			// the toggle of older managers would enable the pack again
+			continue
+		}
+		q, err := m.Act(action, pack)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		queued = queued || q
+	}
+
+	if queued {
+		if err := m.Start(time.Duration(packTimeout) * time.Second); err != nil {
+			for i := range results {
+				if results[i].Error == "" {
+					results[i].Error = err.Error()
+				}
+			}
+		}
+	}
+	return results
+}
+
+func displayResults(results []pkg.ManagerResult) {
+	failed := 0
+	for _, r := range results {
+		if r.Error != "" {
+			failed++
+		}
+	}
+
+	if CLIOptions.Json {
+		j, _ := pkg.ToJson(results, CLIOptions.PrettyJson)
+		fmt.Println(j)
+	} else {
+		for _, r := range results {
+			name := r.Pack
+			if r.Title != "" && r.Title != r.Pack {
+				name = fmt.Sprintf("%s (%s)", r.Pack, r.Title)
+			}
+			if r.Error != "" {
+				fmt.Printf("%s: failed to %s %s: %s\n", r.Host, r.Action, name, r.Error)
+			} else {
+				fmt.Printf("%s: %s %s done\n", r.Host, r.Action, name)
+			}
+		}
+		if failed < len(results) {
+			fmt.Println("restart ComfyUI to load the changes with 'comfycli manager restart'")
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func InitPacks(managerCmd *cobra.Command) {
+	installCmd := packActionCmd("install", "Install custom nodes",
+		"comfycli manager install comfyui-impact-pack https://github.com/cubiq/ComfyUI_essentials")
+	updateCmd := packActionCmd("update", "Update custom nodes",
+		`comfycli manager update comfyui-impact-pack
+	# update every installed custom node
+	comfycli manager update --all`)
+	disableCmd := packActionCmd("disable", "Disable custom nodes, without removing them",
+		"comfycli manager disable comfyui-impact-pack")
+	uninstallCmd := packActionCmd("uninstall", "Uninstall custom nodes",
+		"comfycli manager uninstall comfyui-impact-pack")
+
+	for _, c := range []*cobra.Command{installCmd, updateCmd, disableCmd, uninstallCmd} {
+		managerCmd.AddCommand(c)
+		c.Flags().IntVar(&packTimeout, "timeout", 600, "Seconds to wait for ComfyUI-Manager to finish")
+	}
+	updateCmd.Flags().BoolVar(&updateAll, "all", false, "Update every installed custom node")
+}
@@ -0,0 +1,65 @@
+/*
+Copyright © 2024 Rich Insley <richinsley@gmail.com>
+*/
+package manager
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/richinsley/comfycli/pkg"
+	"github.com/spf13/cobra"
+)
+
+var restartWait int
+
+// hostRestart is the result of restarting a host
+type hostRestart struct {
+	Host  string `json:"host"`
+	Error string `json:"error,omitempty"`
+}
+
+// restartCmd represents the restart command
+var restartCmd = &cobra.Command{
+	Use:   "restart",
+	Short: "Restart ComfyUI",
+	Long: `Restart ComfyUI through ComfyUI-Manager, on every host.
+	examples:
+	# restart ComfyUI and wait up to two minutes until it answers again
+	comfycli manager restart --wait 120`,
+	Run: func(cmd *cobra.Command, args []string) {
+		results := make([]hostRestart, len(CLIOptions.Host))
+		failed := false
+		for i := range CLIOptions.Host {
+			m := pkg.NewManagerClient(CLIOptions.Host[i], CLIOptions.Port[i])
+			results[i].Host = m.Address()
+			if err := m.Restart(time.Duration(restartWait) * time.Second); err != nil {
+				results[i].Error = err.Error()
+				failed = true
+			}
+		}
+
+		if CLIOptions.Json {
+			j, _ := pkg.ToJson(results, CLIOptions.PrettyJson)
+			fmt.Println(j)
+		} else {
+			for _, r := range results {
+				if r.Error != "" {
+					fmt.Printf("%s: failed to restart: %s\n", r.Host, r.Error)
+				} else {
+					fmt.Printf("%s: restarted\n", r.Host)
+				}
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+func InitRestart(managerCmd *cobra.Command) {
+	managerCmd.AddCommand(restartCmd)
+
+	restartCmd.Flags().IntVar(&restartWait, "wait", 0, "Seconds to wait for ComfyUI to answer again, 0 to not wait")
+}
@@ -34,7 +34,7 @@ func PreprocessOptions(cmd *cobra.Command, args []string) {
 	hosts := viper.GetStringSlice("host")
 	CLIOptions.Host = make([]string, len(hosts))
 	CLIOptions.Port = make([]int, len(hosts))
-	CLIOptions.JsonScannerMutex = &sync.Mutex{}
+	CLIOptions.JsonStreamMutex = &sync.Mutex{}
 	for i, host := range hosts {
 		if host != "" {
 			hostParts := strings.Split(host, ":")
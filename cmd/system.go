@@ -40,4 +40,8 @@ func init() {
 	system.InitWait(systemCmd)
 	system.InitNodes(systemCmd)
 	system.InitTop(systemCmd)
+	system.InitExporter(systemCmd)
+	system.InitBench(systemCmd)
+	system.InitModels(systemCmd)
+	system.InitCompare(systemCmd)
 }
@@ -0,0 +1,95 @@
+/*
+Copyright © 2024 Rich Insley <richinsley@gmail.com>
+*/
+package system
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/richinsley/comfycli/pkg"
+	"github.com/spf13/cobra"
+)
+
+var benchOptions pkg.BenchOptions
+var benchBaseline string
+
+// benchCmd represents the bench command
+var benchCmd = &cobra.Command{
+	Use:   "bench [workflow file]",
+	Short: "Benchmark a workflow on ComfyUI instances",
+	Long: `Benchmark a workflow on ComfyUI instances.
+The workflow is queued repeatedly on every host given with --host, after warmup runs that are not
+measured.  The hosts are benchmarked at the same time, and the runs on each host one after the
+other.  The latency percentiles, the throughput and the time spent in each node are reported,
+and outputs are not saved.  Parameters following the delimiter "--" are set before the runs.
+
+With --seed random, every seed of the workflow is set to a new value for each run.  With
+--seed fixed, ComfyUI reuses the cached outputs of the nodes whose inputs did not change, so
+only the nodes after a changed input execute.
+
+Save a benchmark with -j to compare later benchmarks with it using --baseline.  A measure worse
+than the baseline by more than --threshold percent is flagged as a regression, and the command
+exits with status 1.  A baseline of a single host is compared with every host.
+
+examples:
+# benchmark a workflow on two hosts and save the result as a baseline
+comfycli --host gpu1:8188 --host gpu2:8188 -j system bench wf.json -n 20 --warmup 2 > baseline.json
+
+# compare a host launched with new flags against the baseline
+comfycli --host gpu1:8188 system bench wf.json -n 20 --warmup 2 --baseline baseline.json`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if benchOptions.Runs < 1 {
+			slog.Error("the number of runs must be at least 1")
+			os.Exit(1)
+		}
+		if benchOptions.Seed != "random" && benchOptions.Seed != "fixed" {
+			slog.Error(fmt.Sprintf("unknown seed mode %s, expected random or fixed", benchOptions.Seed))
+			os.Exit(1)
+		}
+
+		var baseline *pkg.BenchReport
+		if benchBaseline != "" {
+			var err error
+			baseline, err = pkg.LoadBenchReport(benchBaseline)
+			if err != nil {
+				slog.Error("Error loading the baseline:", "error", err)
+				os.Exit(1)
+			}
+		}
+
+		parameters := pkg.ParseParameters(args[1:])
+		report := pkg.Bench(CLIOptions, args[0], parameters, benchOptions)
+
+		regressions := 0
+		if baseline != nil {
+			regressions = pkg.CompareBench(report, baseline, benchOptions.Threshold)
+		}
+
+		if CLIOptions.Json {
+			j, err := pkg.ToJson(report, CLIOptions.PrettyJson)
+			if err != nil {
+				slog.Error("Error fomating benchmark to json:", "error", err)
+				os.Exit(1)
+			}
+			fmt.Println(j)
+		} else {
+			pkg.WriteBenchReport(os.Stdout, report)
+		}
+
+		if regressions > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func InitBench(systemCmd *cobra.Command) {
+	benchCmd.Flags().IntVarP(&benchOptions.Runs, "runs", "n", 10, "Number of measured runs on each host")
+	benchCmd.Flags().IntVarP(&benchOptions.Warmup, "warmup", "", 1, "Number of runs before the measured runs")
+	benchCmd.Flags().StringVarP(&benchOptions.Seed, "seed", "", "random", "Seed of each run (random, fixed)")
+	benchCmd.Flags().StringVarP(&benchBaseline, "baseline", "b", "", "Benchmark json to compare with, flagging regressions")
+	benchCmd.Flags().Float64VarP(&benchOptions.Threshold, "threshold", "t", 10, "Percent a measure can be worse than the baseline before it is a regression")
+	systemCmd.AddCommand(benchCmd)
+}
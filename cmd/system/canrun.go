@@ -6,91 +6,40 @@ package system
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
-	"github.com/richinsley/comfy2go/client"
-	"github.com/richinsley/comfy2go/graphapi"
+	"github.com/richinsley/comfycli/cmd/env"
 	util "github.com/richinsley/comfycli/pkg"
 	"github.com/spf13/cobra"
 	"golang.org/x/exp/slog"
 )
 
-func contains(slice []string, str string) bool {
-	for _, item := range slice {
-		if item == str {
-			return true
-		}
-	}
-	return false
-}
-
-type missingComboValue struct {
-	NodeTitle     string
-	NodeType      string
-	PropertyName  string
-	PropertyValue string
-}
-
-func getMissingCombos(c *client.ComfyClient, graph *graphapi.Graph) []missingComboValue {
-	object_infos, err := c.GetObjectInfos()
-	if err != nil {
-		slog.Error("Error decoding Object Infos:", "error", err)
-		os.Exit(1)
-	}
-	missing := make([]missingComboValue, 0)
-	for _, n := range graph.Nodes {
-		for _, p := range n.Properties {
-			obj, ok := object_infos.Objects[n.Type]
-			if !ok && n.Type != "PrimitiveNode" && n.Type != "Note" && n.Type != "Reroute" {
-				slog.Error("could not find node type:", "node", n.Type)
-				os.Exit(1)
-			}
+// exit codes of canrun when a host can't run the workflow
+const (
+	canrunMissingNodes  = 2
+	canrunMissingModels = 3
+)
 
-			if p.TypeString() == "COMBO" {
-				combo, _ := p.ToComboProperty()
-				cvalue, ok := combo.GetValue().(string)
-				if !ok {
-					// combo is not a string value
-					continue
-				}
-				if obj != nil && obj.InputPropertiesByID != nil {
-					inputrawprop := obj.InputPropertiesByID[combo.Name()]
-					inputcomboprop, _ := (*inputrawprop).ToComboProperty()
-					inputcombovalues := inputcomboprop.Values
-
-					// check is cvalue is in inputcombovalues
-					if !contains(inputcombovalues, cvalue) {
-						// ignore cvalue that ends with image extension
-						// convert to lower case and check if it ends with .png, .jpg, .jpeg, .gif
-						tolower := strings.ToLower(cvalue)
-						if strings.HasSuffix(tolower, ".png") || strings.HasSuffix(tolower, ".jpg") || strings.HasSuffix(tolower, ".jpeg") || strings.HasSuffix(tolower, ".gif") {
-							continue
-						}
-
-						mvalue := missingComboValue{
-							NodeTitle:     n.DisplayName,
-							NodeType:      n.Type,
-							PropertyName:  p.Name(),
-							PropertyValue: p.GetValue().(string),
-						}
-						missing = append(missing, mvalue)
-					}
-				}
-			}
-		}
-	}
-	return missing
-}
+var canrunRecipe string
+var canrunDatabases []string
 
 // canrunCmd represents the canrun command
 var canrunCmd = &cobra.Command{
 	Use:   "canrun [workflow file path]",
-	Short: "Tests if an instance of ComfyUI can run a workflow with the given parameters",
-	Long: `Tests if an instance of ComfyUI can run a workflow with the given parameters.
-	Returns true if the instance can run the workflow, or a list of missing nodes and missing combo values if it cannot.
+	Short: "Tests if instances of ComfyUI can run a workflow with the given parameters",
+	Long: `Tests if instances of ComfyUI can run a workflow with the given parameters.
+	Every host is tested at the same time.  Reports the hosts that can run the workflow, and the missing nodes and missing combo values of those that cannot,
+	with the custom node repos and recipes that provide the missing nodes when the ComfyUI-Manager of a host knows them.
+	Exits with 0 when every host can run the workflow, 1 on errors, 2 when a host is missing nodes and 3 when a host is only missing combo values (models).
+	With --recipe, writes a recipe that installs the missing nodes and models, for 'comfycli env create --file'.
 	examples:
 	# test if the instance of ComfyUI at 192.168.0.41:9000 can run the workflow 'workflow.json'
-	comfycli --host 192.168.0.41 --port 9000 system canrun /path/to/workflow.json`,
+	comfycli --host 192.168.0.41 --port 9000 system canrun /path/to/workflow.json
+	# test two instances and output the results as json
+	comfycli --host 192.168.0.41,192.168.0.42 -j system canrun /path/to/workflow.json
+	# write a recipe with the missing nodes and models, resolved with the node map of ComfyUI-Manager
+	comfycli system canrun --recipe missing.json --db https://raw.githubusercontent.com/ltdrdata/ComfyUI-Manager/main/extension-node-map.json /path/to/workflow.json`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) < 1 {
 			if err := cmd.Help(); err != nil {
@@ -103,66 +52,168 @@ var canrunCmd = &cobra.Command{
 		params := args[1:] // All other args are considered parameters
 		parameters := util.ParseParameters(params)
 
-		// error outputs should go to stout instead of stderr
-		workflow, _, missing, err := util.ClientWithWorkflow(0, CLIOptions, workflowPath, parameters, nil, true)
-		if err != nil && missing == nil {
-			// is err a fs.PathError?
-			if err, ok := err.(*os.PathError); ok {
-				slog.Error("workflow not found", "error", err)
+		if _, err := os.Stat(workflowPath); err != nil {
+			slog.Error("workflow not found", "error", err)
+			os.Exit(1)
+		}
+
+		results := util.CanRun(CLIOptions, workflowPath, parameters)
+		var resolution *env.Resolution
+		if needsResolver(results) || canrunRecipe != "" {
+			resolver, err := env.NewResolver(canrunDatabases)
+			if err != nil {
+				slog.Error("error loading resolver", "error", err)
 				os.Exit(1)
 			}
-
-			slog.Error("error getting client and workflow", "error", err)
-			os.Exit(1)
+			resolver.Mappings.Merge(util.GetHostsNodeMappings(CLIOptions))
+			util.SuggestNodeSources(results, resolver.Mappings, resolver.RecipesByRepo())
+			if canrunRecipe != "" {
+				resolution = writeMissingRecipe(resolver, results, workflowPath)
+			}
 		}
 
-		if missing != nil {
-			if !CLIOptions.Json {
-				fmt.Println("failed to get workflow\nmissing nodes:\n--------------")
-				for _, v := range *missing {
-					fmt.Println(v)
-				}
-			} else {
-				// output as json
-				output := make(map[string]interface{})
-				output["canrun"] = false
-				output["missing_nodes"] = missing
-				j, _ := util.ToJson(output, CLIOptions.PrettyJson)
-				fmt.Println(j)
+		exitCode := 0
+		canrun := true
+		for _, r := range results {
+			if r.CanRun {
+				continue
+			}
+			canrun = false
+			switch {
+			case r.Error != "":
+				exitCode = 1
+			case len(r.MissingNodes) > 0 && exitCode != 1:
+				exitCode = canrunMissingNodes
+			case len(r.MissingComboValues) > 0 && exitCode == 0:
+				exitCode = canrunMissingModels
 			}
-			// we can't continue on to check for missing combo values
-			os.Exit(0)
 		}
 
-		missingcombos := getMissingCombos(workflow.Client, workflow.Graph)
-		if len(missingcombos) > 0 {
-			if !CLIOptions.Json {
-				fmt.Println("failed to get workflow\nmissing combo values:\n--------------")
-				for _, v := range missingcombos {
-					fmt.Println(v)
-				}
-			} else {
-				// output as json
-				output := make(map[string]interface{})
-				output["canrun"] = false
-				output["missing_combo_values"] = missingcombos
-				j, _ := util.ToJson(output, CLIOptions.PrettyJson)
-				fmt.Println(j)
+		if CLIOptions.Json {
+			output := make(map[string]interface{})
+			output["canrun"] = canrun
+			output["hosts"] = results
+			if resolution != nil {
+				output["resolution"] = resolution
 			}
+			j, _ := util.ToJson(output, CLIOptions.PrettyJson)
+			fmt.Println(j)
 		} else {
-			if !CLIOptions.Json {
-				fmt.Printf("Host %s:%d can run workflow %s\n", CLIOptions.Host, CLIOptions.Port, workflowPath)
-			} else {
-				// output as json
-				output := make(map[string]interface{})
-				output["canrun"] = true
-				j, _ := util.ToJson(output, CLIOptions.PrettyJson)
-				fmt.Println(j)
+			for i, r := range results {
+				if i > 0 {
+					fmt.Println()
+				}
+				displayCanRunResult(r, workflowPath)
+			}
+			if resolution != nil {
+				displayResolution(resolution)
 			}
 		}
+		os.Exit(exitCode)
 	},
 }
 
+// needsResolver returns true if a host is missing nodes, whose sources are suggested
+func needsResolver(results []*util.CanRunResult) bool {
+	for _, r := range results {
+		if len(r.MissingNodes) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// writeMissingRecipe writes a recipe with the nodes and models missing on any host
+func writeMissingRecipe(resolver *env.Resolver, results []*util.CanRunResult, workflowPath string) *env.Resolution {
+	nodeTypes := make([]string, 0)
+	models := make([]util.WorkflowModel, 0)
+	seen := make(map[util.WorkflowModel]bool)
+	for _, r := range results {
+		for _, t := range r.MissingNodes {
+			if !contains(nodeTypes, t) {
+				nodeTypes = append(nodeTypes, t)
+			}
+		}
+		for _, v := range r.MissingComboValues {
+			m := util.WorkflowModel{Name: v.PropertyValue, Directory: util.ModelInputFolder(v.NodeType, v.PropertyName)}
+			if !seen[m] {
+				seen[m] = true
+				models = append(models, m)
+			}
+		}
+	}
+
+	name := strings.TrimSuffix(filepath.Base(workflowPath), filepath.Ext(workflowPath))
+	resolution := resolver.Resolve(name, nodeTypes, models)
+	if err := resolution.Recipe.WriteRecipe(canrunRecipe, true); err != nil {
+		slog.Error("error writing recipe", "error", err)
+		os.Exit(1)
+	}
+	return resolution
+}
+
+func displayResolution(res *env.Resolution) {
+	fmt.Printf("\nrecipe written to %s with %d custom nodes and %d models\n", canrunRecipe, len(res.Recipe.CustomNodes), len(res.Recipe.Models))
+	if len(res.UnresolvedNodes) > 0 {
+		fmt.Printf("no source found for nodes: %s\n", strings.Join(res.UnresolvedNodes, ", "))
+	}
+	if len(res.UnresolvedModels) > 0 {
+		fmt.Printf("no source found for models: %s\n", strings.Join(res.UnresolvedModels, ", "))
+	}
+	for m, folders := range res.AmbiguousModels {
+		fmt.Printf("model %s is in several folders: %s\n", m, strings.Join(folders, ", "))
+	}
+}
+
+func displayCanRunResult(r *util.CanRunResult, workflowPath string) {
+	switch {
+	case r.Error != "":
+		fmt.Printf("Host %s failed to test workflow %s: %s\n", r.Host, workflowPath, r.Error)
+		return
+	case r.CanRun:
+		fmt.Printf("Host %s can run workflow %s\n", r.Host, workflowPath)
+		return
+	}
+
+	fmt.Printf("Host %s cannot run workflow %s\n", r.Host, workflowPath)
+	if len(r.MissingNodes) > 0 {
+		fmt.Println("missing nodes:\n--------------")
+		for _, t := range r.MissingNodes {
+			sources := r.Suggestions[t]
+			if len(sources) == 0 {
+				fmt.Println(t)
+				continue
+			}
+			provided := make([]string, len(sources))
+			for i, s := range sources {
+				provided[i] = s.Repo
+				if len(s.Recipes) > 0 {
+					provided[i] += " (recipes: " + strings.Join(s.Recipes, ", ") + ")"
+				}
+			}
+			fmt.Printf("%s - provided by %s\n", t, strings.Join(provided, ", "))
+		}
+	}
+	if len(r.MissingComboValues) > 0 {
+		fmt.Println("missing combo values:\n--------------")
+		for _, v := range r.MissingComboValues {
+			fmt.Println(v)
+		}
+	}
+}
+
+func contains(slice []string, str string) bool {
+	for _, item := range slice {
+		if item == str {
+			return true
+		}
+	}
+	return false
+}
+
 func InitCanrun(systemCmd *cobra.Command) {
 	systemCmd.AddCommand(canrunCmd)
+
+	canrunCmd.Flags().StringVar(&canrunRecipe, "recipe", "", "Write a recipe that installs the missing nodes and models to a file")
+	canrunCmd.Flags().StringSliceVar(&canrunDatabases, "db", nil, "ComfyUI-Manager style database file or url to resolve nodes and models with (extension-node-map.json, custom-node-list.json, model-list.json)")
 }
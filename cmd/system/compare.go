@@ -0,0 +1,128 @@
+/*
+Copyright © 2024 Rich Insley <richinsley@gmail.com>
+*/
+package system
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/richinsley/comfycli/pkg"
+	"github.com/spf13/cobra"
+)
+
+// presence marks the hosts that have something, with "?" for the hosts that didn't answer
+func presence(report *pkg.InventoryReport, present []bool) []string {
+	cells := make([]string, len(present))
+	for i, p := range present {
+		switch _, failed := report.Errors[report.Hosts[i]]; {
+		case failed:
+			cells[i] = "?"
+		case p:
+			cells[i] = "yes"
+		default:
+			cells[i] = "-"
+		}
+	}
+	return cells
+}
+
+func displayInventoryReport(report *pkg.InventoryReport) {
+	for _, h := range report.Hosts {
+		if err, ok := report.Errors[h]; ok {
+			fmt.Printf("%s: %s\n", h, err)
+		}
+	}
+	if report.Count() == 0 {
+		fmt.Println("The hosts have the same node types, node schemas and combo choices")
+		return
+	}
+
+	table := func(title string, header []string, rows [][]string) {
+		if len(rows) == 0 {
+			return
+		}
+		fmt.Println(title)
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(header, "\t"))
+		for _, row := range rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		tw.Flush()
+		fmt.Println()
+	}
+
+	rows := make([][]string, 0, len(report.Nodes))
+	for _, n := range report.Nodes {
+		rows = append(rows, append([]string{n.Type}, presence(report, n.Present)...))
+	}
+	table("Node types missing from some hosts:", append([]string{"TYPE"}, report.Hosts...), rows)
+
+	rows = make([][]string, 0, len(report.Schemas))
+	for _, s := range report.Schemas {
+		cells := []string{s.Type + "." + s.Slot}
+		for i, schema := range s.Schemas {
+			if _, failed := report.Errors[report.Hosts[i]]; failed {
+				schema = "?"
+			} else if schema == "" {
+				schema = "-"
+			}
+			cells = append(cells, schema)
+		}
+		rows = append(rows, cells)
+	}
+	table("Node inputs and outputs that differ:", append([]string{"TYPE.SLOT"}, report.Hosts...), rows)
+
+	// the inputs offering a choice are listed last, they can be long
+	rows = make([][]string, 0, len(report.Choices))
+	for _, c := range report.Choices {
+		cells := append([]string{c.Choice}, presence(report, c.Present)...)
+		rows = append(rows, append(cells, strings.Join(c.Inputs, ", ")))
+	}
+	header := append([]string{"CHOICE"}, report.Hosts...)
+	table("Combo choices missing from some hosts:", append(header, "INPUTS"), rows)
+}
+
+// compareCmd represents the compare command
+var compareCmd = &cobra.Command{
+	Use:   "compare",
+	Short: "Compare the node types and models of ComfyUI instances",
+	Long: `Compare the node types and models of ComfyUI instances.
+The object info of every host given with --host is read and compared, listing the node types some
+hosts lack, the node inputs and outputs whose types differ, and the combo choices, such as
+checkpoints and LoRAs, some hosts lack.  The command exits with status 1 when the hosts differ,
+to check the hosts before a batch is queued across them.
+
+examples:
+# compare two hosts
+comfycli --host gpu1:8188 --host gpu2:8188 system compare`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(CLIOptions.Host) < 2 {
+			slog.Error("compare needs at least two hosts, use --host for each host")
+			os.Exit(1)
+		}
+
+		report := pkg.CompareHosts(CLIOptions)
+		if CLIOptions.Json {
+			j, err := pkg.ToJson(report, CLIOptions.PrettyJson)
+			if err != nil {
+				slog.Error("Error fomating comparison to json:", "error", err)
+				os.Exit(1)
+			}
+			fmt.Println(j)
+		} else {
+			displayInventoryReport(report)
+		}
+
+		if report.Count() > 0 || len(report.Errors) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func InitCompare(systemCmd *cobra.Command) {
+	systemCmd.AddCommand(compareCmd)
+}
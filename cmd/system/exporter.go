@@ -0,0 +1,75 @@
+/*
+Copyright © 2024 Rich Insley <richinsley@gmail.com>
+*/
+package system
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/richinsley/comfycli/pkg"
+	"github.com/spf13/cobra"
+)
+
+var exporterListen string = ":9101"
+var exporterInterval int = 5
+
+// exporterCmd represents the exporter command
+var exporterCmd = &cobra.Command{
+	Use:   "exporter",
+	Short: "Serve Prometheus metrics for ComfyUI instances",
+	Long: `Serve Prometheus metrics for ComfyUI instances on /metrics.
+Every host given with --host is polled for its system stats, queue and history.  Job counters
+count the prompts that finish after the exporter starts, and their execution time is taken from
+the start and end the history records for each prompt.  There are no per node times: ComfyUI
+sends the executing node only to the client that queued a prompt, and its history only records
+when each prompt started and finished.
+
+metrics:
+  comfyui_up                       whether the last poll of the host succeeded
+  comfyui_vram_total_bytes         total VRAM of each device
+  comfyui_vram_free_bytes          free VRAM of each device
+  comfyui_torch_vram_total_bytes   VRAM reserved by torch on each device
+  comfyui_torch_vram_free_bytes    free VRAM reserved by torch on each device
+  comfyui_ram_total_bytes          total RAM of the host
+  comfyui_ram_free_bytes           free RAM of the host
+  comfyui_queue_running            number of running prompts
+  comfyui_queue_pending            number of pending prompts
+  comfyui_jobs_completed_total     prompts that succeeded since the exporter started
+  comfyui_jobs_failed_total        prompts that failed or were interrupted since the exporter started
+  comfyui_job_execution_seconds    execution time of the prompts counted by the job counters
+
+examples:
+# export two hosts on the default port
+comfycli --host gpu1:8188 --host gpu2:8188 system exporter --listen :9101`,
+	Run: func(cmd *cobra.Command, args []string) {
+		exporter := &pkg.Exporter{}
+		stop := make(chan struct{})
+		for i := range CLIOptions.Host {
+			m := pkg.NewHostMonitor(CLIOptions.Host[i], CLIOptions.Port[i])
+			exporter.Monitors = append(exporter.Monitors, m)
+			go m.Run(time.Duration(exporterInterval)*time.Second, stop)
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", exporter)
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, `<html><body><a href="/metrics">Metrics</a></body></html>`)
+		})
+
+		slog.Info("serving metrics", "listen", exporterListen)
+		err := http.ListenAndServe(exporterListen, mux)
+		close(stop)
+		slog.Error("Error serving metrics:", "error", err)
+		os.Exit(1)
+	},
+}
+
+func InitExporter(systemCmd *cobra.Command) {
+	exporterCmd.Flags().StringVarP(&exporterListen, "listen", "l", ":9101", "Address to serve the metrics on")
+	exporterCmd.Flags().IntVarP(&exporterInterval, "interval", "i", 5, "Interval in seconds to poll the hosts")
+	systemCmd.AddCommand(exporterCmd)
+}
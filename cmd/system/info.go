@@ -40,7 +40,7 @@ var infoCmd = &cobra.Command{
 		if CLIOptions.Json {
 			j, err := pkg.ToJson(s, CLIOptions.PrettyJson)
 			if err != nil {
-				slog.Error("Error fomating system info to json:", err)
+				slog.Error("Error fomating system info to json:", "error", err)
 				os.Exit(1)
 			}
 			fmt.Println(j)
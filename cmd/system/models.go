@@ -0,0 +1,147 @@
+/*
+Copyright © 2024 Rich Insley <richinsley@gmail.com>
+*/
+package system
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/richinsley/comfycli/pkg"
+	"github.com/spf13/cobra"
+)
+
+var modelsDiff bool
+
+func displayModels(report *pkg.ModelsReport) {
+	for _, h := range report.Hosts {
+		if h.Error != "" {
+			fmt.Printf("%s: %s\n", h.Host, h.Error)
+		}
+	}
+
+	all := report.AllModels()
+	folders := make([]string, 0, len(all))
+	for folder := range all {
+		folders = append(folders, folder)
+	}
+	sort.Strings(folders)
+
+	if len(report.Hosts) == 1 {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "FOLDER\tMODEL")
+		for _, folder := range folders {
+			for _, model := range all[folder] {
+				fmt.Fprintf(tw, "%s\t%s\n", folder, model)
+			}
+		}
+		tw.Flush()
+		return
+	}
+
+	// a column for each host, marking the hosts that have each model
+	missing := 0
+	for _, folder := range folders {
+		rows := make([]string, 0)
+		for _, model := range all[folder] {
+			if modelsDiff && len(report.Missing[folder][model]) == 0 {
+				continue
+			}
+			cells := []string{model}
+			for _, h := range report.Hosts {
+				switch {
+				case h.Error != "":
+					cells = append(cells, "?")
+				case h.Has(folder, model):
+					cells = append(cells, "yes")
+				default:
+					cells = append(cells, "-")
+				}
+			}
+			rows = append(rows, strings.Join(cells, "\t"))
+		}
+		if len(rows) == 0 {
+			continue
+		}
+		missing += len(rows)
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		header := []string{strings.ToUpper(folder)}
+		for _, h := range report.Hosts {
+			header = append(header, h.Host)
+		}
+		fmt.Fprintln(tw, strings.Join(header, "\t"))
+		for _, row := range rows {
+			fmt.Fprintln(tw, row)
+		}
+		tw.Flush()
+		fmt.Println()
+	}
+	if modelsDiff && missing == 0 {
+		for _, h := range report.Hosts {
+			if h.Error != "" {
+				fmt.Println("Every host that answered has the same models")
+				return
+			}
+		}
+		fmt.Println("Every host has the same models")
+	}
+}
+
+// modelsCmd represents the models command
+var modelsCmd = &cobra.Command{
+	Use:   "models [type]",
+	Short: "List the models of ComfyUI instances by folder",
+	Long: `List the models of ComfyUI instances by folder.
+The type is a model folder such as checkpoints, loras, vae, controlnet, text_encoders,
+diffusion_models or upscale_models, and every folder is listed without a type.  The models are
+read from the server's models endpoint, or from the choices of its loader nodes on servers
+without the endpoint.  With several hosts, the models of all hosts are listed with the hosts
+that have them, and --diff lists only the models some hosts are missing.
+
+examples:
+# list the checkpoints of a host
+comfycli system models checkpoints
+
+# find the LoRAs missing from some of the hosts
+comfycli --host gpu1:8188 --host gpu2:8188 system models loras --diff`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		folder := ""
+		if len(args) > 0 {
+			folder = pkg.ModelFolder(args[0])
+		}
+
+		report := pkg.GetModelsReport(CLIOptions, folder)
+		failed := 0
+		for _, h := range report.Hosts {
+			if h.Error != "" {
+				failed++
+			}
+		}
+
+		if CLIOptions.Json {
+			j, err := pkg.ToJson(report, CLIOptions.PrettyJson)
+			if err != nil {
+				slog.Error("Error fomating models to json:", "error", err)
+				os.Exit(1)
+			}
+			fmt.Println(j)
+		} else {
+			displayModels(report)
+		}
+
+		if failed == len(report.Hosts) {
+			os.Exit(1)
+		}
+	},
+}
+
+func InitModels(systemCmd *cobra.Command) {
+	modelsCmd.Flags().BoolVarP(&modelsDiff, "diff", "", false, "List only the models some hosts are missing")
+	systemCmd.AddCommand(modelsCmd)
+}
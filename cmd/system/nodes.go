@@ -7,23 +7,33 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/richinsley/comfy2go/client"
+	"github.com/richinsley/comfy2go/graphapi"
 	"github.com/richinsley/comfycli/pkg"
 	"github.com/spf13/cobra"
 )
 
+var nodesSearch string
+var nodesCategory string
+var nodesType string
+var nodesCombos string
+
 func displayAvailableNodes(c *client.ComfyClient) {
 	object_infos, err := c.GetObjectInfos()
 	if err != nil {
-		slog.Error("Error decoding Object Infos:", err)
+		slog.Error("Error decoding Object Infos:", "error", err)
 		os.Exit(1)
 	}
 
 	if CLIOptions.Json {
 		j, err := pkg.ToJson(object_infos, CLIOptions.PrettyJson)
 		if err != nil {
-			slog.Error("Error fomating system info to json:", err)
+			slog.Error("Error fomating system info to json:", "error", err)
 			os.Exit(1)
 		}
 		fmt.Println(j)
@@ -46,19 +56,166 @@ func displayAvailableNodes(c *client.ComfyClient) {
 	}
 }
 
+// displayNodeList lists the name, display name and category of nodes
+func displayNodeList(nodes []*graphapi.NodeObject) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tDISPLAY NAME\tCATEGORY")
+	for _, n := range nodes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", n.Name, n.DisplayName, n.Category)
+	}
+	tw.Flush()
+}
+
+// displayNodeSchema shows the inputs and outputs of a node, with the choices of its combos
+func displayNodeSchema(schema *pkg.NodeSchema) {
+	fmt.Printf("%s\n", schema.Name)
+	fmt.Printf("  Display name: %s\n", schema.DisplayName)
+	fmt.Printf("  Category:     %s\n", schema.Category)
+	if schema.Description != "" {
+		fmt.Printf("  Description:  %s\n", schema.Description)
+	}
+	fmt.Printf("  Output node:  %v\n", schema.OutputNode)
+
+	fmt.Println("Inputs:")
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, in := range schema.Inputs {
+		required := "required"
+		if !in.Required {
+			required = "optional"
+		}
+		keys := make([]string, 0, len(in.Options))
+		for k := range in.Options {
+			// tooltips are too long for a table
+			if k != "tooltip" {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		options := make([]string, 0, len(keys))
+		for _, k := range keys {
+			v := in.Options[k]
+			if f, ok := v.(float64); ok {
+				v = strconv.FormatFloat(f, 'f', -1, 64)
+			}
+			options = append(options, fmt.Sprintf("%s=%v", k, v))
+		}
+		cells := []string{in.Name, in.Type, required}
+		if len(options) > 0 {
+			cells = append(cells, strings.Join(options, " "))
+		}
+		fmt.Fprintf(tw, "  %s\n", strings.Join(cells, "\t"))
+	}
+	tw.Flush()
+
+	for _, in := range schema.Inputs {
+		if len(in.Choices) == 0 {
+			continue
+		}
+		fmt.Printf("Choices of %s:\n", in.Name)
+		for _, c := range in.Choices {
+			fmt.Printf("  \"%s\"\n", c)
+		}
+	}
+
+	fmt.Println("Outputs:")
+	tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for i, out := range schema.Outputs {
+		list := ""
+		if out.IsList {
+			list = "\tlist"
+		}
+		fmt.Fprintf(tw, "  %d\t%s\t%s%s\n", i, out.Name, out.Type, list)
+	}
+	tw.Flush()
+}
+
 // nodesCmd represents the nodes command
 var nodesCmd = &cobra.Command{
 	Use:   "nodes",
 	Short: "List available nodes in a ComfyUI instance",
-	Long:  `List available nodes in a ComfyUI instance`,
+	Long: `List available nodes in a ComfyUI instance.
+Without flags, every node is listed with its properties and the choices of its combos.  Use
+--search and --category to list the matching nodes, --type to show the inputs and outputs of a
+node, and --combos-only to list the choices of a combo input.
+
+examples:
+# list the upscaling nodes
+comfycli system nodes --search upscale
+
+# show the inputs and outputs of KSampler
+comfycli system nodes --type KSampler
+
+# list the installed checkpoints
+comfycli system nodes --type CheckpointLoaderSimple --combos-only ckpt_name`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// create a client
 		c := client.NewComfyClient(CLIOptions.Host[0], CLIOptions.Port[0], nil)
 
-		displayAvailableNodes(c)
+		if nodesSearch == "" && nodesCategory == "" && nodesType == "" && nodesCombos == "" {
+			displayAvailableNodes(c)
+			return
+		}
+
+		object_infos, err := c.GetObjectInfos()
+		if err != nil {
+			slog.Error("Error decoding Object Infos:", "error", err)
+			os.Exit(1)
+		}
+
+		var nodes []*graphapi.NodeObject
+		if nodesType != "" {
+			n := pkg.FindNodeObject(object_infos, nodesType)
+			if n == nil {
+				slog.Error(fmt.Sprintf("node type %s not found", nodesType))
+				os.Exit(1)
+			}
+			nodes = []*graphapi.NodeObject{n}
+		} else {
+			nodes = pkg.FilterNodeObjects(object_infos, nodesSearch, nodesCategory)
+		}
+
+		var output interface{}
+		switch {
+		case nodesCombos != "":
+			choices := pkg.ComboChoices(nodes, nodesCombos)
+			output = choices
+			if !CLIOptions.Json {
+				for _, c := range choices {
+					fmt.Println(c)
+				}
+			}
+		case nodesType != "":
+			schema := pkg.GetNodeSchema(nodes[0])
+			output = schema
+			if !CLIOptions.Json {
+				displayNodeSchema(schema)
+			}
+		default:
+			schemas := make([]*pkg.NodeSchema, len(nodes))
+			for i, n := range nodes {
+				schemas[i] = pkg.GetNodeSchema(n)
+			}
+			output = schemas
+			if !CLIOptions.Json {
+				displayNodeList(nodes)
+			}
+		}
+
+		if CLIOptions.Json {
+			j, err := pkg.ToJson(output, CLIOptions.PrettyJson)
+			if err != nil {
+				slog.Error("Error fomating nodes to json:", "error", err)
+				os.Exit(1)
+			}
+			fmt.Println(j)
+		}
 	},
 }
 
 func InitNodes(systemCmd *cobra.Command) {
+	nodesCmd.Flags().StringVarP(&nodesSearch, "search", "", "", "List the nodes whose name, category or description contains the text")
+	nodesCmd.Flags().StringVarP(&nodesCategory, "category", "", "", "List the nodes in a category and its sub categories")
+	nodesCmd.Flags().StringVarP(&nodesType, "type", "", "", "Show the inputs and outputs of a node type")
+	nodesCmd.Flags().StringVarP(&nodesCombos, "combos-only", "", "", "List the choices of the combo inputs with a name")
 	systemCmd.AddCommand(nodesCmd)
 }
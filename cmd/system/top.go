@@ -7,74 +7,77 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
-	"runtime"
 	"time"
 
-	"github.com/richinsley/comfy2go/client"
 	"github.com/richinsley/comfycli/pkg"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
 var interval int = 4
+var topJobs int = 3
 
 // topCmd represents the info command
 var topCmd = &cobra.Command{
 	Use:   "top",
-	Short: "Provides a dynamic real-time view of system information from a ComfyUI instance",
-	Long:  `Provides a dynamic real-time view of system information from a ComfyUI instance`,
+	Short: "Provides a dynamic real-time view of system information from ComfyUI instances",
+	Long: `Provides a dynamic real-time view of system information from ComfyUI instances.
+Every host given with --host is shown with sparklines of its VRAM and RAM use, its queue, the
+executing node and its progress, and its recent jobs.
+
+ComfyUI sends the executing node and its progress only to the client that queued a prompt, or to
+every client for prompts queued without a client id.  Prompts queued by another client, such as a
+browser or another comfycli, are not sent to top: they are shown from the queue, with their prompt
+id and how long they have been running, but without their executing node or progress.
+
+keys:
+  q, esc       quit
+  tab, j, k    select a host
+  i            interrupt the prompt the selected host is executing
+  c            clear the pending prompts of the selected host
+  r            refresh the selected host`,
 	Run: func(cmd *cobra.Command, args []string) {
-		// create a client
-		c := client.NewComfyClient(CLIOptions.Host[0], CLIOptions.Port[0], nil)
+		dashboard := &pkg.Dashboard{Jobs: topJobs}
+		for i := range CLIOptions.Host {
+			dashboard.Monitors = append(dashboard.Monitors, pkg.NewHostMonitor(CLIOptions.Host[i], CLIOptions.Port[i]))
+		}
+		tick := time.Duration(interval) * time.Second
 
-		// continuously update the top information at the specified interval
-		for {
-			// clear the terminal screen
-			// windows
-			if runtime.GOOS == "windows" {
-				fmt.Print("\033[H\033[2J")
-			} else {
-				// unix
-				fmt.Print("\033c")
+		interactive := term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+		if interactive && !CLIOptions.Json {
+			if err := dashboard.Run(tick); err != nil {
+				slog.Error("Error running the dashboard:", "error", err)
+				os.Exit(1)
 			}
+			return
+		}
 
-			s, err := c.GetSystemStats()
-			if err != nil {
-				slog.Error("Error initializing client:", "error", err)
-				os.Exit(1)
+		// without a terminal, print the status of the hosts at every interval
+		for {
+			statuses := make([]pkg.HostStatus, 0, len(dashboard.Monitors))
+			for _, m := range dashboard.Monitors {
+				m.Poll()
+				statuses = append(statuses, m.Status())
 			}
 
 			if CLIOptions.Json {
-				j, err := pkg.ToJson(s, CLIOptions.PrettyJson)
+				j, err := pkg.ToJson(statuses, CLIOptions.PrettyJson)
 				if err != nil {
-					slog.Error("Error fomating system info to json:", err)
+					slog.Error("Error fomating system info to json:", "error", err)
 					os.Exit(1)
 				}
 				fmt.Println(j)
 			} else {
-				// output system info as plain text
-				fmt.Println("System Info:")
-				fmt.Println("  OS:", s.System.OS)
-				fmt.Println("  Python Version:", s.System.PythonVersion)
-				fmt.Println("  Embedded Python:", s.System.EmbeddedPython)
-				fmt.Println("Devices:")
-				for _, d := range s.Devices {
-					fmt.Println("  Name:", d.Name)
-					fmt.Println("  Type:", d.Type)
-					fmt.Println("  Index:", d.Index)
-					fmt.Println("  VRAM Total:", d.VRAM_Total)
-					fmt.Println("  VRAM Free:", d.VRAM_Free)
-					fmt.Println("  Torch VRAM Total:", d.Torch_VRAM_Total)
-					fmt.Println("  Torch VRAM Free:", d.Torch_VRAM_Free)
-				}
+				fmt.Println(dashboard.Text(80))
 			}
 
-			// sleep for the specified interval
-			time.Sleep(time.Duration(interval) * time.Second)
+			time.Sleep(tick)
 		}
 	},
 }
 
 func InitTop(systemCmd *cobra.Command) {
 	topCmd.Flags().IntVarP(&interval, "interval", "i", 4, "Interval in seconds to update the top information")
+	topCmd.Flags().IntVarP(&topJobs, "jobs", "", 3, "Number of recent jobs to show for each host")
 	systemCmd.AddCommand(topCmd)
 }
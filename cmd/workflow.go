@@ -41,4 +41,6 @@ func init() {
 	workflow.InitApi(workflowCmd)
 	workflow.InitExtract(workflowCmd)
 	workflow.InitInject(workflowCmd)
+	workflow.InitEdit(workflowCmd)
+	workflow.InitCompose(workflowCmd)
 }
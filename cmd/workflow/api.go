@@ -12,11 +12,24 @@ import (
 	"golang.org/x/exp/slog"
 )
 
+var apiCreate []string
+var apiOutputs []string
+
 // apiCmd represents the api command
 var apiCmd = &cobra.Command{
 	Use:   "api [workflow file]",
 	Short: "Output the API for the workflow in json format",
-	Long:  `Output the API for the workflow in json format`,
+	Long: `Output the API for the workflow in json format.
+
+With --create, a Simple API group is added to the workflow and the new workflow json is output.
+Each value is given as name=node:widget.  A Primitive node titled with the name is added to the
+group and linked to the widget, which is converted to an input.  Output nodes given with --output
+are moved into the group.
+
+examples:
+# create a Simple API with a seed and a prompt value, saving the images of the "Save Image" node
+comfycli workflow api workflow.json --create "seed=KSampler:seed,prompt=CLIP Text Encode:text" --output "Save Image" -g api.json
+`,
 	// validate that a png file is provided
 	Args: func(cmd *cobra.Command, args []string) error {
 		if len(args) == 0 {
@@ -40,6 +53,35 @@ var apiCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
+		if len(apiCreate) > 0 || len(apiOutputs) > 0 {
+			err := util.CreateSimpleAPI(workflow.Graph, CLIOptions.API, apiCreate, apiOutputs)
+			if err != nil {
+				slog.Error("failed to create the Simple API", "error", err)
+				os.Exit(1)
+			}
+
+			j, err := util.ToJson(workflow.Graph, CLIOptions.PrettyJson)
+			if err != nil {
+				slog.Error("failed to convert graph to json", "error", err)
+				os.Exit(1)
+			}
+			if CLIOptions.GraphOutPath != "" {
+				d := []byte(j)
+				if err := util.SaveData(&d, CLIOptions.GraphOutPath); err != nil {
+					slog.Error("failed to save graph to file", "error", err)
+					os.Exit(1)
+				}
+			} else {
+				fmt.Println(j)
+			}
+			return
+		}
+
+		if workflow.SimpleAPI == nil {
+			slog.Error(fmt.Sprintf("the workflow has no %s group, create one with --create", CLIOptions.API))
+			os.Exit(1)
+		}
+
 		if CLIOptions.APIValuesOnly {
 			// create a slice of the API parameter values and serialize to json
 			_, err = util.ApplyParameters(nil, CLIOptions, workflow.Graph, workflow.SimpleAPI, parameters)
@@ -67,4 +109,7 @@ func InitApi(workflowCmd *cobra.Command) {
 	workflowCmd.AddCommand(apiCmd)
 
 	apiCmd.Flags().BoolVarP(&CLIOptions.APIValuesOnly, "values", "", false, "Output as values only")
+	apiCmd.Flags().StringSliceVar(&apiCreate, "create", nil, "Create a Simple API with the values, as name=node:widget")
+	apiCmd.Flags().StringSliceVar(&apiOutputs, "output", nil, "Output nodes to move into a created Simple API")
+	apiCmd.PersistentFlags().StringVarP(&CLIOptions.GraphOutPath, "graphout", "g", "", "Path to write workflow graph JSON")
 }
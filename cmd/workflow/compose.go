@@ -0,0 +1,117 @@
+/*
+Copyright © 2024 Rich Insley <richinsley@gmail.com>
+*/
+package workflow
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	util "github.com/richinsley/comfycli/pkg"
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slog"
+)
+
+var composeAttach []string
+var composePrefix []string
+var composeLink []string
+
+// composeCmd represents the compose command
+var composeCmd = &cobra.Command{
+	Use:   "compose [base workflow file]",
+	Short: "Merge workflows into one workflow and output the workflow json.",
+	Long: `Merge workflows into one workflow and output the workflow json.
+Each attached workflow is added below the base workflow, with its node and link ids moved past
+the ids of the base workflow.  Groups are kept, and the nodes in the Simple API group of an
+attached workflow are prefixed with "prefix." so the merged Simple API keeps a namespace for each
+workflow.  The prefix defaults to the name of the attached file.
+
+Links are given as "node.output->node.input".  The output is looked up in the base workflow
+first and the input in the attached workflows first, so a title used in both workflows links the
+base workflow to the attached one.
+
+examples:
+# feed the decoded image of a workflow to an upscale chain
+comfycli workflow compose base.json --attach upscale.json --link "VAE Decode.IMAGE->Upscale In.image" > composed.json
+
+# attach two sub-workflows, the API values of the detailer are named "face.<name>"
+comfycli workflow compose base.json --attach upscale.json --attach detailer.json --prefix up --prefix face -g composed.json
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(composeAttach) == 0 {
+			slog.Error("no workflow to attach, use --attach")
+			os.Exit(1)
+		}
+		if len(composePrefix) > len(composeAttach) {
+			slog.Error("more prefixes than attached workflows")
+			os.Exit(1)
+		}
+
+		base, missing, err := util.GetFullWorkflow(0, CLIOptions, args[0], nil)
+		if missing != nil {
+			slog.Error("failed to get workflow: missing nodes", "missing", fmt.Sprintf("%v", missing))
+			os.Exit(1)
+		}
+		if err != nil {
+			slog.Error("failed to get workflow", "error", err)
+			os.Exit(1)
+		}
+
+		attached := make(map[int]bool)
+		for i, path := range composeAttach {
+			workflow, missing, err := util.GetFullWorkflow(0, CLIOptions, path, nil)
+			if missing != nil {
+				slog.Error(fmt.Sprintf("failed to get workflow %s: missing nodes", path), "missing", fmt.Sprintf("%v", missing))
+				os.Exit(1)
+			}
+			if err != nil {
+				slog.Error(fmt.Sprintf("failed to get workflow %s", path), "error", err)
+				os.Exit(1)
+			}
+
+			prefix := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+			if i < len(composePrefix) {
+				prefix = composePrefix[i]
+			}
+			for id := range util.AttachGraph(base.Graph, workflow.Graph, CLIOptions.API, prefix) {
+				attached[id] = true
+			}
+		}
+
+		for _, l := range composeLink {
+			if err := util.LinkAttached(base.Graph, attached, l); err != nil {
+				slog.Error(fmt.Sprintf("failed to link %s", l), "error", err)
+				os.Exit(1)
+			}
+		}
+
+		j, err := util.ToJson(base.Graph, CLIOptions.PrettyJson)
+		if err != nil {
+			slog.Error("failed to convert graph to json", "error", err)
+			os.Exit(1)
+		}
+
+		if CLIOptions.GraphOutPath != "" {
+			d := []byte(j)
+			err := util.SaveData(&d, CLIOptions.GraphOutPath)
+			if err != nil {
+				slog.Error("failed to save graph to file", "error", err)
+				os.Exit(1)
+			}
+		} else {
+			fmt.Println(j)
+		}
+	},
+}
+
+func InitCompose(workflowCmd *cobra.Command) {
+	workflowCmd.AddCommand(composeCmd)
+
+	composeCmd.PersistentFlags().StringVarP(&CLIOptions.GraphOutPath, "graphout", "g", "", "Path to write workflow graph JSON")
+	composeCmd.Flags().StringArrayVar(&composeAttach, "attach", nil, "Workflow to attach to the base workflow")
+	composeCmd.Flags().StringArrayVar(&composePrefix, "prefix", nil, "Simple API prefix of each attached workflow, in the order of --attach")
+	composeCmd.Flags().StringArrayVar(&composeLink, "link", nil, "Link an output to an input, as node.output->node.input")
+}
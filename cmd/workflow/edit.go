@@ -0,0 +1,146 @@
+/*
+Copyright © 2024 Rich Insley <richinsley@gmail.com>
+*/
+package workflow
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	util "github.com/richinsley/comfycli/pkg"
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slog"
+)
+
+var editBypass []string
+var editMute []string
+var editEnable []string
+var editReplace []string
+var editLink []string
+var editUnlink []string
+
+// editCmd represents the edit command
+var editCmd = &cobra.Command{
+	Use:   "edit [workflow file]",
+	Short: "Bypass, mute, replace and rewire the nodes of a workflow.",
+	Long: `Bypass, mute, replace and rewire the nodes of a workflow and output the workflow json.
+Nodes are selected by title, by id, with "@Type" for every node of a type, "*Title" for every
+node with a title, and with a "[Group]/" prefix to limit the selection to a group.  Slots are
+addressed as "node.slot".  Outputs are matched by name, type or index, and inputs by name.
+The edits are applied in the order unlink, replace, link, enable, bypass and mute.  Parameters
+following the delimiter "--" are set before the edits.
+
+examples:
+# bypass the upscaler and mute node 12
+comfycli workflow edit workflow.json --bypass "Upscaler" --mute 12 > ab.json
+
+# swap the VAE decoder for the tiled decoder
+comfycli workflow edit workflow.json --replace "VAE Decode=VAEDecodeTiled" -g tiled.json
+
+# feed the sampler's latent straight to the decoder
+comfycli workflow edit workflow.json --link "KSampler.LATENT->VAE Decode.samples"
+`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		workflowPath := args[0]
+		params := args[1:] // All other args are considered parameters
+		parameters := util.ParseParameters(params)
+
+		workflow, _, missing, err := util.ClientWithWorkflow(0, CLIOptions, workflowPath, parameters, nil, true)
+		if missing != nil {
+			slog.Error("failed to get workflow: missing nodes", "missing", fmt.Sprintf("%v", missing))
+			os.Exit(1)
+		}
+		if err != nil {
+			slog.Error("error getting client and workflow", "error", err)
+			os.Exit(1)
+		}
+		graph := workflow.Graph
+
+		for _, u := range editUnlink {
+			if err := util.UnlinkSlot(graph, u); err != nil {
+				slog.Error(fmt.Sprintf("failed to unlink %s", u), "error", err)
+				os.Exit(1)
+			}
+		}
+
+		if len(editReplace) > 0 {
+			objects, err := workflow.Client.GetObjectInfos()
+			if err != nil {
+				slog.Error("failed to get node types", "error", err)
+				os.Exit(1)
+			}
+			for _, r := range editReplace {
+				// class types have no "=", titles may
+				split := strings.LastIndex(r, "=")
+				if split <= 0 {
+					slog.Error(fmt.Sprintf("invalid replace %s, expected node=type", r))
+					os.Exit(1)
+				}
+				if err := util.ReplaceNodeType(graph, r[:split], r[split+1:], objects); err != nil {
+					slog.Error(fmt.Sprintf("failed to replace %s", r[:split]), "error", err)
+					os.Exit(1)
+				}
+			}
+		}
+
+		for _, l := range editLink {
+			from, to, ok := strings.Cut(l, "->")
+			if !ok {
+				slog.Error(fmt.Sprintf("invalid link %s, expected node.output->node.input", l))
+				os.Exit(1)
+			}
+			if err := util.LinkSlots(graph, from, to); err != nil {
+				slog.Error(fmt.Sprintf("failed to link %s", l), "error", err)
+				os.Exit(1)
+			}
+		}
+
+		modes := []struct {
+			selectors []string
+			mode      int
+		}{
+			{editEnable, util.NodeModeAlways},
+			{editBypass, util.NodeModeBypass},
+			{editMute, util.NodeModeNever},
+		}
+		for _, m := range modes {
+			for _, s := range m.selectors {
+				if err := util.SetNodeMode(graph, s, m.mode); err != nil {
+					slog.Error("failed to set node mode", "error", err)
+					os.Exit(1)
+				}
+			}
+		}
+
+		j, err := util.ToJson(graph, CLIOptions.PrettyJson)
+		if err != nil {
+			slog.Error("failed to convert graph to json", "error", err)
+			os.Exit(1)
+		}
+
+		if CLIOptions.GraphOutPath != "" {
+			d := []byte(j)
+			err := util.SaveData(&d, CLIOptions.GraphOutPath)
+			if err != nil {
+				slog.Error("failed to save graph to file", "error", err)
+				os.Exit(1)
+			}
+		} else {
+			fmt.Println(j)
+		}
+	},
+}
+
+func InitEdit(workflowCmd *cobra.Command) {
+	workflowCmd.AddCommand(editCmd)
+
+	editCmd.PersistentFlags().StringVarP(&CLIOptions.GraphOutPath, "graphout", "g", "", "Path to write workflow graph JSON")
+	editCmd.Flags().StringArrayVar(&editBypass, "bypass", nil, "Bypass the selected nodes, passing their inputs through to their outputs")
+	editCmd.Flags().StringArrayVar(&editMute, "mute", nil, "Mute the selected nodes")
+	editCmd.Flags().StringArrayVar(&editEnable, "enable", nil, "Enable the selected nodes that are bypassed or muted")
+	editCmd.Flags().StringArrayVar(&editReplace, "replace", nil, "Replace the class of the selected nodes, as node=type")
+	editCmd.Flags().StringArrayVar(&editLink, "link", nil, "Link an output to an input, as node.output->node.input")
+	editCmd.Flags().StringArrayVar(&editUnlink, "unlink", nil, "Remove the link of an input, as node.input")
+}
@@ -6,7 +6,6 @@ package workflow
 import (
 	"fmt"
 	"os"
-	"time"
 
 	"github.com/richinsley/comfycli/pkg"
 	"github.com/spf13/cobra"
@@ -23,6 +22,8 @@ var queueCmd = &cobra.Command{
 Queue a workflow for processing. The first argument is the path to the workflow file.
 Set the parameters for the workflow by adding them as additional arguments after "--"
 Node parameters are set by providing the node name followed by the parameter name and value.
+Nodes can also be selected by id "(3)seed", by type "@KSampler:seed", all nodes with a title "*KSampler:seed"
+and within a group "[Group]/KSampler:seed".  Values starting with "@" are read from a file.
 When using a Simple API, parameters can be set by providing the parameter name and value.
 Nodes that output data save the data to the current working directory.  
 An optional file server can be started to serve the files by specifying "--servepath".  
@@ -33,12 +34,43 @@ examples:
 # Set the seed parameter for a node with the title "KSampler"
 comfycli workflow queue myworkflow.json -- KSampler:seed=1234
 
+# Set the seed of every KSampler node, and the text of the "Prompt" node in the group "Upscale" from a file
+comfycli workflow queue myworkflow.json -- "@KSampler:seed:int=1234" "[Upscale]/Prompt:text=@file:prompt.txt"
+
 # Use a workflow that has a Simple API that has a parameter named "seed"
 comfycli --api API workflow queue myworkflow_simple_api.json -- seed=1234
 
 # Queue a workflow, don't save images to disk, but output them to the terminal using the Inline Image Protocol
 comfycli workflow queue --inlineimages --nosavedata myworkflow.json -- KSampler:seed=1234
 
+# Queue a workflow and output the images to the terminal as sixels
+comfycli workflow queue --inlineimages --image-protocol sixel myworkflow.json -- KSampler:seed=1234
+
+# Queue a workflow and watch the sampling previews in the terminal while it runs
+comfycli workflow queue --preview auto myworkflow.json -- KSampler:seed=1234
+
+# Queue a workflow and save every sampling preview frame to a folder
+comfycli workflow queue --preview-dir ./previews myworkflow.json -- KSampler:seed=1234
+
+# Inpaint each image in a folder with the matching mask and a prompt from a text file
+comfycli workflow queue inpaint.json -- "Load Image:file=@dir:./images" "Load Image:mask=@dir:./masks" "Prompt:text=@lines:prompts.txt"
+
+# Queue a job for each row of a spreadsheet, the header row names the parameter for each column
+comfycli workflow queue --batch prompts.csv myworkflow.json
+
+# Queue a job for each image in a folder, saving the results with the name of the input image
+comfycli workflow queue --batch-dir "./inputs/*.png" img2img.json -- "Load Image:file={file}" "Save Image:filename_prefix={name}"
+
+# Queue a work item for each API values object, skipping the items finished before the run was interrupted
+comfycli --apivalues values.json workflow queue --resume state.json myworkflow_simple_api.json
+
+# Process a video frame by frame, reading and writing y4m streams
+ffmpeg -i input.mp4 -f yuv4mpegpipe - | comfycli --stdout workflow queue -n --stdout-format y4m img2img.json -- "Load Image:file=-" | ffmpeg -f yuv4mpegpipe -i - output.mp4
+
+# Profile the execution time and VRAM use of each node, and save a trace to load in Perfetto
+comfycli workflow queue --profile table myworkflow.json
+comfycli workflow queue --profile trace --profile-file trace.json myworkflow.json
+
 # Queue a workflow, and open a file server to serve files
 comfycli workflow queue myworkflow.json --serveport 8080 --servepath /path/to/files
 `,
@@ -47,12 +79,56 @@ comfycli workflow queue myworkflow.json --serveport 8080 --servepath /path/to/fi
 		params := args[1:] // All other args are considered parameters
 		parameters := pkg.ParseParameters(params)
 
+		// fail early on an unknown image protocol
+		if CLIOptions.InlineImages {
+			if _, err := pkg.ResolveImageProtocol(CLIOptions.ImageProtocol); err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+		}
+		if CLIOptions.DataToStdout {
+			if _, err := pkg.NewFrameWriter(nil, CLIOptions.StdoutFormat, ""); err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+		}
+		if err := pkg.CheckProfileFormat(CLIOptions.Profile); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		if CLIOptions.Preview != "" && CLIOptions.Preview != "none" {
+			if _, err := pkg.ResolveImageProtocol(CLIOptions.Preview); err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+		}
+
 		hasloop, err := pkg.TestParametersHasPipeLoop(CLIOptions, parameters)
 		if err != nil {
 			fmt.Println(err.Error())
 			os.Exit(1)
 		}
 
+		// skip the work items finished by an earlier run
+		resumePath, _ := cmd.Flags().GetString("resume")
+		if resumePath != "" {
+			CLIOptions.Resume, err = pkg.LoadResumeState(resumePath)
+			if err != nil {
+				fmt.Printf("error loading resume state: %v\n", err.Error())
+				os.Exit(1)
+			}
+		}
+
+		// a batch queues the workflow once for each row or file
+		var jobs []*pkg.BatchJob = nil
+		if CLIOptions.IsBatch() {
+			jobs, err = CLIOptions.GetBatchJobs(parameters)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+		}
+
 		// do we need to enable the file server?
 		servePort, _ := cmd.Flags().GetInt("serveport")
 		servePath, _ := cmd.Flags().GetString("servepath")
@@ -71,7 +147,7 @@ comfycli workflow queue myworkflow.json --serveport 8080 --servepath /path/to/fi
 			}
 		}
 
-		if hasloop && len(CLIOptions.Host) > 1 {
+		if (hasloop || jobs != nil) && len(CLIOptions.Host) > 1 {
 			// get the workflows for each host that can process the workflow
 			// the workers channel is filled asynchronously as the workflows are created
 			tmpworkers := pkg.GetWorkflowsAsync(CLIOptions, workflowPath, parameters)
@@ -99,35 +175,14 @@ comfycli workflow queue myworkflow.json --serveport 8080 --servepath /path/to/fi
 				fmt.Println("No client could be created to process the workflow")
 				os.Exit(1)
 			} else if workercount == 1 {
-				// Process the queue on a single client. If there was a pipe loop, process it again
-				for {
-					hasPipeLoop, err := pkg.ProcessQueue(CLIOptions, workflowPath, parameters)
-					if err != nil && hasloop {
-						// not an actual error, just ran out of parameter inputs
-						break
-					}
-
-					if !hasPipeLoop {
-						break
-					}
-				}
+				queueProcess(workflowPath, parameters, hasloop, jobs)
 			} else {
 				// should the results be ordered?
 				ordered, _ := cmd.Flags().GetBool("ordered")
-				batchQueueProcess(workercount, workers, parameters, ordered)
+				batchQueueProcess(workercount, workers, parameters, jobs, ordered)
 			}
 		} else {
-			// Process the queue on a single client. If there was a pipe loop, process it again
-			for {
-				hasPipeLoop, err := pkg.ProcessQueue(CLIOptions, workflowPath, parameters)
-				if err != nil && hasloop {
-					// not an actual error, just ran out of parameter inputs
-					break
-				}
-				if !hasPipeLoop {
-					break
-				}
-			}
+			queueProcess(workflowPath, parameters, hasloop, jobs)
 		}
 
 		if filesrv != nil {
@@ -136,7 +191,37 @@ comfycli workflow queue myworkflow.json --serveport 8080 --servepath /path/to/fi
 	},
 }
 
-func batchQueueProcess(workercount int, workers chan *pkg.WorkflowQueueProcessor, parameters []pkg.CLIParameter, ordered bool) {
+// queueProcess processes the queue on a single client. If there was a pipe loop, process it again
+func queueProcess(workflowPath string, parameters []pkg.CLIParameter, hasloop bool, jobs []*pkg.BatchJob) {
+	if jobs != nil {
+		for i, job := range jobs {
+			reportBatchProgress(i, len(jobs), job)
+			if _, err := pkg.ProcessQueue(CLIOptions, workflowPath, job.Apply(parameters)); err != nil {
+				// a parameter source ran out of input
+				break
+			}
+		}
+		return
+	}
+
+	for {
+		hasPipeLoop, err := pkg.ProcessQueue(CLIOptions, workflowPath, parameters)
+		if err != nil && hasloop {
+			// not an actual error, just ran out of parameter inputs
+			break
+		}
+		if !hasPipeLoop {
+			break
+		}
+	}
+}
+
+// reportBatchProgress writes the job about to be queued to stderr
+func reportBatchProgress(index int, count int, job *pkg.BatchJob) {
+	fmt.Fprintf(os.Stderr, "Batch job %d of %d: %s\n", index+1, count, job.Source)
+}
+
+func batchQueueProcess(workercount int, workers chan *pkg.WorkflowQueueProcessor, parameters []pkg.CLIParameter, jobs []*pkg.BatchJob, ordered bool) {
 	deadcount := 0
 	workitem := 0
 	var dataitems chan pkg.WorkflowQueueDataOutputItems = nil
@@ -149,7 +234,9 @@ func batchQueueProcess(workercount int, workers chan *pkg.WorkflowQueueProcessor
 	nextExpectedItem := 0
 
 	// Process the received data items concurrently
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		for item := range dataitems {
 			receivedItems[item.WorkItem] = item
 
@@ -157,9 +244,7 @@ func batchQueueProcess(workercount int, workers chan *pkg.WorkflowQueueProcessor
 			for {
 				if item, ok := receivedItems[nextExpectedItem]; ok {
 					// Process the items
-					for _, v := range item.Outputs {
-						pkg.HandleDataOutput(item.Client, CLIOptions, v)
-					}
+					pkg.HandleWorkItemOutputs(CLIOptions, item)
 
 					// Remove the processed item from the map
 					delete(receivedItems, nextExpectedItem)
@@ -174,6 +259,10 @@ func batchQueueProcess(workercount int, workers chan *pkg.WorkflowQueueProcessor
 
 	for {
 		w := <-workers
+		if w != nil && jobs != nil && workitem >= len(jobs) {
+			// the batch is done, retire the worker
+			w = nil
+		}
 		if w == nil {
 			deadcount++
 			if deadcount == workercount {
@@ -181,7 +270,13 @@ func batchQueueProcess(workercount int, workers chan *pkg.WorkflowQueueProcessor
 			}
 			continue
 		}
-		pkg.ProcessWorkerQueue(w, CLIOptions, parameters, workers, workitem, dataitems)
+
+		params := parameters
+		if jobs != nil {
+			reportBatchProgress(workitem, len(jobs), jobs[workitem])
+			params = jobs[workitem].Apply(parameters)
+		}
+		pkg.ProcessWorkerQueue(w, CLIOptions, params, workers, workitem, dataitems)
 		workitem++
 	}
 
@@ -190,24 +285,40 @@ func batchQueueProcess(workercount int, workers chan *pkg.WorkflowQueueProcessor
 		close(dataitems)
 
 		// Wait for all data items to be processed
-		for len(receivedItems) > 0 {
-			// Sleep for a short duration to avoid busy waiting
-			time.Sleep(time.Millisecond)
-		}
+		<-done
 	}
 }
 
 func InitQueue(workflowCmd *cobra.Command) {
-	queueCmd.Flags().BoolVarP(&CLIOptions.InlineImages, "inlineimages", "i", false, "Output images to terminal with Inline Image Protocol")
+	queueCmd.Flags().BoolVarP(&CLIOptions.InlineImages, "inlineimages", "i", false, "Output images to terminal")
+	queueCmd.Flags().StringVarP(&CLIOptions.ImageProtocol, "image-protocol", "", "auto", "Protocol for terminal image output (auto, iterm, sixel, kitty, ansi)")
 	queueCmd.Flags().BoolVarP(&CLIOptions.NoSaveData, "nosavedata", "n", false, "Do not save data to disk")
 	queueCmd.Flags().StringVarP(&CLIOptions.OutputNodes, "outputnodes", "o", "", "Specify which output nodes save data. Comma separated nodes. Default is all nodes")
 
+	// live sampling previews
+	queueCmd.Flags().StringVarP(&CLIOptions.Preview, "preview", "", "", "Render sampling previews in place in the terminal (auto, iterm, sixel, kitty, ansi)")
+	queueCmd.Flags().StringVarP(&CLIOptions.PreviewDir, "preview-dir", "", "", "Path to write sampling preview frames to")
+
+	// per node execution profiles
+	queueCmd.Flags().StringVarP(&CLIOptions.Profile, "profile", "", "", "Profile the execution of each node and write it as a table, json or a Chrome trace (table, json, trace)")
+	queueCmd.Flags().StringVarP(&CLIOptions.ProfileFile, "profile-file", "", "", "Path to write the profiles of the run to, stderr by default")
+
 	// port to serve files on
 	queueCmd.Flags().IntP("serveport", "", 8080, "File server port to serve files on")
 
 	// storage path for uploaded files
 	queueCmd.Flags().StringP("servepath", "", "", "Path to serve/reveive files from")
 
+	// format of the stream written to stdout with --stdout
+	queueCmd.Flags().StringVarP(&CLIOptions.StdoutFormat, "stdout-format", "", "raw", "Stream format for output data written with --stdout (raw, png, mjpeg, y4m)")
+
+	// batch runs
+	queueCmd.Flags().StringVarP(&CLIOptions.BatchFile, "batch", "", "", "CSV or TSV file with a job on each row, the header names the parameter for each column")
+	queueCmd.Flags().StringVarP(&CLIOptions.BatchDir, "batch-dir", "", "", "Folder or glob pattern of input files, one job per file substituted for {file}")
+
+	// state file for resumable runs
+	queueCmd.Flags().StringP("resume", "", "", "Path to a state file recording finished work items, to skip them when the run is restarted")
+
 	// flag to indicate we should maintain order of the queue results
 	queueCmd.Flags().BoolP("ordered", "", false, "Maintain the order of the queue results")
 
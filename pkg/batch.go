@@ -0,0 +1,191 @@
+package pkg
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// BatchJob is a single job of a batch run, a row of a batch file or a file matched by a
+// batch directory
+type BatchJob struct {
+	// Source identifies the job, such as "prompts.csv:12" or the path of the input file
+	Source string
+	// Fields are substituted for "{name}" placeholders in parameter values.  They are the
+	// columns of a batch file row, or "file" and "name" for a batch directory
+	Fields map[string]string
+	// Parameters are the columns of a batch file row that address API values or node properties
+	Parameters []CLIParameter
+}
+
+// IsBatch returns true if the workflow is queued once for each job of a batch file or directory
+func (o *ComfyOptions) IsBatch() bool {
+	return o.BatchFile != "" || o.BatchDir != ""
+}
+
+// GetBatchJobs returns the jobs of the batch file or batch directory
+func (o *ComfyOptions) GetBatchJobs(parameters []CLIParameter) ([]*BatchJob, error) {
+	var jobs []*BatchJob
+	var err error
+	if o.BatchFile != "" && o.BatchDir != "" {
+		return nil, fmt.Errorf("--batch and --batch-dir cannot be used together")
+	}
+	if o.APIValues != "" {
+		return nil, fmt.Errorf("a batch and --apivalues cannot be used together")
+	}
+	if o.BatchFile != "" {
+		jobs, err = ReadBatchFile(o.BatchFile, parameters)
+	} else {
+		jobs, err = GlobBatchDir(o.BatchDir, parameters)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// ReadBatchFile reads a CSV or TSV file with a job on each row.  The header row names the
+// parameter each column sets, either a Simple API value ("seed") or a node property
+// ("KSampler:seed" or "(3)seed").  Columns used as "{column}" placeholders in the parameters
+// only fill the placeholders.  Empty cells leave the parameter unchanged.
+func ReadBatchFile(path string, parameters []CLIParameter) ([]*BatchJob, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := csv.NewReader(strings.NewReader(string(data)))
+	r.Comma = batchDelimiter(path, string(data))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("batch file %s is empty", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read batch file %s: %w", path, err)
+	}
+	// the first column may start with a UTF-8 byte order mark from spreadsheet exports
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+
+	// parse the address of each column once
+	columns := make([]*CLIParameter, len(header))
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+		if header[i] == "" || usesPlaceholder(parameters, header[i]) {
+			continue
+		}
+		parsed, err := ParseParameter(header[i] + "=")
+		if err != nil {
+			return nil, fmt.Errorf("batch file %s has an invalid column %q: %w", path, header[i], err)
+		}
+		columns[i] = &parsed
+	}
+
+	var jobs []*BatchJob
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read batch file %s: %w", path, err)
+		}
+		line, _ := r.FieldPos(0)
+
+		job := &BatchJob{
+			Source: fmt.Sprintf("%s:%d", path, line),
+			Fields: make(map[string]string),
+		}
+		for i, value := range record {
+			if i >= len(header) || header[i] == "" {
+				continue
+			}
+			job.Fields[header[i]] = value
+			if value == "" || columns[i] == nil {
+				continue
+			}
+			param := *columns[i]
+			param.Value = value
+			job.Parameters = append(job.Parameters, param)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// GlobBatchDir returns a job for each file matching the pattern, or each file in the folder
+// when the pattern is a folder.  The path of the file is substituted for "{file}" and its name
+// without the extension for "{name}".
+func GlobBatchDir(pattern string, parameters []CLIParameter) ([]*BatchJob, error) {
+	if !usesPlaceholder(parameters, "file") {
+		return nil, fmt.Errorf(`--batch-dir needs a parameter set to "{file}", such as "Load Image:file={file}"`)
+	}
+
+	if fi, err := os.Stat(pattern); err == nil && fi.IsDir() {
+		pattern = filepath.Join(pattern, "*")
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+
+	var jobs []*BatchJob
+	for _, match := range matches {
+		if fi, err := os.Stat(match); err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		base := filepath.Base(match)
+		jobs = append(jobs, &BatchJob{
+			Source: match,
+			Fields: map[string]string{
+				"file": match,
+				"name": strings.TrimSuffix(base, filepath.Ext(base)),
+			},
+		})
+	}
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("no files match %s", pattern)
+	}
+	return jobs, nil
+}
+
+// Apply returns the parameters for the job, with the placeholders in the values replaced by
+// the job's fields, followed by the parameters set by the job
+func (j *BatchJob) Apply(parameters []CLIParameter) []CLIParameter {
+	retv := make([]CLIParameter, 0, len(parameters)+len(j.Parameters))
+	for _, p := range parameters {
+		for name, value := range j.Fields {
+			p.Value = strings.ReplaceAll(p.Value, "{"+name+"}", value)
+		}
+		retv = append(retv, p)
+	}
+	return append(retv, j.Parameters...)
+}
+
+// batchDelimiter returns tab for .tsv files or a header row with tabs and no commas
+func batchDelimiter(path string, data string) rune {
+	if strings.EqualFold(filepath.Ext(path), ".tsv") {
+		return '\t'
+	}
+	header, _, _ := strings.Cut(data, "\n")
+	if strings.Contains(header, "\t") && !strings.Contains(header, ",") {
+		return '\t'
+	}
+	return ','
+}
+
+func usesPlaceholder(parameters []CLIParameter, name string) bool {
+	for _, p := range parameters {
+		if strings.Contains(p.Value, "{"+name+"}") {
+			return true
+		}
+	}
+	return false
+}
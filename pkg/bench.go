@@ -0,0 +1,371 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// BenchOptions are the settings of a benchmark
+type BenchOptions struct {
+	Runs      int     // measured runs on each host
+	Warmup    int     // runs before the measured runs, to load the models
+	Seed      string  // "random" sets a new seed for each run, "fixed" keeps the seeds of the workflow
+	Threshold float64 // percent a measure can be worse than the baseline before it is a regression
+}
+
+// LatencyStats are the latencies of the runs of a benchmark, in seconds
+type LatencyStats struct {
+	Mean float64 `json:"mean"`
+	Min  float64 `json:"min"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P95  float64 `json:"p95"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// NodeBench is the time spent in a node over the runs of a benchmark
+type NodeBench struct {
+	NodeID   int     `json:"node_id"`
+	Title    string  `json:"title"`
+	Type     string  `json:"type"`
+	Executed int     `json:"executed"` // runs the node executed in, it was cached in the others
+	Mean     float64 `json:"mean"`     // seconds per execution
+	Share    float64 `json:"share"`    // percent of the total latency
+}
+
+// BenchResult is the benchmark of a workflow on a host
+type BenchResult struct {
+	Host             string       `json:"host"`
+	Device           string       `json:"device,omitempty"`
+	Error            string       `json:"error,omitempty"`
+	Runs             int          `json:"runs"`
+	Failed           int          `json:"failed"`
+	Latency          LatencyStats `json:"latency"`
+	PromptsPerMinute float64      `json:"prompts_per_minute"`
+	PeakVRAM         int64        `json:"peak_vram"`
+	Nodes            []NodeBench  `json:"nodes"`
+	Regressions      []string     `json:"regressions,omitempty"`
+}
+
+// BenchReport is the benchmark of a workflow on every host
+type BenchReport struct {
+	Workflow string        `json:"workflow"`
+	Started  time.Time     `json:"started"`
+	Runs     int           `json:"runs"`
+	Warmup   int           `json:"warmup"`
+	Seed     string        `json:"seed"`
+	Results  []BenchResult `json:"results"`
+}
+
+// LoadBenchReport reads a benchmark report saved as json
+func LoadBenchReport(path string) (*BenchReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	report := &BenchReport{}
+	if err := json.Unmarshal(data, report); err != nil {
+		return nil, fmt.Errorf("failed to parse benchmark %s: %v", path, err)
+	}
+	return report, nil
+}
+
+// Bench queues a workflow repeatedly on every host, the hosts at the same time and the runs on
+// each host one after the other.  Every host is given the same parameter values.  Progress is
+// written to stderr.
+func Bench(options *ComfyOptions, workflowpath string, parameters []CLIParameter, bench BenchOptions) *BenchReport {
+	report := &BenchReport{
+		Workflow: workflowpath,
+		Started:  time.Now(),
+		Runs:     bench.Runs,
+		Warmup:   bench.Warmup,
+		Seed:     bench.Seed,
+		Results:  make([]BenchResult, len(options.Host)),
+	}
+
+	workflows, missing, errs := ClientsWithWorkflows(options, workflowpath, parameters)
+	var wg sync.WaitGroup
+	for i := range options.Host {
+		result := BenchResult{Host: fmt.Sprintf("%s:%d", options.Host[i], options.Port[i]), Nodes: make([]NodeBench, 0)}
+		if missing[i] != nil {
+			result.Error = fmt.Sprintf("missing nodes %v", *missing[i])
+			report.Results[i] = result
+			continue
+		}
+		if errs[i] != nil {
+			result.Error = errs[i].Error()
+			report.Results[i] = result
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			report.Results[i] = benchHost(workflows[i], options, result, bench)
+		}(i)
+	}
+	wg.Wait()
+	return report
+}
+
+func benchHost(workflow *Workflow, options *ComfyOptions, result BenchResult, bench BenchOptions) BenchResult {
+	if stats, err := workflow.Client.GetSystemStats(); err == nil && len(stats.Devices) > 0 {
+		result.Device = stats.Devices[0].Name
+	}
+
+	var latencies []float64
+	var profiles []*PromptProfile
+	var elapsed time.Duration
+	for run := 0; run < bench.Warmup+bench.Runs; run++ {
+		if bench.Seed == "random" {
+			randomizeSeeds(workflow)
+		}
+
+		start := time.Now()
+		profile, err := benchRun(workflow, options)
+		latency := time.Since(start)
+
+		label := fmt.Sprintf("run %d/%d", run-bench.Warmup+1, bench.Runs)
+		if run < bench.Warmup {
+			label = fmt.Sprintf("warmup %d/%d", run+1, bench.Warmup)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s failed: %v\n", result.Host, label, err)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: %s %s\n", result.Host, label, latency.Round(time.Millisecond))
+		}
+		if run < bench.Warmup {
+			continue
+		}
+
+		result.Runs++
+		if err != nil {
+			result.Failed++
+			continue
+		}
+		elapsed += latency
+		latencies = append(latencies, latency.Seconds())
+		profiles = append(profiles, profile)
+		result.PeakVRAM = max(result.PeakVRAM, profile.PeakVRAM)
+	}
+
+	if len(latencies) == 0 {
+		return result
+	}
+	result.Latency = latencyStats(latencies)
+	result.PromptsPerMinute = float64(len(latencies)) / elapsed.Minutes()
+	result.Nodes = nodeBenches(profiles, elapsed)
+	return result
+}
+
+// benchRun queues the workflow and waits for it to finish, discarding its outputs
+func benchRun(workflow *Workflow, options *ComfyOptions) (*PromptProfile, error) {
+	item, err := queuePrompt(workflow, options)
+	if err != nil {
+		return nil, err
+	}
+	profiler := newProfiler(workflow, options, item.PromptID)
+	for {
+		msg := <-item.Messages
+		switch msg.Type {
+		case "started":
+			profiler.Started()
+		case "executing":
+			profiler.Executing(msg.ToPromptMessageExecuting().NodeID)
+		case "stopped":
+			qm := msg.ToPromptMessageStopped()
+			profile := profiler.Stopped(qm.Exception)
+			if qm.Exception != nil {
+				return nil, fmt.Errorf("exception in node %s: %s", qm.Exception.NodeName, qm.Exception.ExceptionMessage)
+			}
+			return profile, nil
+		}
+	}
+}
+
+// randomizeSeeds sets a new value for every seed of the workflow, within the seed's range
+func randomizeSeeds(workflow *Workflow) {
+	for _, n := range workflow.Graph.Nodes {
+		for name, p := range n.Properties {
+			if (name != "seed" && name != "noise_seed") || p.TypeString() != "INT" {
+				continue
+			}
+			low, high := int64(0), int64(1<<50)
+			if ip, ok := p.ToIntProperty(); ok && ip.Max > ip.Min {
+				low, high = ip.Min, min(ip.Max, high)
+			}
+			p.SetValue(low + rand.Int63n(high-low+1))
+		}
+	}
+}
+
+// latencyStats returns the nearest rank percentiles of the latencies
+func latencyStats(latencies []float64) LatencyStats {
+	sorted := append([]float64{}, latencies...)
+	sort.Float64s(sorted)
+	percentile := func(p float64) float64 {
+		rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+		return sorted[max(0, min(rank, len(sorted)-1))]
+	}
+	sum := 0.0
+	for _, l := range sorted {
+		sum += l
+	}
+	return LatencyStats{
+		Mean: sum / float64(len(sorted)),
+		Min:  sorted[0],
+		P50:  percentile(50),
+		P90:  percentile(90),
+		P95:  percentile(95),
+		P99:  percentile(99),
+		Max:  sorted[len(sorted)-1],
+	}
+}
+
+// nodeBenches sums the time spent in each node over the runs, slowest first
+func nodeBenches(profiles []*PromptProfile, elapsed time.Duration) []NodeBench {
+	byID := make(map[int]*NodeBench)
+	totals := make(map[int]time.Duration)
+	for _, profile := range profiles {
+		for _, n := range profile.Nodes {
+			if n.Skipped {
+				continue
+			}
+			b, ok := byID[n.NodeID]
+			if !ok {
+				b = &NodeBench{NodeID: n.NodeID, Title: n.Title, Type: n.Type}
+				byID[n.NodeID] = b
+			}
+			if !n.Cached {
+				b.Executed++
+				totals[n.NodeID] += n.Duration
+			}
+		}
+	}
+
+	nodes := make([]NodeBench, 0, len(byID))
+	for id, b := range byID {
+		if b.Executed > 0 {
+			b.Mean = totals[id].Seconds() / float64(b.Executed)
+		}
+		if elapsed > 0 {
+			b.Share = float64(totals[id]) * 100 / float64(elapsed)
+		}
+		nodes = append(nodes, *b)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if totals[nodes[i].NodeID] != totals[nodes[j].NodeID] {
+			return totals[nodes[i].NodeID] > totals[nodes[j].NodeID]
+		}
+		return nodes[i].NodeID < nodes[j].NodeID
+	})
+	return nodes
+}
+
+// CompareBench flags the measures of each result that are worse than the baseline result of the
+// same host by more than the threshold percent.  A baseline with a single result is compared
+// with every host, to compare hosts with a reference host.  The number of regressions is
+// returned.
+func CompareBench(report *BenchReport, baseline *BenchReport, threshold float64) int {
+	count := 0
+	for i := range report.Results {
+		result := &report.Results[i]
+		base := baselineResult(baseline, result.Host)
+		if base == nil || result.Error != "" || len(result.Nodes) == 0 {
+			continue
+		}
+
+		// larger is worse for latencies and node times
+		worse := func(name string, value float64, was float64) {
+			if was <= 0 {
+				return
+			}
+			change := (value - was) * 100 / was
+			if change > threshold {
+				result.Regressions = append(result.Regressions, fmt.Sprintf("%s %.3fs, baseline %.3fs (%+.1f%%)", name, value, was, change))
+			}
+		}
+		worse("p50 latency", result.Latency.P50, base.Latency.P50)
+		worse("p95 latency", result.Latency.P95, base.Latency.P95)
+		if base.PromptsPerMinute > 0 {
+			change := (result.PromptsPerMinute - base.PromptsPerMinute) * 100 / base.PromptsPerMinute
+			if -change > threshold {
+				result.Regressions = append(result.Regressions, fmt.Sprintf("throughput %.2f/min, baseline %.2f/min (%+.1f%%)", result.PromptsPerMinute, base.PromptsPerMinute, change))
+			}
+		}
+		for _, n := range result.Nodes {
+			for _, b := range base.Nodes {
+				if b.NodeID == n.NodeID && b.Type == n.Type && n.Executed > 0 && b.Executed > 0 {
+					worse(fmt.Sprintf("node %s (%d)", n.Title, n.NodeID), n.Mean, b.Mean)
+				}
+			}
+		}
+		if result.Failed > base.Failed {
+			result.Regressions = append(result.Regressions, fmt.Sprintf("%d failed runs, baseline %d", result.Failed, base.Failed))
+		}
+		count += len(result.Regressions)
+	}
+	return count
+}
+
+func baselineResult(baseline *BenchReport, host string) *BenchResult {
+	for i := range baseline.Results {
+		if baseline.Results[i].Host == host {
+			return &baseline.Results[i]
+		}
+	}
+	if len(baseline.Results) == 1 {
+		return &baseline.Results[0]
+	}
+	return nil
+}
+
+// WriteBenchReport writes a benchmark report as text
+func WriteBenchReport(w io.Writer, report *BenchReport) {
+	for _, r := range report.Results {
+		fmt.Fprintf(w, "%s", r.Host)
+		if r.Device != "" {
+			fmt.Fprintf(w, " (%s)", r.Device)
+		}
+		if r.Error != "" {
+			fmt.Fprintf(w, ": %s\n\n", r.Error)
+			continue
+		}
+		fmt.Fprintf(w, ": %d runs, %d failed, %d warmup, %s seed\n", r.Runs, r.Failed, report.Warmup, report.Seed)
+		if r.Runs == r.Failed {
+			fmt.Fprintln(w)
+			continue
+		}
+
+		l := r.Latency
+		fmt.Fprintf(w, "  latency     mean %.3fs  min %.3fs  p50 %.3fs  p90 %.3fs  p95 %.3fs  p99 %.3fs  max %.3fs\n", l.Mean, l.Min, l.P50, l.P90, l.P95, l.P99, l.Max)
+		fmt.Fprintf(w, "  throughput  %.2f prompts/min\n", r.PromptsPerMinute)
+		if r.PeakVRAM > 0 {
+			fmt.Fprintf(w, "  peak VRAM   %.2f GB\n", float64(r.PeakVRAM)/(1<<30))
+		}
+
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  ID\tNODE\tTYPE\tEXECUTED\tMEAN\t%")
+		for _, n := range r.Nodes {
+			if n.Executed == 0 {
+				fmt.Fprintf(tw, "  %d\t%s\t%s\t0/%d\tcached\t-\n", n.NodeID, n.Title, n.Type, r.Runs-r.Failed)
+				continue
+			}
+			fmt.Fprintf(tw, "  %d\t%s\t%s\t%d/%d\t%.3fs\t%.1f\n", n.NodeID, n.Title, n.Type, n.Executed, r.Runs-r.Failed, n.Mean, n.Share)
+		}
+		tw.Flush()
+
+		for _, regression := range r.Regressions {
+			fmt.Fprintf(w, "  REGRESSION %s\n", regression)
+		}
+		fmt.Fprintln(w)
+	}
+}
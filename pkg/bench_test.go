@@ -0,0 +1,121 @@
+package pkg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyStats(t *testing.T) {
+	latencies := make([]float64, 0, 100)
+	for i := 100; i >= 1; i-- {
+		latencies = append(latencies, float64(i))
+	}
+	got := latencyStats(latencies)
+	want := LatencyStats{Mean: 50.5, Min: 1, P50: 50, P90: 90, P95: 95, P99: 99, Max: 100}
+	if got != want {
+		t.Errorf("latencyStats(1..100) = %+v, want %+v", got, want)
+	}
+	if latencies[0] != 100 {
+		t.Error("latencyStats sorted the latencies of the caller")
+	}
+
+	got = latencyStats([]float64{2})
+	want = LatencyStats{Mean: 2, Min: 2, P50: 2, P90: 2, P95: 2, P99: 2, Max: 2}
+	if got != want {
+		t.Errorf("latencyStats(2) = %+v, want %+v", got, want)
+	}
+
+	got = latencyStats([]float64{3, 1, 2, 4})
+	if got.P50 != 2 || got.P90 != 4 || got.Mean != 2.5 {
+		t.Errorf("latencyStats(3, 1, 2, 4) = %+v, want p50 2, p90 4 and mean 2.5", got)
+	}
+}
+
+func TestNodeBenches(t *testing.T) {
+	profiles := []*PromptProfile{
+		{Nodes: []*NodeProfile{
+			{NodeID: 1, Type: "CheckpointLoaderSimple", Duration: 2 * time.Second},
+			{NodeID: 2, Type: "KSampler", Duration: 3 * time.Second},
+		}},
+		{Nodes: []*NodeProfile{
+			{NodeID: 2, Type: "KSampler", Duration: 5 * time.Second},
+			{NodeID: 1, Type: "CheckpointLoaderSimple", Cached: true},
+			{NodeID: 3, Type: "PreviewImage", Skipped: true},
+		}},
+	}
+	nodes := nodeBenches(profiles, 10*time.Second)
+	if len(nodes) != 2 {
+		t.Fatalf("got %d nodes, want 2", len(nodes))
+	}
+	if n := nodes[0]; n.NodeID != 2 || n.Executed != 2 || n.Mean != 4 || n.Share != 80 {
+		t.Errorf("slowest node = %+v, want node 2 executed twice for 4s and 80%%", n)
+	}
+	if n := nodes[1]; n.NodeID != 1 || n.Executed != 1 || n.Mean != 2 || n.Share != 20 {
+		t.Errorf("second node = %+v, want node 1 executed once for 2s and 20%%", n)
+	}
+}
+
+func benchResult(host string, p50 float64, ppm float64, sampler float64) BenchResult {
+	return BenchResult{
+		Host:             host,
+		Runs:             5,
+		Latency:          LatencyStats{P50: p50, P95: p50},
+		PromptsPerMinute: ppm,
+		Nodes:            []NodeBench{{NodeID: 3, Title: "KSampler", Type: "KSampler", Executed: 5, Mean: sampler}},
+	}
+}
+
+func TestCompareBench(t *testing.T) {
+	baseline := &BenchReport{Results: []BenchResult{
+		benchResult("a:8188", 10, 6, 8),
+		benchResult("b:8188", 10, 6, 8),
+	}}
+	report := &BenchReport{Results: []BenchResult{
+		// within the threshold
+		benchResult("a:8188", 10.4, 5.8, 8.3),
+		// slower latency, throughput and sampler
+		benchResult("b:8188", 12, 5, 10),
+		// no baseline for the host
+		benchResult("c:8188", 20, 3, 16),
+	}}
+
+	if count := CompareBench(report, baseline, 5); count != 4 {
+		t.Errorf("CompareBench found %d regressions, want 4: %v", count, report.Results[1].Regressions)
+	}
+	if len(report.Results[0].Regressions) != 0 {
+		t.Errorf("regressions within the threshold: %v", report.Results[0].Regressions)
+	}
+	if len(report.Results[2].Regressions) != 0 {
+		t.Errorf("regressions for a host without a baseline: %v", report.Results[2].Regressions)
+	}
+	for _, want := range []string{"p50 latency", "p95 latency", "throughput", "node KSampler (3)"} {
+		found := false
+		for _, r := range report.Results[1].Regressions {
+			found = found || strings.HasPrefix(r, want)
+		}
+		if !found {
+			t.Errorf("missing %s regression in %v", want, report.Results[1].Regressions)
+		}
+	}
+
+	var out bytes.Buffer
+	WriteBenchReport(&out, report)
+	if !strings.Contains(out.String(), "REGRESSION throughput") {
+		t.Errorf("report does not show the regressions:\n%s", out.String())
+	}
+}
+
+func TestCompareBenchSingleBaseline(t *testing.T) {
+	// a baseline of one host is the reference for every host
+	baseline := &BenchReport{Results: []BenchResult{benchResult("ref:8188", 10, 6, 8)}}
+	report := &BenchReport{Results: []BenchResult{
+		benchResult("a:8188", 10, 6, 8),
+		benchResult("b:8188", 20, 6, 8),
+	}}
+	CompareBench(report, baseline, 5)
+	if len(report.Results[0].Regressions) != 0 || len(report.Results[1].Regressions) != 2 {
+		t.Errorf("regressions = %v and %v, want none and the two latencies", report.Results[0].Regressions, report.Results[1].Regressions)
+	}
+}
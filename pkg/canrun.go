@@ -0,0 +1,195 @@
+package pkg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/richinsley/comfy2go/client"
+	"github.com/richinsley/comfy2go/graphapi"
+)
+
+// MissingComboValue is a combo value of a workflow that a host does not offer, usually a model
+type MissingComboValue struct {
+	NodeTitle     string `json:"node_title"`
+	NodeType      string `json:"node_type"`
+	PropertyName  string `json:"property_name"`
+	PropertyValue string `json:"property_value"`
+}
+
+// NodeSource is a custom node repo that provides a missing node type, and the recipes that
+// install the repo
+type NodeSource struct {
+	Repo    string   `json:"repo"`
+	Recipes []string `json:"recipes,omitempty"`
+}
+
+// CanRunResult tells if a host can run a workflow, and what it is missing when it can't
+type CanRunResult struct {
+	Host               string                  `json:"host"`
+	CanRun             bool                    `json:"canrun"`
+	Error              string                  `json:"error,omitempty"`
+	MissingNodes       []string                `json:"missing_nodes,omitempty"`
+	MissingComboValues []MissingComboValue     `json:"missing_combo_values,omitempty"`
+	Suggestions        map[string][]NodeSource `json:"suggestions,omitempty"` // node type to the repos providing it
+}
+
+// CanRun tests if every host can run a workflow with the given parameters.  The hosts are
+// tested at the same time, and the results are in the order of the hosts.
+func CanRun(options *ComfyOptions, workflowpath string, parameters []CLIParameter) []*CanRunResult {
+	results := make([]*CanRunResult, len(options.Host))
+	workflows, missing, errs := ClientsWithWorkflows(options, workflowpath, parameters)
+	done := make(chan struct{})
+	for i := range options.Host {
+		go func(i int) {
+			results[i] = canRunHost(i, options, workflows[i], missing[i], errs[i])
+			done <- struct{}{}
+		}(i)
+	}
+	for range options.Host {
+		<-done
+	}
+	return results
+}
+
+func canRunHost(i int, options *ComfyOptions, workflow *Workflow, missing *[]string, err error) *CanRunResult {
+	result := &CanRunResult{Host: fmt.Sprintf("%s:%d", options.Host[i], options.Port[i])}
+	if missing != nil {
+		// without a graph the combo values can't be checked
+		result.MissingNodes = uniqueSorted(*missing)
+		return result
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	combos, unknown, err := MissingCombos(workflow.Client, workflow.Graph)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.MissingNodes = unknown
+	result.MissingComboValues = combos
+	result.CanRun = len(unknown) == 0 && len(combos) == 0
+	return result
+}
+
+// MissingCombos returns the combo values of a graph that the host does not offer, and the node
+// types of the graph the host does not know.  Values that name images are ignored, as they are
+// usually uploaded with the prompt.
+func MissingCombos(c *client.ComfyClient, graph *graphapi.Graph) ([]MissingComboValue, []string, error) {
+	objects, err := c.GetObjectInfos()
+	if err != nil {
+		return nil, nil, err
+	}
+	missing := make([]MissingComboValue, 0)
+	unknown := make([]string, 0)
+	for _, n := range graph.Nodes {
+		obj, ok := objects.Objects[n.Type]
+		if !ok {
+			if !n.IsVirtual() && !containsString(unknown, n.Type) {
+				unknown = append(unknown, n.Type)
+			}
+			continue
+		}
+		if obj.InputPropertiesByID == nil {
+			continue
+		}
+		for _, p := range n.Properties {
+			if p.TypeString() != "COMBO" {
+				continue
+			}
+			combo, _ := p.ToComboProperty()
+			value, ok := combo.GetValue().(string)
+			if !ok {
+				// combo is not a string value
+				continue
+			}
+			input, ok := obj.InputPropertiesByID[combo.Name()]
+			if !ok || input == nil {
+				continue
+			}
+			inputcombo, ok := (*input).ToComboProperty()
+			if !ok || containsString(inputcombo.Values, value) {
+				continue
+			}
+			if isImageName(value) {
+				continue
+			}
+			missing = append(missing, MissingComboValue{
+				NodeTitle:     n.DisplayName,
+				NodeType:      n.Type,
+				PropertyName:  p.Name(),
+				PropertyValue: value,
+			})
+		}
+	}
+	sort.Strings(unknown)
+	return missing, unknown, nil
+}
+
+// SuggestNodeSources fills the suggestions of the results with the repos that provide their
+// missing node types.  recipes maps normalized repo urls to the recipes that install them, and
+// may be nil.
+func SuggestNodeSources(results []*CanRunResult, mappings *NodeMappings, recipes map[string][]string) {
+	if mappings == nil {
+		return
+	}
+	for _, r := range results {
+		for _, t := range r.MissingNodes {
+			repos := mappings.Repos(t)
+			if len(repos) == 0 {
+				continue
+			}
+			if r.Suggestions == nil {
+				r.Suggestions = make(map[string][]NodeSource)
+			}
+			for _, repo := range repos {
+				r.Suggestions[t] = append(r.Suggestions[t], NodeSource{Repo: repo, Recipes: recipes[NormalizeRepoURL(repo)]})
+			}
+		}
+	}
+}
+
+// GetHostsNodeMappings reads and merges the node mappings of the ComfyUI-Manager of every
+// host.  Hosts without a manager are skipped.
+func GetHostsNodeMappings(options *ComfyOptions) *NodeMappings {
+	mappings := NewNodeMappings()
+	found := make([]*NodeMappings, len(options.Host))
+	done := make(chan struct{})
+	for i := range options.Host {
+		go func(i int) {
+			found[i], _ = GetNodeMappings(options.Host[i], options.Port[i])
+			done <- struct{}{}
+		}(i)
+	}
+	for range options.Host {
+		<-done
+	}
+	for _, m := range found {
+		mappings.Merge(m)
+	}
+	return mappings
+}
+
+func isImageName(value string) bool {
+	value = strings.ToLower(value)
+	for _, ext := range []string{".png", ".jpg", ".jpeg", ".gif", ".webp"} {
+		if strings.HasSuffix(value, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func uniqueSorted(list []string) []string {
+	unique := make([]string, 0, len(list))
+	for _, s := range list {
+		if !containsString(unique, s) {
+			unique = append(unique, s)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
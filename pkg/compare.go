@@ -0,0 +1,257 @@
+package pkg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/richinsley/comfy2go/client"
+	"github.com/richinsley/comfy2go/graphapi"
+)
+
+// InventoryReport are the node types, node schemas and combo choices that differ between hosts.
+// Presence and schemas are listed for each host in the order of Hosts.
+type InventoryReport struct {
+	Hosts   []string           `json:"hosts"`
+	Errors  map[string]string  `json:"errors,omitempty"` // hosts whose object info could not be read
+	Nodes   []NodeDifference   `json:"nodes"`            // node types some hosts lack
+	Schemas []SchemaDifference `json:"schemas"`          // inputs and outputs that differ between hosts
+	Choices []ChoiceDifference `json:"choices"`          // combo choices some hosts lack
+	objects []*graphapi.NodeObjects
+}
+
+// NodeDifference is a node type some hosts lack
+type NodeDifference struct {
+	Type    string `json:"type"`
+	Present []bool `json:"present"`
+}
+
+// SchemaDifference is an input or the outputs of a node type that differ between hosts
+type SchemaDifference struct {
+	Type    string   `json:"type"`
+	Slot    string   `json:"slot"`    // the input name, or "outputs"
+	Schemas []string `json:"schemas"` // the schema on each host, empty when the host lacks the input
+}
+
+// ChoiceDifference is a combo choice some hosts lack, with the inputs that offer it
+type ChoiceDifference struct {
+	Choice  string   `json:"choice"`
+	Inputs  []string `json:"inputs"` // as Type.input
+	Present []bool   `json:"present"`
+}
+
+// Count returns the number of differences
+func (r *InventoryReport) Count() int {
+	return len(r.Nodes) + len(r.Schemas) + len(r.Choices)
+}
+
+// CompareHosts reads the object info of every host at the same time and compares them.  Hosts
+// whose object info can't be read are left out of the comparison.
+func CompareHosts(options *ComfyOptions) *InventoryReport {
+	report := &InventoryReport{
+		Hosts:   make([]string, len(options.Host)),
+		Errors:  make(map[string]string),
+		Nodes:   make([]NodeDifference, 0),
+		Schemas: make([]SchemaDifference, 0),
+		Choices: make([]ChoiceDifference, 0),
+		objects: make([]*graphapi.NodeObjects, len(options.Host)),
+	}
+	errs := make([]error, len(options.Host))
+	done := make(chan struct{})
+	for i := range options.Host {
+		report.Hosts[i] = fmt.Sprintf("%s:%d", options.Host[i], options.Port[i])
+		go func(i int) {
+			report.objects[i], errs[i] = client.NewComfyClient(options.Host[i], options.Port[i], nil).GetObjectInfos()
+			done <- struct{}{}
+		}(i)
+	}
+	for range options.Host {
+		<-done
+	}
+	for i, err := range errs {
+		if err != nil {
+			report.Errors[report.Hosts[i]] = err.Error()
+			report.objects[i] = nil
+		}
+	}
+
+	report.compareNodes()
+	return report
+}
+
+// answered returns true if the object info of the host was read
+func (r *InventoryReport) answered(i int) bool {
+	return r.objects[i] != nil
+}
+
+func (r *InventoryReport) compareNodes() {
+	types := make(map[string]bool)
+	for _, objects := range r.objects {
+		if objects == nil {
+			continue
+		}
+		for name := range objects.Objects {
+			types[name] = true
+		}
+	}
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// choice to the presence pattern to the inputs offering it
+	choices := make(map[string]map[string][]string)
+	for _, name := range names {
+		present := make([]bool, len(r.Hosts))
+		all := true
+		schemas := make([]*NodeSchema, len(r.Hosts))
+		for i, objects := range r.objects {
+			if objects == nil {
+				continue
+			}
+			if o, ok := objects.Objects[name]; ok {
+				present[i] = true
+				schemas[i] = GetNodeSchema(o)
+			} else {
+				all = false
+			}
+		}
+		if !all {
+			r.Nodes = append(r.Nodes, NodeDifference{Type: name, Present: present})
+			continue
+		}
+		r.compareSchemas(name, schemas, choices)
+	}
+
+	for choice, patterns := range choices {
+		for pattern, inputs := range patterns {
+			present := make([]bool, len(r.Hosts))
+			for i := range pattern {
+				present[i] = pattern[i] == '1'
+			}
+			sort.Strings(inputs)
+			r.Choices = append(r.Choices, ChoiceDifference{Choice: choice, Inputs: inputs, Present: present})
+		}
+	}
+	sort.Slice(r.Choices, func(i, j int) bool {
+		if r.Choices[i].Choice != r.Choices[j].Choice {
+			return r.Choices[i].Choice < r.Choices[j].Choice
+		}
+		return r.Choices[i].Inputs[0] < r.Choices[j].Inputs[0]
+	})
+}
+
+// compareSchemas compares the inputs and outputs of a node type every host has, and collects
+// the combo choices some hosts lack
+func (r *InventoryReport) compareSchemas(name string, schemas []*NodeSchema, choices map[string]map[string][]string) {
+	var slots []string
+	inputs := make([]map[string]NodeInputSchema, len(schemas))
+	for i, schema := range schemas {
+		if schema == nil {
+			continue
+		}
+		inputs[i] = make(map[string]NodeInputSchema)
+		for _, in := range schema.Inputs {
+			if !containsString(slots, in.Name) {
+				slots = append(slots, in.Name)
+			}
+			inputs[i][in.Name] = in
+		}
+	}
+
+	for _, slot := range slots {
+		described := make([]string, len(schemas))
+		differ := false
+		first := -1
+		for i := range schemas {
+			if inputs[i] == nil {
+				continue
+			}
+			if in, ok := inputs[i][slot]; ok {
+				required := "required"
+				if !in.Required {
+					required = "optional"
+				}
+				described[i] = in.Type + " " + required
+			}
+			if first < 0 {
+				first = i
+			} else if described[i] != described[first] {
+				differ = true
+			}
+		}
+		if differ {
+			r.Schemas = append(r.Schemas, SchemaDifference{Type: name, Slot: slot, Schemas: described})
+			continue
+		}
+
+		// the same combo on every host, compare its choices
+		seen := make(map[string][]bool)
+		for i := range schemas {
+			if inputs[i] == nil {
+				continue
+			}
+			in := inputs[i][slot]
+			if in.Type != "COMBO" {
+				break
+			}
+			for _, c := range in.Choices {
+				if seen[c] == nil {
+					seen[c] = make([]bool, len(schemas))
+				}
+				seen[c][i] = true
+			}
+		}
+		for c, present := range seen {
+			pattern := make([]byte, len(present))
+			missing := false
+			for i, p := range present {
+				pattern[i] = '0'
+				if p {
+					pattern[i] = '1'
+				} else if r.answered(i) {
+					missing = true
+				}
+			}
+			if !missing {
+				continue
+			}
+			if choices[c] == nil {
+				choices[c] = make(map[string][]string)
+			}
+			choices[c][string(pattern)] = append(choices[c][string(pattern)], name+"."+slot)
+		}
+	}
+
+	outputs := make([]string, len(schemas))
+	differ := false
+	first := -1
+	for i, schema := range schemas {
+		if schema == nil {
+			continue
+		}
+		types := make([]string, len(schema.Outputs))
+		for j, out := range schema.Outputs {
+			types[j] = out.Type
+		}
+		outputs[i] = strings.Join(types, ", ")
+		if first < 0 {
+			first = i
+		} else if outputs[i] != outputs[first] {
+			differ = true
+		}
+	}
+	if differ {
+		r.Schemas = append(r.Schemas, SchemaDifference{Type: name, Slot: "outputs", Schemas: outputs})
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package pkg
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/richinsley/comfy2go/graphapi"
+)
+
+// space between the base workflow and an attached workflow
+const composeMargin = 100
+
+// AttachGraph merges the nodes, links and groups of attached into base.  Node and link ids are
+// offset past the last ids of base, and the attached workflow is placed below base.  The titles
+// of the nodes in the attached workflow's Simple API group are prefixed with "prefix.", so its
+// API values keep their own namespace when the two API groups are merged.  The ids of the
+// attached nodes in base are returned.
+func AttachGraph(base *graphapi.Graph, attached *graphapi.Graph, apiTitle string, prefix string) map[int]bool {
+	if prefix != "" {
+		for _, g := range attached.Groups {
+			if g.Title != apiTitle {
+				continue
+			}
+			for _, n := range attached.GetNodesInGroup(g) {
+				title := n.Title
+				if title == "" {
+					title = n.DisplayName
+				}
+				if !strings.HasPrefix(title, prefix+".") {
+					n.Title = prefix + "." + title
+				}
+			}
+		}
+	}
+
+	// place the attached workflow below the base workflow, aligned on the left
+	bx, _, _, by := graphBounds(base)
+	ax, ay, _, _ := graphBounds(attached)
+	dx, dy := 0.0, 0.0
+	if len(base.Nodes) > 0 && len(attached.Nodes) > 0 {
+		dx = bx - ax
+		dy = by + composeMargin - ay
+	}
+
+	nodeOffset := base.LastNodeID
+	linkOffset := base.LastLinkID
+	orderOffset := len(base.Nodes)
+	ids := make(map[int]bool)
+
+	for _, n := range attached.Nodes {
+		n.ID += nodeOffset
+		n.Order += orderOffset
+		n.Graph = base
+		for i := range n.Inputs {
+			if n.Inputs[i].Link != 0 {
+				n.Inputs[i].Link += linkOffset
+			}
+		}
+		for i := range n.Outputs {
+			if n.Outputs[i].Links != nil {
+				links := make([]int, len(*n.Outputs[i].Links))
+				for j, l := range *n.Outputs[i].Links {
+					links[j] = l + linkOffset
+				}
+				n.Outputs[i].Links = &links
+			}
+		}
+		moveNode(n, dx, dy)
+
+		ids[n.ID] = true
+		base.Nodes = append(base.Nodes, n)
+		base.NodesByID[n.ID] = n
+		base.NodesInExecutionOrder = append(base.NodesInExecutionOrder, n)
+	}
+
+	for _, l := range attached.Links {
+		l.ID += linkOffset
+		l.OriginID += nodeOffset
+		l.TargetID += nodeOffset
+		base.Links = append(base.Links, l)
+		base.LinksByID[l.ID] = l
+	}
+
+	for _, g := range attached.Groups {
+		if len(g.Bounding) == 4 {
+			g.Bounding[0] += int(math.Round(dx))
+			g.Bounding[1] += int(math.Round(dy))
+		}
+		base.Groups = append(base.Groups, g)
+	}
+
+	base.LastNodeID += attached.LastNodeID
+	base.LastLinkID += attached.LastLinkID
+	return ids
+}
+
+// LinkAttached links an output to an input after AttachGraph, as "node.output->node.input".  The
+// output is looked up in the base workflow first and the input in the attached workflow first,
+// so titles used in both workflows link the base to the attached workflow.
+func LinkAttached(graph *graphapi.Graph, attached map[int]bool, spec string) error {
+	from, to, ok := strings.Cut(spec, "->")
+	if !ok {
+		return fmt.Errorf("expected node.output->node.input, got %s", spec)
+	}
+	origin, output, err := findPreferredSlot(graph, from, func(id int) bool { return !attached[id] })
+	if err != nil {
+		return err
+	}
+	target, input, err := findPreferredSlot(graph, to, func(id int) bool { return attached[id] })
+	if err != nil {
+		return err
+	}
+	return linkNodes(graph, origin, output, target, input)
+}
+
+// findPreferredSlot is findSlot, narrowing a selector that matches several nodes to the
+// preferred ones
+func findPreferredSlot(graph *graphapi.Graph, address string, preferred func(int) bool) (*graphapi.GraphNode, string, error) {
+	dot := strings.LastIndex(address, ".")
+	if dot <= 0 || dot == len(address)-1 {
+		return nil, "", fmt.Errorf("expected node.slot, got %s", address)
+	}
+	p, err := ParseNodeSelector(address[:dot])
+	if err != nil {
+		return nil, "", err
+	}
+	// every node with the title, to choose between them
+	all := p
+	all.AllNodes = p.NodeTitle != ""
+	nodes, err := all.Nodes(graph)
+	if err != nil {
+		// report the selector as it was given
+		_, err = p.Nodes(graph)
+		return nil, "", err
+	}
+	if len(nodes) > 1 {
+		var narrowed []*graphapi.GraphNode
+		for _, n := range nodes {
+			if preferred(n.ID) {
+				narrowed = append(narrowed, n)
+			}
+		}
+		if len(narrowed) > 0 {
+			nodes = narrowed
+		}
+	}
+	if len(nodes) > 1 {
+		return nil, "", fmt.Errorf("%s matches %d nodes, a link needs a single node", address[:dot], len(nodes))
+	}
+	return nodes[0], address[dot+1:], nil
+}
+
+// graphBounds returns the bounding box of the nodes and groups of a graph
+func graphBounds(graph *graphapi.Graph) (minX, minY, maxX, maxY float64) {
+	minX, minY = math.Inf(1), math.Inf(1)
+	maxX, maxY = math.Inf(-1), math.Inf(-1)
+	extend := func(x, y, w, h float64) {
+		minX, minY = math.Min(minX, x), math.Min(minY, y)
+		maxX, maxY = math.Max(maxX, x+w), math.Max(maxY, y+h)
+	}
+	for _, n := range graph.Nodes {
+		if x, y, ok := nodePosition(n); ok {
+			// group membership tests the node's width as its height too
+			extend(x, y, n.Size.Width, math.Max(n.Size.Width, n.Size.Height))
+		}
+	}
+	for _, g := range graph.Groups {
+		if len(g.Bounding) == 4 {
+			extend(float64(g.Bounding[0]), float64(g.Bounding[1]), float64(g.Bounding[2]), float64(g.Bounding[3]))
+		}
+	}
+	if math.IsInf(minX, 1) {
+		return 0, 0, 0, 0
+	}
+	return minX, minY, maxX, maxY
+}
+
+// nodePosition returns the position of a node, stored as an array or as a map
+func nodePosition(n *graphapi.GraphNode) (float64, float64, bool) {
+	switch pos := n.Position.(type) {
+	case []interface{}:
+		if len(pos) == 2 {
+			x, xok := pos[0].(float64)
+			y, yok := pos[1].(float64)
+			return x, y, xok && yok
+		}
+	case map[string]interface{}:
+		x, xok := pos["0"].(float64)
+		y, yok := pos["1"].(float64)
+		return x, y, xok && yok
+	}
+	return 0, 0, false
+}
+
+func moveNode(n *graphapi.GraphNode, dx, dy float64) {
+	x, y, ok := nodePosition(n)
+	if !ok {
+		return
+	}
+	n.Position = []interface{}{x + dx, y + dy}
+}
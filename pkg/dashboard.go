@@ -0,0 +1,277 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// ANSI sequences used by the dashboard
+const (
+	ansiAltScreen   = "\033[?1049h"
+	ansiMainScreen  = "\033[?1049l"
+	ansiHideCursor  = "\033[?25l"
+	ansiShowCursor  = "\033[?25h"
+	ansiHome        = "\033[H"
+	ansiClearLine   = "\033[K"
+	ansiClearScreen = "\033[J"
+	ansiBold        = "\033[1m"
+	ansiDim         = "\033[2m"
+	ansiRed         = "\033[31m"
+	ansiGreen       = "\033[32m"
+	ansiYellow      = "\033[33m"
+	ansiReset       = "\033[0m"
+)
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// Dashboard shows the status of several ComfyUI hosts in the terminal
+type Dashboard struct {
+	Monitors []*HostMonitor
+	Jobs     int // number of recent jobs shown for each host
+
+	selected int
+	message  string
+	confirm  bool // waiting for the confirmation to clear the selected host's queue
+	color    bool
+}
+
+// Run shows the dashboard until the user quits.  The hosts are polled every interval and the
+// dashboard is redrawn as the executing nodes progress.
+func (d *Dashboard) Run(interval time.Duration) error {
+	in := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(in)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(in, state)
+	os.Stdout.WriteString(ansiAltScreen + ansiHideCursor)
+	defer os.Stdout.WriteString(ansiShowCursor + ansiMainScreen)
+	d.color = true
+
+	stop := make(chan struct{})
+	defer close(stop)
+	for _, m := range d.Monitors {
+		go m.Run(interval, stop)
+	}
+
+	keys := make(chan string)
+	go readKeys(keys)
+
+	redraw := time.NewTicker(250 * time.Millisecond)
+	defer redraw.Stop()
+	for {
+		d.draw()
+		select {
+		case key, ok := <-keys:
+			if !ok || !d.handleKey(key) {
+				return nil
+			}
+		case <-redraw.C:
+		}
+	}
+}
+
+// Text returns the dashboard as plain text, for output that is not a terminal
+func (d *Dashboard) Text(width int) string {
+	d.color = false
+	return strings.Join(d.lines(width), "\n")
+}
+
+// handleKey acts on a key press, returning false to quit
+func (d *Dashboard) handleKey(key string) bool {
+	if d.confirm {
+		d.confirm = false
+		d.message = ""
+		if key == "y" || key == "Y" {
+			d.run("cleared the queue of", d.Monitors[d.selected].ClearQueue)
+		}
+		return true
+	}
+
+	switch key {
+	case "q", "Q", "\x03", "\x1b":
+		return false
+	case "\t", "j", "\x1b[B":
+		d.selected = (d.selected + 1) % len(d.Monitors)
+	case "k", "\x1b[A", "\x1b[Z":
+		d.selected = (d.selected + len(d.Monitors) - 1) % len(d.Monitors)
+	case "i":
+		d.run("interrupted", d.Monitors[d.selected].Interrupt)
+	case "c":
+		d.confirm = true
+		d.message = fmt.Sprintf("Clear the pending prompts of %s? (y/n)", d.Monitors[d.selected].Status().Host)
+	case "r":
+		go d.Monitors[d.selected].Poll()
+	}
+	return true
+}
+
+func (d *Dashboard) run(action string, f func() error) {
+	host := d.Monitors[d.selected].Status().Host
+	if err := f(); err != nil {
+		d.message = fmt.Sprintf("%s: %v", host, err)
+		return
+	}
+	d.message = fmt.Sprintf("%s %s", strings.ToUpper(action[:1])+action[1:], host)
+	go d.Monitors[d.selected].Poll()
+}
+
+func (d *Dashboard) draw() {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+	lines := d.lines(width)
+	if len(lines) > height {
+		lines = lines[:height]
+	}
+	var b strings.Builder
+	b.WriteString(ansiHome)
+	for i, l := range lines {
+		b.WriteString(l + ansiClearLine)
+		if i < len(lines)-1 {
+			// the terminal is in raw mode, new lines don't return the cursor
+			b.WriteString("\r\n")
+		}
+	}
+	b.WriteString(ansiClearScreen)
+	os.Stdout.WriteString(b.String())
+}
+
+func (d *Dashboard) lines(width int) []string {
+	// the width left for sparklines and bars after their labels
+	graph := max(10, min(60, width-40))
+
+	lines := []string{d.style(ansiBold, fmt.Sprintf("comfycli top - %d hosts - %s", len(d.Monitors), time.Now().Format("15:04:05"))), ""}
+	for i, m := range d.Monitors {
+		s := m.Status()
+		marker := "  "
+		if i == d.selected && d.color {
+			marker = "▶ "
+		}
+
+		if !s.Connected {
+			state := "connecting"
+			if s.Error != "" {
+				state = "offline: " + s.Error
+			}
+			lines = append(lines, marker+d.style(ansiBold, s.Host)+"  "+d.style(ansiRed, state), "")
+			continue
+		}
+
+		lines = append(lines, fmt.Sprintf("%s%s  queue %d running, %d pending", marker, d.style(ansiBold, s.Host), s.QueueRunning, s.QueuePending))
+		if len(s.Devices) > 0 {
+			dev := s.Devices[0]
+			lines = append(lines, fmt.Sprintf("  VRAM %s %s  %s", d.style(ansiGreen, sparkline(s.VRAMUsed, graph)),
+				memoryUse(dev.VRAMTotal, dev.VRAMFree), dev.Name))
+		}
+		if s.RAMTotal > 0 {
+			lines = append(lines, fmt.Sprintf("  RAM  %s %s", d.style(ansiGreen, sparkline(s.RAMUsed, graph)), memoryUse(s.RAMTotal, s.RAMFree)))
+		}
+
+		switch {
+		case s.PromptID == "":
+			lines = append(lines, "  "+d.style(ansiDim, "idle"))
+		case s.ProgressMax > 0:
+			lines = append(lines, fmt.Sprintf("  %s %s %d/%d", d.style(ansiYellow, s.Node), progressBar(s.Progress, s.ProgressMax, graph), s.Progress, s.ProgressMax))
+		default:
+			node := s.Node
+			if node == "" {
+				// ComfyUI does not send the executing node of prompts queued by other clients, so
+				// they show how long the queue has had them running
+				node = "running " + time.Since(s.Running).Round(time.Second).String()
+			}
+			lines = append(lines, fmt.Sprintf("  %s  %s", d.style(ansiYellow, node), d.style(ansiDim, s.PromptID)))
+		}
+
+		for j, job := range s.Jobs {
+			if j == d.Jobs {
+				break
+			}
+			status := d.style(ansiGreen, "done")
+			switch job.Status {
+			case "error":
+				status = d.style(ansiRed, "fail")
+			case "interrupted":
+				status = d.style(ansiYellow, "stop")
+			}
+			started := ""
+			if !job.Started.IsZero() {
+				started = job.Started.Format("15:04:05")
+			}
+			lines = append(lines, fmt.Sprintf("  %s #%-5d %-8s %8s  %s", status, job.Number, started, job.Duration.Round(100*time.Millisecond), d.style(ansiDim, job.PromptID)))
+		}
+		lines = append(lines, "")
+	}
+
+	if d.color {
+		lines = append(lines, d.style(ansiDim, "q quit  tab/j/k select host  i interrupt  c clear queue  r refresh"))
+		if d.message != "" {
+			lines = append(lines, d.message)
+		}
+	}
+	return lines
+}
+
+func (d *Dashboard) style(code string, s string) string {
+	if !d.color || s == "" {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+// readKeys sends the keys read from stdin, with escape sequences such as the arrow keys sent
+// as one key
+func readKeys(keys chan string) {
+	defer close(keys)
+	buf := make([]byte, 64)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		s := string(buf[:n])
+		if strings.HasPrefix(s, "\x1b[") || n == 1 {
+			keys <- s
+			continue
+		}
+		for _, r := range s {
+			keys <- string(r)
+		}
+	}
+}
+
+// sparkline draws the last width samples, each a fraction from 0 to 1
+func sparkline(samples []float64, width int) string {
+	if len(samples) > width {
+		samples = samples[len(samples)-width:]
+	}
+	var b strings.Builder
+	for _, v := range samples {
+		i := int(v * float64(len(sparkBlocks)-1))
+		b.WriteRune(sparkBlocks[max(0, min(i, len(sparkBlocks)-1))])
+	}
+	return b.String() + strings.Repeat(" ", width-len(samples))
+}
+
+func progressBar(value int, total int, width int) string {
+	filled := 0
+	if total > 0 {
+		filled = max(0, min(width, value*width/total))
+	}
+	return "[" + strings.Repeat("█", filled) + strings.Repeat("░", width-filled) + "]"
+}
+
+// memoryUse formats memory in use as "62% 14.9/24.0 GB"
+func memoryUse(total int64, free int64) string {
+	if total <= 0 {
+		return ""
+	}
+	const gb = 1 << 30
+	used := total - free
+	return fmt.Sprintf("%3d%% %.1f/%.1f GB", used*100/total, float64(used)/gb, float64(total)/gb)
+}
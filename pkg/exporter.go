@@ -0,0 +1,91 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Exporter serves the status of ComfyUI hosts as Prometheus metrics
+type Exporter struct {
+	Monitors []*HostMonitor
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	e.WriteMetrics(w)
+}
+
+// WriteMetrics writes the metrics of every host in the Prometheus text format
+func (e *Exporter) WriteMetrics(w io.Writer) {
+	statuses := make([]HostStatus, len(e.Monitors))
+	for i, m := range e.Monitors {
+		statuses[i] = m.Status()
+	}
+
+	metric := func(name string, kind string, help string, samples func(add func(labels []string, v float64))) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		samples(func(labels []string, v float64) {
+			fmt.Fprintf(w, "%s%s %g\n", name, formatLabels(labels), v)
+		})
+	}
+	// device metrics are written for the hosts that answered the last poll
+	devices := func(v func(d DeviceStats) int64) func(add func([]string, float64)) {
+		return func(add func([]string, float64)) {
+			for _, s := range statuses {
+				if !s.Connected {
+					continue
+				}
+				for _, d := range s.Devices {
+					add([]string{"host", s.Host, "device", d.Name}, float64(v(d)))
+				}
+			}
+		}
+	}
+	hosts := func(connected bool, v func(s HostStatus) float64) func(add func([]string, float64)) {
+		return func(add func([]string, float64)) {
+			for _, s := range statuses {
+				if connected && !s.Connected {
+					continue
+				}
+				add([]string{"host", s.Host}, v(s))
+			}
+		}
+	}
+
+	metric("comfyui_up", "gauge", "Whether the last poll of the host succeeded.", hosts(false, func(s HostStatus) float64 {
+		if s.Connected {
+			return 1
+		}
+		return 0
+	}))
+	metric("comfyui_vram_total_bytes", "gauge", "Total VRAM of the device.", devices(func(d DeviceStats) int64 { return d.VRAMTotal }))
+	metric("comfyui_vram_free_bytes", "gauge", "Free VRAM of the device.", devices(func(d DeviceStats) int64 { return d.VRAMFree }))
+	metric("comfyui_torch_vram_total_bytes", "gauge", "VRAM reserved by torch on the device.", devices(func(d DeviceStats) int64 { return d.TorchVRAMTotal }))
+	metric("comfyui_torch_vram_free_bytes", "gauge", "Free VRAM reserved by torch on the device.", devices(func(d DeviceStats) int64 { return d.TorchVRAMFree }))
+	metric("comfyui_ram_total_bytes", "gauge", "Total RAM of the host.", hosts(true, func(s HostStatus) float64 { return float64(s.RAMTotal) }))
+	metric("comfyui_ram_free_bytes", "gauge", "Free RAM of the host.", hosts(true, func(s HostStatus) float64 { return float64(s.RAMFree) }))
+	metric("comfyui_queue_running", "gauge", "Number of running prompts.", hosts(true, func(s HostStatus) float64 { return float64(s.QueueRunning) }))
+	metric("comfyui_queue_pending", "gauge", "Number of pending prompts.", hosts(true, func(s HostStatus) float64 { return float64(s.QueuePending) }))
+	metric("comfyui_jobs_completed_total", "counter", "Prompts that succeeded since the exporter started.", hosts(false, func(s HostStatus) float64 { return float64(s.Completed) }))
+	metric("comfyui_jobs_failed_total", "counter", "Prompts that failed or were interrupted since the exporter started.", hosts(false, func(s HostStatus) float64 { return float64(s.Failed) }))
+	metric("comfyui_job_execution_seconds", "summary", "Execution time of the prompts counted by the job counters.", func(add func([]string, float64)) {
+		for _, s := range statuses {
+			labels := formatLabels([]string{"host", s.Host})
+			fmt.Fprintf(w, "comfyui_job_execution_seconds_sum%s %g\n", labels, s.JobsSeconds)
+			fmt.Fprintf(w, "comfyui_job_execution_seconds_count%s %d\n", labels, s.JobsTimed)
+		}
+	})
+}
+
+// formatLabels formats name, value pairs as {name="value",...}
+func formatLabels(labels []string) string {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], escape.Replace(labels[i+1])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
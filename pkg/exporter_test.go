@@ -0,0 +1,67 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// serve the history of a ComfyUI host, with prompts that ran for 2 seconds each
+func historyServer(t *testing.T, prompts *int) (string, int) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/system_stats":
+			fmt.Fprint(w, `{"system": {"ram_total": 100, "ram_free": 50}, "devices": []}`)
+		case "/queue":
+			fmt.Fprint(w, `{"queue_running": [], "queue_pending": []}`)
+		case "/history":
+			var entries []string
+			for i := 1; i <= *prompts; i++ {
+				start := int64(i) * 10000
+				entries = append(entries, fmt.Sprintf(`"p%d": {"prompt": [%d, "p%d", {}, {}, []], "status": {"status_str": "success", "messages": [["execution_start", {"timestamp": %d}], ["execution_success", {"timestamp": %d}]]}}`, i, i, i, start, start+2000))
+			}
+			fmt.Fprintf(w, "{%s}", strings.Join(entries, ","))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	p, _ := strconv.Atoi(port)
+	return host, p
+}
+
+func TestExporterJobMetrics(t *testing.T) {
+	prompts := 1
+	m := NewHostMonitor(historyServer(t, &prompts))
+	// the history already on the host is not counted
+	m.Poll()
+	prompts = 3
+	m.Poll()
+
+	s := m.Status()
+	if s.Completed != 2 || s.JobsTimed != 2 || s.JobsSeconds != 4 {
+		t.Errorf("completed %d, timed %d for %gs, want 2 jobs timed for 4s", s.Completed, s.JobsTimed, s.JobsSeconds)
+	}
+
+	var out bytes.Buffer
+	(&Exporter{Monitors: []*HostMonitor{m}}).WriteMetrics(&out)
+	labels := fmt.Sprintf(`{host="%s"}`, s.Host)
+	for _, want := range []string{
+		"comfyui_up" + labels + " 1\n",
+		"comfyui_jobs_completed_total" + labels + " 2\n",
+		"# TYPE comfyui_job_execution_seconds summary\n",
+		"comfyui_job_execution_seconds_sum" + labels + " 4\n",
+		"comfyui_job_execution_seconds_count" + labels + " 2\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics do not contain %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,394 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// frame stream formats
+const (
+	FrameFormatPNG   = "png" // concatenated PNG frames
+	FrameFormatY4M   = "y4m"
+	FrameFormatMJPEG = "mjpeg" // concatenated JPEG frames
+	FrameFormatRaw   = "raw"   // output data is written as is
+)
+
+var (
+	pngSignature  = []byte("\x89PNG\r\n\x1a\n")
+	jpegSignature = []byte{0xff, 0xd8, 0xff}
+	y4mSignature  = []byte("YUV4MPEG2 ")
+)
+
+// Frame is a single image read from a frame stream
+type Frame struct {
+	Format string // an image format or "y4m"
+	// Data holds the original bytes of encoded images
+	Data []byte
+	// Image holds the decoded image of y4m frames
+	Image image.Image
+}
+
+// Decode returns the frame's image
+func (f *Frame) Decode() (image.Image, error) {
+	if f.Image != nil {
+		return f.Image, nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(f.Data))
+	return img, err
+}
+
+// y4mHeader holds the stream parameters of a YUV4MPEG2 stream
+type y4mHeader struct {
+	Width      int
+	Height     int
+	Rate       string
+	Colorspace string
+}
+
+// FrameReader splits a stream of concatenated images (such as ffmpeg's image2pipe or mjpeg
+// muxers), or a YUV4MPEG2 stream, into individual frames.  The image decoders in the
+// standard library buffer ahead of the image they decode, so the frame boundaries have to be
+// found before decoding.
+type FrameReader struct {
+	r   *bufio.Reader
+	y4m *y4mHeader
+}
+
+// NewFrameReader creates a FrameReader for a stream
+func NewFrameReader(r *bufio.Reader) *FrameReader {
+	return &FrameReader{r: r}
+}
+
+// Rate returns the frame rate of a y4m stream, or an empty string if it is not known
+func (f *FrameReader) Rate() string {
+	if f.y4m == nil {
+		return ""
+	}
+	return f.y4m.Rate
+}
+
+// Next reads the next frame from the stream.  io.EOF is returned at the end of the stream
+func (f *FrameReader) Next() (*Frame, error) {
+	if f.y4m == nil {
+		header, err := f.r.Peek(len(y4mSignature))
+		if len(header) == 0 && err == io.EOF {
+			return nil, io.EOF
+		}
+		if bytes.Equal(header, y4mSignature) {
+			if f.y4m, err = readY4MHeader(f.r); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if f.y4m != nil {
+		return readY4MFrame(f.r, f.y4m)
+	}
+
+	// skip anything between frames, such as multipart MJPEG boundaries
+	skipped := 0
+	for {
+		header, err := f.r.Peek(imageHeaderSize)
+		if ImageFormat(header) != "" {
+			break
+		}
+		if err != nil {
+			if len(header) == 0 || err == io.EOF {
+				if skipped+len(header) > 0 {
+					slog.Debug(fmt.Sprintf("Skipped %d bytes at the end of the frame stream", skipped+len(header)))
+				}
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		f.r.Discard(1)
+		skipped++
+	}
+	if skipped > 0 {
+		slog.Debug(fmt.Sprintf("Skipped %d bytes between frames", skipped))
+	}
+	return ExpectImage(f.r)
+}
+
+// readPNG reads the chunks of a PNG image up to and including IEND
+func readPNG(r *bufio.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(len(pngSignature))); err != nil {
+		return nil, fmt.Errorf("failed to read PNG signature: %w", err)
+	}
+	for {
+		// [length][type][data][crc]
+		chunk := make([]byte, 8)
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return nil, fmt.Errorf("failed to read PNG chunk: %w", err)
+		}
+		buf.Write(chunk)
+		length := int64(binary.BigEndian.Uint32(chunk[0:4]))
+		if _, err := io.CopyN(&buf, r, length+4); err != nil {
+			return nil, fmt.Errorf("failed to read PNG chunk: %w", err)
+		}
+		if string(chunk[4:8]) == "IEND" {
+			return buf.Bytes(), nil
+		}
+	}
+}
+
+// readJPEG reads the segments of a JPEG image up to and including the EOI marker
+func readJPEG(r *bufio.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	readByte := func() (byte, error) {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read JPEG: %w", err)
+		}
+		return b, nil
+	}
+
+	// the marker's 0xff has already been consumed at the end of entropy coded data
+	pending := false
+	for {
+		if !pending {
+			b, err := readByte()
+			if err != nil {
+				return nil, err
+			}
+			if b != 0xff {
+				return nil, fmt.Errorf("failed to read JPEG: expected a marker, got 0x%02x", b)
+			}
+		}
+		pending = false
+
+		// skip any fill bytes
+		marker, err := readByte()
+		for err == nil && marker == 0xff {
+			marker, err = readByte()
+		}
+		if err != nil {
+			return nil, err
+		}
+		buf.Write([]byte{0xff, marker})
+
+		switch {
+		case marker == 0xd9: // EOI
+			return buf.Bytes(), nil
+		case marker == 0xd8 || marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7):
+			// SOI, TEM and RSTn have no payload
+			continue
+		}
+
+		length := make([]byte, 2)
+		if _, err := io.ReadFull(r, length); err != nil {
+			return nil, fmt.Errorf("failed to read JPEG segment: %w", err)
+		}
+		buf.Write(length)
+		if _, err := io.CopyN(&buf, r, int64(binary.BigEndian.Uint16(length))-2); err != nil {
+			return nil, fmt.Errorf("failed to read JPEG segment: %w", err)
+		}
+
+		if marker == 0xda {
+			// SOS is followed by entropy coded data, which ends at the first marker that is
+			// not a stuffed 0x00 or a restart marker
+			for {
+				b, err := readByte()
+				if err != nil {
+					return nil, err
+				}
+				if b != 0xff {
+					buf.WriteByte(b)
+					continue
+				}
+				next, err := r.Peek(1)
+				if err != nil {
+					return nil, fmt.Errorf("failed to read JPEG: %w", io.ErrUnexpectedEOF)
+				}
+				if next[0] == 0x00 || (next[0] >= 0xd0 && next[0] <= 0xd7) {
+					buf.WriteByte(b)
+					continue
+				}
+				pending = true
+				break
+			}
+		}
+	}
+}
+
+// readY4MHeader reads the stream header of a YUV4MPEG2 stream
+func readY4MHeader(r *bufio.Reader) (*y4mHeader, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed to read y4m header: %w", err)
+	}
+
+	header := &y4mHeader{Colorspace: "420"}
+	for _, param := range strings.Fields(strings.TrimPrefix(line, string(y4mSignature))) {
+		switch param[0] {
+		case 'W':
+			header.Width, err = strconv.Atoi(param[1:])
+		case 'H':
+			header.Height, err = strconv.Atoi(param[1:])
+		case 'F':
+			header.Rate = param[1:]
+		case 'C':
+			header.Colorspace = param[1:]
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid y4m header parameter %s", param)
+		}
+	}
+	if header.Width <= 0 || header.Height <= 0 {
+		return nil, fmt.Errorf("invalid y4m frame size %dx%d", header.Width, header.Height)
+	}
+	return header, nil
+}
+
+// readY4MFrame reads a single frame of a YUV4MPEG2 stream
+func readY4MFrame(r *bufio.Reader, header *y4mHeader) (*Frame, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && line == "" {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("failed to read y4m frame header: %w", err)
+	}
+	if !strings.HasPrefix(line, "FRAME") {
+		return nil, fmt.Errorf("invalid y4m frame header %q", strings.TrimSpace(line))
+	}
+
+	w, h := header.Width, header.Height
+	rect := image.Rect(0, 0, w, h)
+	readPlane := func(dst []byte) error {
+		if _, err := io.ReadFull(r, dst); err != nil {
+			return fmt.Errorf("failed to read y4m frame: %w", err)
+		}
+		return nil
+	}
+
+	// only 8 bit planes are read, higher bit depths such as 420p10 or mono16 are unsupported
+	var img image.Image
+	switch header.Colorspace {
+	case "mono":
+		gray := image.NewGray(rect)
+		if err := readPlane(gray.Pix); err != nil {
+			return nil, err
+		}
+		img = gray
+	default:
+		var ratio image.YCbCrSubsampleRatio
+		switch header.Colorspace {
+		case "420", "420jpeg", "420paldv", "420mpeg2":
+			ratio = image.YCbCrSubsampleRatio420
+		case "422":
+			ratio = image.YCbCrSubsampleRatio422
+		case "444":
+			ratio = image.YCbCrSubsampleRatio444
+		default:
+			return nil, fmt.Errorf("unsupported y4m colorspace %s", header.Colorspace)
+		}
+		ycbcr := image.NewYCbCr(rect, ratio)
+		for _, plane := range [][]byte{ycbcr.Y, ycbcr.Cb, ycbcr.Cr} {
+			if err := readPlane(plane); err != nil {
+				return nil, err
+			}
+		}
+		img = ycbcr
+	}
+	return &Frame{Format: FrameFormatY4M, Image: img}, nil
+}
+
+// FrameWriter writes output images to a stream, re-encoding them to the stream's format
+type FrameWriter struct {
+	w      io.Writer
+	format string
+	// frame rate written to y4m stream headers
+	rate   string
+	width  int
+	height int
+	mu     sync.Mutex
+}
+
+// NewFrameWriter creates a FrameWriter for the format "raw", "png", "mjpeg" or "y4m"
+func NewFrameWriter(w io.Writer, format string, rate string) (*FrameWriter, error) {
+	switch format {
+	case "", FrameFormatRaw:
+		format = FrameFormatRaw
+	case FrameFormatPNG, FrameFormatMJPEG, FrameFormatY4M:
+	default:
+		return nil, fmt.Errorf("unknown stream format %q (expected raw, png, mjpeg or y4m)", format)
+	}
+	if rate == "" {
+		rate = "25:1"
+	}
+	return &FrameWriter{w: w, format: format, rate: rate}, nil
+}
+
+// WriteFrame writes the data of an output to the stream.  Data that is not an image is
+// written as is
+func (f *FrameWriter) WriteFrame(data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.format == FrameFormatRaw {
+		_, err := f.w.Write(data)
+		return err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Output is not an image, writing it as is: %v", err))
+		_, err = f.w.Write(data)
+		return err
+	}
+
+	switch f.format {
+	case FrameFormatPNG:
+		return png.Encode(f.w, img)
+	case FrameFormatMJPEG:
+		return jpeg.Encode(f.w, img, &jpeg.Options{Quality: 95})
+	}
+	return f.writeY4M(img)
+}
+
+// writeY4M writes an image as a 4:4:4 y4m frame, writing the stream header before the first frame
+func (f *FrameWriter) writeY4M(img image.Image) error {
+	bounds := img.Bounds()
+	if f.width == 0 {
+		f.width, f.height = bounds.Dx(), bounds.Dy()
+		if _, err := fmt.Fprintf(f.w, "YUV4MPEG2 W%d H%d F%s Ip A1:1 C444\n", f.width, f.height, f.rate); err != nil {
+			return err
+		}
+	} else if bounds.Dx() != f.width || bounds.Dy() != f.height {
+		return fmt.Errorf("frame size %dx%d does not match the y4m stream size %dx%d", bounds.Dx(), bounds.Dy(), f.width, f.height)
+	}
+
+	size := f.width * f.height
+	planes := make([]byte, size*3)
+	i := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.YCbCrModel.Convert(img.At(x, y)).(color.YCbCr)
+			planes[i] = c.Y
+			planes[size+i] = c.Cb
+			planes[size*2+i] = c.Cr
+			i++
+		}
+	}
+	if _, err := io.WriteString(f.w, "FRAME\n"); err != nil {
+		return err
+	}
+	_, err := f.w.Write(planes)
+	return err
+}
@@ -0,0 +1,149 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+)
+
+// build a y4m stream of frames filled with the bytes of planes
+func y4mStream(header string, frames int, planeSize int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("YUV4MPEG2 " + header + "\n")
+	for i := 0; i < frames; i++ {
+		buf.WriteString("FRAME\n")
+		buf.Write(bytes.Repeat([]byte{byte(16 + i)}, planeSize))
+	}
+	return buf.Bytes()
+}
+
+func TestFrameReaderY4M(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    string
+		planeSize int // bytes of all planes of a 4x2 frame
+		ratio     image.YCbCrSubsampleRatio
+		gray      bool
+	}{
+		{"default 420", "W4 H2 F30:1", 8 + 2 + 2, image.YCbCrSubsampleRatio420, false},
+		{"420jpeg", "W4 H2 F30:1 C420jpeg", 8 + 2 + 2, image.YCbCrSubsampleRatio420, false},
+		{"420mpeg2", "W4 H2 F30:1 C420mpeg2", 8 + 2 + 2, image.YCbCrSubsampleRatio420, false},
+		{"422", "W4 H2 F30:1 C422", 8 + 4 + 4, image.YCbCrSubsampleRatio422, false},
+		{"444", "W4 H2 F30:1 C444", 8 * 3, image.YCbCrSubsampleRatio444, false},
+		{"mono", "W4 H2 F30:1 Cmono", 8, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewFrameReader(bufio.NewReader(bytes.NewReader(y4mStream(tt.header, 2, tt.planeSize))))
+			for i := 0; i < 2; i++ {
+				frame, err := r.Next()
+				if err != nil {
+					t.Fatalf("frame %d: %v", i, err)
+				}
+				if tt.gray {
+					gray, ok := frame.Image.(*image.Gray)
+					if !ok {
+						t.Fatalf("frame %d is a %T, want *image.Gray", i, frame.Image)
+					}
+					if gray.Pix[0] != byte(16+i) {
+						t.Errorf("frame %d pixel = %d, want %d", i, gray.Pix[0], 16+i)
+					}
+					continue
+				}
+				ycbcr, ok := frame.Image.(*image.YCbCr)
+				if !ok {
+					t.Fatalf("frame %d is a %T, want *image.YCbCr", i, frame.Image)
+				}
+				if ycbcr.SubsampleRatio != tt.ratio {
+					t.Errorf("frame %d subsample ratio = %v, want %v", i, ycbcr.SubsampleRatio, tt.ratio)
+				}
+				// every plane of the frame holds the same byte, so the last Cr byte shows
+				// that the frame was read to its end
+				if cr := ycbcr.Cr[len(ycbcr.Cr)-1]; cr != byte(16+i) {
+					t.Errorf("frame %d last Cr byte = %d, want %d", i, cr, 16+i)
+				}
+			}
+			if r.Rate() != "30:1" {
+				t.Errorf("rate = %q, want 30:1", r.Rate())
+			}
+			if _, err := r.Next(); err != io.EOF {
+				t.Errorf("error at the end of the stream = %v, want io.EOF", err)
+			}
+		})
+	}
+}
+
+func TestFrameReaderY4MUnsupportedColorspace(t *testing.T) {
+	for _, colorspace := range []string{"420p10", "422p12", "444p16", "mono16", "444alpha", "411"} {
+		t.Run(colorspace, func(t *testing.T) {
+			r := NewFrameReader(bufio.NewReader(bytes.NewReader(y4mStream("W4 H2 C"+colorspace, 1, 64))))
+			_, err := r.Next()
+			if err == nil || !strings.Contains(err.Error(), "unsupported y4m colorspace") {
+				t.Errorf("error = %v, want an unsupported colorspace error", err)
+			}
+		})
+	}
+}
+
+func TestFrameWriterY4MRoundTrip(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 100), G: uint8(y * 200), B: 50, A: 255})
+		}
+	}
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, img); err != nil {
+		t.Fatal(err)
+	}
+
+	var stream bytes.Buffer
+	w, err := NewFrameWriter(&stream, FrameFormatY4M, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := w.WriteFrame(encoded.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !strings.HasPrefix(stream.String(), "YUV4MPEG2 W3 H2 F25:1 ") {
+		t.Errorf("stream header = %q", strings.SplitN(stream.String(), "\n", 2)[0])
+	}
+
+	r := NewFrameReader(bufio.NewReader(&stream))
+	for i := 0; i < 2; i++ {
+		frame, err := r.Next()
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		want := color.YCbCrModel.Convert(img.At(2, 1)).(color.YCbCr)
+		if got := frame.Image.(*image.YCbCr).YCbCrAt(2, 1); got != want {
+			t.Errorf("frame %d pixel = %v, want %v", i, got, want)
+		}
+	}
+
+	// frames of another size can't be added to the stream
+	small := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	encoded.Reset()
+	png.Encode(&encoded, small)
+	if err := w.WriteFrame(encoded.Bytes()); err == nil {
+		t.Error("expected an error for a frame of a different size")
+	}
+}
+
+func TestNewFrameWriterFormats(t *testing.T) {
+	for _, format := range []string{"", FrameFormatRaw, FrameFormatPNG, FrameFormatMJPEG, FrameFormatY4M} {
+		if _, err := NewFrameWriter(io.Discard, format, ""); err != nil {
+			t.Errorf("NewFrameWriter(%q): %v", format, err)
+		}
+	}
+	if _, err := NewFrameWriter(io.Discard, "avi", ""); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
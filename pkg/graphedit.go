@@ -0,0 +1,341 @@
+package pkg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/richinsley/comfy2go/graphapi"
+)
+
+// node modes of a ComfyUI workflow
+const (
+	NodeModeAlways = 0
+	NodeModeNever  = 2 // muted, the node and the nodes that depend on it do not run
+	NodeModeBypass = 4 // the node's inputs are passed through to its outputs
+)
+
+// FindNodes returns the nodes of the graph a node selector addresses
+func FindNodes(graph *graphapi.Graph, selector string) ([]*graphapi.GraphNode, error) {
+	p, err := ParseNodeSelector(selector)
+	if err != nil {
+		return nil, fmt.Errorf("node %s: %w", selector, err)
+	}
+	return p.Nodes(graph)
+}
+
+// SetNodeMode sets the mode of the nodes a selector addresses
+func SetNodeMode(graph *graphapi.Graph, selector string, mode int) error {
+	nodes, err := FindNodes(graph, selector)
+	if err != nil {
+		return err
+	}
+	for _, n := range nodes {
+		n.Mode = mode
+	}
+	return nil
+}
+
+// ReplaceNodeType changes the class of the nodes a selector addresses to newType.  Widget values
+// and links are kept for the inputs and outputs the new class has with the same name (or for
+// outputs, the same type).  Inputs and outputs the new class has no match for must not be linked.
+func ReplaceNodeType(graph *graphapi.Graph, selector string, newType string, objects *graphapi.NodeObjects) error {
+	object := objects.GetNodeObjectByName(newType)
+	if object == nil {
+		return fmt.Errorf("unknown node type %s", newType)
+	}
+	nodes, err := FindNodes(graph, selector)
+	if err != nil {
+		return err
+	}
+	for _, n := range nodes {
+		if err := replaceNode(graph, n, object); err != nil {
+			return fmt.Errorf("node %d: %w", n.ID, err)
+		}
+	}
+	return nil
+}
+
+func replaceNode(graph *graphapi.Graph, node *graphapi.GraphNode, object *graphapi.NodeObject) error {
+	if node.IsWidgetValueMap() {
+		return fmt.Errorf("nodes with cascading widgets can't be replaced")
+	}
+
+	// widget values in the order of the new class's widgets
+	values := make([]interface{}, 0)
+	for _, p := range object.GetSettableProperties() {
+		if old := node.GetPropertyWithName(p.Name()); old != nil && old.TypeString() == p.TypeString() {
+			values = append(values, old.GetValue())
+		} else {
+			values = append(values, widgetDefault(object, p))
+		}
+	}
+
+	// link inputs first, then widgets converted to inputs
+	inputs := make([]graphapi.Slot, 0)
+	converted := make([]graphapi.Slot, 0)
+	names := append(append([]string{}, object.Input.OrderedRequired...), object.Input.OrderedOptional...)
+	for _, name := range names {
+		old := node.GetInputWithName(name)
+		if p, ok := object.InputPropertiesByID[name]; ok && (*p).Settable() {
+			if old != nil && old.Widget != nil && old.Link != 0 {
+				converted = append(converted, *old)
+			}
+			continue
+		}
+		slot := graphapi.Slot{Name: name, Type: objectInputType(object, name)}
+		if old != nil {
+			slot.Link = old.Link
+		}
+		inputs = append(inputs, slot)
+	}
+	inputs = append(inputs, converted...)
+
+	for _, old := range node.Inputs {
+		if old.Link == 0 {
+			continue
+		}
+		index := slotWithName(inputs, old.Name)
+		if index == -1 {
+			return fmt.Errorf("input %s is linked but %s has no such input", old.Name, object.Name)
+		}
+		if link := graph.GetLinkById(old.Link); link != nil {
+			link.TargetSlot = index
+		}
+	}
+
+	// outputs keep the links of the old output with the same name, or else the same type
+	outputs := make([]graphapi.Slot, 0)
+	used := make(map[int]bool)
+	var outputNames []interface{}
+	if object.OutputName != nil {
+		outputNames, _ = (*object.OutputName).([]interface{})
+	}
+	if object.Output != nil {
+		for i, t := range *object.Output {
+			index := i
+			slot := graphapi.Slot{Type: slotType(t), SlotIndex: &index}
+			slot.Name = slot.Type
+			if i < len(outputNames) {
+				slot.Name = fmt.Sprint(outputNames[i])
+			}
+			old := slotWithName(node.Outputs, slot.Name)
+			if old == -1 || used[old] {
+				old = -1
+				for j, o := range node.Outputs {
+					if !used[j] && o.Type == slot.Type {
+						old = j
+						break
+					}
+				}
+			}
+			if old != -1 {
+				used[old] = true
+				slot.Links = node.Outputs[old].Links
+			}
+			outputs = append(outputs, slot)
+		}
+	}
+
+	for i, old := range node.Outputs {
+		if old.Links == nil || len(*old.Links) == 0 {
+			continue
+		}
+		if !used[i] {
+			return fmt.Errorf("output %s is linked but %s has no such output", old.Name, object.Name)
+		}
+	}
+	for i, o := range outputs {
+		if o.Links == nil {
+			continue
+		}
+		for _, id := range *o.Links {
+			if link := graph.GetLinkById(id); link != nil {
+				link.OriginSlot = i
+			}
+		}
+	}
+
+	node.Type = object.Name
+	node.WidgetValues = values
+	node.Inputs = inputs
+	node.Outputs = outputs
+	if node.InternalProperties != nil {
+		if _, ok := (*node.InternalProperties)["Node name for S&R"]; ok {
+			(*node.InternalProperties)["Node name for S&R"] = object.Name
+		}
+	}
+	// the properties of the old class no longer address the node's widgets
+	node.Properties = make(map[string]graphapi.Property)
+	return nil
+}
+
+// LinkSlots links the output of one node to the input of another, replacing the input's link.
+// Slots are addressed as "node.slot", outputs by name, type or index, and inputs by name.
+func LinkSlots(graph *graphapi.Graph, from string, to string) error {
+	origin, output, err := findSlot(graph, from)
+	if err != nil {
+		return err
+	}
+	target, input, err := findSlot(graph, to)
+	if err != nil {
+		return err
+	}
+	return linkNodes(graph, origin, output, target, input)
+}
+
+// linkNodes links the output of origin to the input of target, replacing the input's link
+func linkNodes(graph *graphapi.Graph, origin *graphapi.GraphNode, output string, target *graphapi.GraphNode, input string) error {
+	oslot := -1
+	if i, err := strconv.Atoi(output); err == nil && i >= 0 && i < len(origin.Outputs) {
+		oslot = i
+	} else if oslot = slotWithName(origin.Outputs, output); oslot == -1 {
+		for i, o := range origin.Outputs {
+			if o.Type == output {
+				oslot = i
+				break
+			}
+		}
+	}
+	if oslot == -1 {
+		return fmt.Errorf("node %d has no output %s", origin.ID, output)
+	}
+	tslot := slotWithName(target.Inputs, input)
+	if tslot == -1 {
+		return fmt.Errorf("node %d has no input %s", target.ID, input)
+	}
+
+	removeLink(graph, target.Inputs[tslot].Link)
+	graph.LastLinkID++
+	link := &graphapi.Link{
+		ID:         graph.LastLinkID,
+		OriginID:   origin.ID,
+		OriginSlot: oslot,
+		TargetID:   target.ID,
+		TargetSlot: tslot,
+		Type:       origin.Outputs[oslot].Type,
+	}
+	graph.Links = append(graph.Links, link)
+	graph.LinksByID[link.ID] = link
+	target.Inputs[tslot].Link = link.ID
+	out := &origin.Outputs[oslot]
+	if out.Links == nil {
+		out.Links = &[]int{}
+	}
+	*out.Links = append(*out.Links, link.ID)
+	return nil
+}
+
+// UnlinkSlot removes the link of a node's input, addressed as "node.input"
+func UnlinkSlot(graph *graphapi.Graph, to string) error {
+	target, input, err := findSlot(graph, to)
+	if err != nil {
+		return err
+	}
+	tslot := slotWithName(target.Inputs, input)
+	if tslot == -1 {
+		return fmt.Errorf("node %d has no input %s", target.ID, input)
+	}
+	removeLink(graph, target.Inputs[tslot].Link)
+	return nil
+}
+
+// findSlot splits "node.slot" at the last "." and returns the node and the slot name
+func findSlot(graph *graphapi.Graph, address string) (*graphapi.GraphNode, string, error) {
+	dot := strings.LastIndex(address, ".")
+	if dot <= 0 || dot == len(address)-1 {
+		return nil, "", fmt.Errorf("expected node.slot, got %s", address)
+	}
+	nodes, err := FindNodes(graph, address[:dot])
+	if err != nil {
+		return nil, "", err
+	}
+	if len(nodes) > 1 {
+		return nil, "", fmt.Errorf("%s matches %d nodes, a link needs a single node", address[:dot], len(nodes))
+	}
+	return nodes[0], address[dot+1:], nil
+}
+
+// removeLink removes a link from the graph and from the slots it connects
+func removeLink(graph *graphapi.Graph, id int) {
+	link := graph.GetLinkById(id)
+	if link == nil {
+		return
+	}
+	if origin := graph.GetNodeById(link.OriginID); origin != nil && link.OriginSlot < len(origin.Outputs) {
+		if links := origin.Outputs[link.OriginSlot].Links; links != nil {
+			kept := make([]int, 0, len(*links))
+			for _, l := range *links {
+				if l != id {
+					kept = append(kept, l)
+				}
+			}
+			*links = kept
+		}
+	}
+	if target := graph.GetNodeById(link.TargetID); target != nil && link.TargetSlot < len(target.Inputs) {
+		if target.Inputs[link.TargetSlot].Link == id {
+			target.Inputs[link.TargetSlot].Link = 0
+		}
+	}
+	for i, l := range graph.Links {
+		if l == link {
+			graph.Links = append(graph.Links[:i], graph.Links[i+1:]...)
+			break
+		}
+	}
+	delete(graph.LinksByID, id)
+}
+
+func slotWithName(slots []graphapi.Slot, name string) int {
+	for i, s := range slots {
+		if s.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// slotType returns the type of a node object input or output.  Combo types are lists of values.
+func slotType(t interface{}) string {
+	if s, ok := t.(string); ok {
+		return s
+	}
+	return "COMBO"
+}
+
+func objectInputType(object *graphapi.NodeObject, name string) string {
+	input, ok := object.Input.Required[name]
+	if !ok {
+		input = object.Input.Optional[name]
+	}
+	if input == nil {
+		return "*"
+	}
+	if spec, ok := (*input).([]interface{}); ok && len(spec) > 0 {
+		return slotType(spec[0])
+	}
+	return "*"
+}
+
+// widgetDefault returns the default value of a widget, from the options of the node object's
+// input.  Combos default to their first value.
+func widgetDefault(object *graphapi.NodeObject, p graphapi.Property) interface{} {
+	input, ok := object.Input.Required[p.Name()]
+	if !ok {
+		input = object.Input.Optional[p.Name()]
+	}
+	if input != nil {
+		if spec, ok := (*input).([]interface{}); ok && len(spec) > 1 {
+			if options, ok := spec[1].(map[string]interface{}); ok {
+				if d, ok := options["default"]; ok {
+					return d
+				}
+			}
+		}
+	}
+	if c, ok := p.(*graphapi.ComboProperty); ok && len(c.Values) > 0 {
+		return c.Values[0]
+	}
+	return nil
+}
@@ -0,0 +1,459 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// HostStatus is a snapshot of the state of a ComfyUI host
+type HostStatus struct {
+	Host         string        `json:"host"`
+	Connected    bool          `json:"connected"`
+	Error        string        `json:"error,omitempty"`
+	Devices      []DeviceStats `json:"devices"`
+	RAMTotal     int64         `json:"ram_total"`
+	RAMFree      int64         `json:"ram_free"`
+	VRAMUsed     []float64     `json:"-"` // fraction of the first device's VRAM in use, oldest first
+	RAMUsed      []float64     `json:"-"` // fraction of RAM in use, oldest first
+	QueueRunning int           `json:"queue_running"`
+	QueuePending int           `json:"queue_pending"`
+	PromptID     string        `json:"prompt_id,omitempty"` // the running prompt
+	Running      time.Time     `json:"running_since"`       // when the running prompt was first seen running
+	Node         string        `json:"node,omitempty"`      // the executing node of the running prompt
+	Progress     int           `json:"progress,omitempty"`
+	ProgressMax  int           `json:"progress_max,omitempty"`
+	Jobs         []JobStatus   `json:"jobs"`           // recent jobs, newest first
+	Completed    int           `json:"jobs_completed"` // jobs that succeeded since the monitor started
+	Failed       int           `json:"jobs_failed"`    // jobs that failed or were interrupted since the monitor started
+	JobsTimed    int           `json:"jobs_timed"`     // jobs of the counters with a start and end in the history
+	JobsSeconds  float64       `json:"jobs_seconds"`   // total execution time of the timed jobs
+	Updated      time.Time     `json:"updated"`
+}
+
+// DeviceStats is the memory of a device of a ComfyUI host
+type DeviceStats struct {
+	Name           string `json:"name"`
+	Type           string `json:"type"`
+	VRAMTotal      int64  `json:"vram_total"`
+	VRAMFree       int64  `json:"vram_free"`
+	TorchVRAMTotal int64  `json:"torch_vram_total"`
+	TorchVRAMFree  int64  `json:"torch_vram_free"`
+}
+
+// JobStatus is a prompt in the history of a ComfyUI host
+type JobStatus struct {
+	PromptID string        `json:"prompt_id"`
+	Number   int           `json:"number"`
+	Status   string        `json:"status"` // success, error or interrupted
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+}
+
+// HostMonitor follows the state of a ComfyUI host.  System stats, the queue and the history
+// are polled, and the executing node and its progress are followed over a websocket.  ComfyUI
+// sends the "executing" and "progress" events only to the client that queued a prompt, or to
+// every client for prompts queued without a client id, so the executing node and progress of
+// a prompt queued by another client are never seen.
+type HostMonitor struct {
+	Host    string
+	Port    int
+	Samples int // number of memory samples kept for sparklines
+	Jobs    int // number of recent jobs kept
+
+	mu         sync.Mutex
+	status     HostStatus
+	nodeTitles map[string]string // node id to title, for the running prompt
+	titlesID   string            // id of the prompt of nodeTitles
+	nodeID     string            // id of the executing node
+	counted    int               // highest prompt number counted in the job counters
+	seeded     bool              // the job counters start from the first history read
+	http       *http.Client
+}
+
+// NewHostMonitor creates a monitor for a host
+func NewHostMonitor(host string, port int) *HostMonitor {
+	return &HostMonitor{
+		Host:       host,
+		Port:       port,
+		Samples:    60,
+		Jobs:       8,
+		status:     HostStatus{Host: fmt.Sprintf("%s:%d", host, port)},
+		nodeTitles: make(map[string]string),
+		counted:    -1,
+		http:       &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Run polls the host every interval and follows its websocket until stop is closed
+func (m *HostMonitor) Run(interval time.Duration, stop chan struct{}) {
+	go m.follow(stop)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m.Poll()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Status returns a snapshot of the host's state
+func (m *HostMonitor) Status() HostStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.status
+	s.Devices = append([]DeviceStats{}, s.Devices...)
+	s.VRAMUsed = append([]float64{}, s.VRAMUsed...)
+	s.RAMUsed = append([]float64{}, s.RAMUsed...)
+	s.Jobs = append([]JobStatus{}, s.Jobs...)
+	return s
+}
+
+// Interrupt stops the prompt the host is executing
+func (m *HostMonitor) Interrupt() error {
+	return m.post("/interrupt", nil)
+}
+
+// ClearQueue removes the pending prompts of the host's queue
+func (m *HostMonitor) ClearQueue() error {
+	return m.post("/queue", map[string]bool{"clear": true})
+}
+
+// Poll updates the system stats, queue and history of the host
+func (m *HostMonitor) Poll() {
+	var stats struct {
+		System struct {
+			RAMTotal int64 `json:"ram_total"`
+			RAMFree  int64 `json:"ram_free"`
+		} `json:"system"`
+		Devices []DeviceStats `json:"devices"`
+	}
+	// queue entries are [number, prompt id, prompt, extra data, outputs]
+	var queue struct {
+		Running []json.RawMessage `json:"queue_running"`
+		Pending []json.RawMessage `json:"queue_pending"`
+	}
+	var history map[string]historyEntry
+
+	err := m.get("/system_stats", &stats)
+	if err == nil {
+		err = m.get("/queue", &queue)
+	}
+	if err == nil {
+		history, err = m.readHistory()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.status.Updated = time.Now()
+	if err != nil {
+		m.status.Connected = false
+		m.status.Error = err.Error()
+		return
+	}
+	m.status.Connected = true
+	m.status.Error = ""
+
+	m.status.Devices = stats.Devices
+	m.status.RAMTotal = stats.System.RAMTotal
+	m.status.RAMFree = stats.System.RAMFree
+	if len(stats.Devices) > 0 && stats.Devices[0].VRAMTotal > 0 {
+		d := stats.Devices[0]
+		m.status.VRAMUsed = appendSample(m.status.VRAMUsed, float64(d.VRAMTotal-d.VRAMFree)/float64(d.VRAMTotal), m.Samples)
+	}
+	if stats.System.RAMTotal > 0 {
+		m.status.RAMUsed = appendSample(m.status.RAMUsed, float64(stats.System.RAMTotal-stats.System.RAMFree)/float64(stats.System.RAMTotal), m.Samples)
+	}
+
+	m.status.QueueRunning = len(queue.Running)
+	m.status.QueuePending = len(queue.Pending)
+	if len(queue.Running) == 0 {
+		m.status.PromptID = ""
+		m.status.Running = time.Time{}
+		m.status.Node = ""
+		m.status.Progress, m.status.ProgressMax = 0, 0
+	} else {
+		var entry []json.RawMessage
+		var promptID string
+		var prompt map[string]struct {
+			ClassType string `json:"class_type"`
+			Meta      struct {
+				Title string `json:"title"`
+			} `json:"_meta"`
+		}
+		if json.Unmarshal(queue.Running[0], &entry) == nil && len(entry) > 2 {
+			json.Unmarshal(entry[1], &promptID)
+			json.Unmarshal(entry[2], &prompt)
+		}
+		if promptID != m.status.PromptID {
+			m.status.PromptID = promptID
+			m.status.Running = time.Now()
+			m.status.Node = ""
+			m.status.Progress, m.status.ProgressMax = 0, 0
+		}
+		if promptID != m.titlesID {
+			m.titlesID = promptID
+			m.nodeTitles = make(map[string]string)
+			for id, n := range prompt {
+				m.nodeTitles[id] = n.ClassType
+				if n.Meta.Title != "" {
+					m.nodeTitles[id] = n.Meta.Title
+				}
+			}
+		}
+		// the node may have started executing before its prompt was polled
+		if title, ok := m.nodeTitles[m.nodeID]; ok {
+			m.status.Node = title
+		}
+	}
+
+	jobs := make([]JobStatus, 0, len(history))
+	counted := m.counted
+	if m.seeded && highestNumber(history) < counted {
+		// the host restarted and numbers its prompts from the start again
+		counted = -1
+	}
+	for id, h := range history {
+		job := JobStatus{PromptID: id, Number: h.number(), Status: h.Status.Status}
+		// messages are [event, {"timestamp": ms}] from the start to the end of the prompt
+		var first, last int64
+		for _, msg := range h.Status.Messages {
+			if len(msg) < 2 {
+				continue
+			}
+			var event string
+			var data struct {
+				Timestamp int64 `json:"timestamp"`
+			}
+			json.Unmarshal(msg[0], &event)
+			json.Unmarshal(msg[1], &data)
+			if event == "execution_start" {
+				first = data.Timestamp
+			}
+			if event == "execution_interrupted" {
+				job.Status = "interrupted"
+			}
+			last = max(last, data.Timestamp)
+		}
+		if first > 0 {
+			job.Started = time.UnixMilli(first)
+			job.Duration = time.Duration(last-first) * time.Millisecond
+		}
+		// prompts are added to the history as they finish, the first read only seeds the counters
+		if m.seeded && job.Number > counted {
+			if job.Status == "success" {
+				m.status.Completed++
+			} else {
+				m.status.Failed++
+			}
+			if first > 0 {
+				m.status.JobsTimed++
+				m.status.JobsSeconds += job.Duration.Seconds()
+			}
+		}
+		jobs = append(jobs, job)
+	}
+	if len(history) > 0 {
+		m.counted = highestNumber(history)
+	}
+	m.seeded = true
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Number > jobs[j].Number })
+	if len(jobs) > m.Jobs {
+		jobs = jobs[:m.Jobs]
+	}
+	m.status.Jobs = jobs
+}
+
+// historyEntry is a prompt of the history of a ComfyUI host
+type historyEntry struct {
+	Prompt []json.RawMessage `json:"prompt"` // [number, prompt id, prompt, extra data, outputs]
+	Status struct {
+		Status   string              `json:"status_str"`
+		Messages [][]json.RawMessage `json:"messages"`
+	} `json:"status"`
+}
+
+func (h historyEntry) number() int {
+	number := 0
+	if len(h.Prompt) > 0 {
+		json.Unmarshal(h.Prompt[0], &number)
+	}
+	return number
+}
+
+func highestNumber(history map[string]historyEntry) int {
+	highest := -1
+	for _, h := range history {
+		highest = max(highest, h.number())
+	}
+	return highest
+}
+
+// readHistory reads the recent prompts of the host's history.  More of the history is read
+// while every prompt read is new to the job counters, so a burst of prompts finishing between
+// polls is counted in full.
+func (m *HostMonitor) readHistory() (map[string]historyEntry, error) {
+	m.mu.Lock()
+	seeded, counted := m.seeded, m.counted
+	m.mu.Unlock()
+
+	items := m.Jobs
+	for {
+		var history map[string]historyEntry
+		if err := m.get(fmt.Sprintf("/history?max_items=%d", items), &history); err != nil {
+			return nil, err
+		}
+		if !seeded || len(history) < items || items >= 100000 {
+			return history, nil
+		}
+		oldest := -1
+		for _, h := range history {
+			if oldest < 0 || h.number() < oldest {
+				oldest = h.number()
+			}
+		}
+		if oldest <= counted || highestNumber(history) < counted {
+			// the read reaches back to counted prompts, or the host restarted
+			return history, nil
+		}
+		items *= 4
+	}
+}
+
+// follow reads the host's websocket for the queue, and for the executing node and its progress
+// of the prompts the host sends them for, reconnecting until stop is closed
+func (m *HostMonitor) follow(stop chan struct{}) {
+	url := fmt.Sprintf("ws://%s:%d/ws?clientId=%s", m.Host, m.Port, uuid.New().String())
+	for {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err == nil {
+			go func() {
+				<-stop
+				conn.Close()
+			}()
+			for {
+				mtype, message, err := conn.ReadMessage()
+				if err != nil {
+					break
+				}
+				if mtype == websocket.TextMessage {
+					m.handleMessage(message)
+				}
+			}
+			conn.Close()
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (m *HostMonitor) handleMessage(message []byte) {
+	var msg struct {
+		Type string `json:"type"`
+		Data struct {
+			PromptID string      `json:"prompt_id"`
+			Node     interface{} `json:"node"`
+			Value    int         `json:"value"`
+			Max      int         `json:"max"`
+			Status   struct {
+				ExecInfo struct {
+					QueueRemaining int `json:"queue_remaining"`
+				} `json:"exec_info"`
+			} `json:"status"`
+		} `json:"data"`
+	}
+	if json.Unmarshal(message, &msg) != nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch msg.Type {
+	case "status":
+		// the queue count includes the running prompt
+		remaining := msg.Data.Status.ExecInfo.QueueRemaining
+		m.status.QueueRunning = min(remaining, 1)
+		m.status.QueuePending = max(remaining-1, 0)
+	case "execution_start":
+		if msg.Data.PromptID != m.status.PromptID {
+			m.status.PromptID = msg.Data.PromptID
+			m.status.Running = time.Now()
+		}
+	case "execution_error", "execution_interrupted":
+		m.nodeID = ""
+	case "executing":
+		m.status.Progress, m.status.ProgressMax = 0, 0
+		switch node := msg.Data.Node.(type) {
+		case string:
+			m.nodeID = node
+		case float64:
+			m.nodeID = strconv.Itoa(int(node))
+		default:
+			m.nodeID = ""
+		}
+		m.status.Node = m.nodeID
+		if title, ok := m.nodeTitles[m.nodeID]; ok {
+			m.status.Node = title
+		}
+	case "progress":
+		m.status.Progress, m.status.ProgressMax = msg.Data.Value, msg.Data.Max
+	}
+}
+
+func (m *HostMonitor) get(path string, v interface{}) error {
+	resp, err := m.http.Get(fmt.Sprintf("http://%s:%d%s", m.Host, m.Port, path))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+func (m *HostMonitor) post(path string, v interface{}) error {
+	var body []byte
+	if v != nil {
+		var err error
+		if body, err = json.Marshal(v); err != nil {
+			return err
+		}
+	}
+	resp, err := m.http.Post(fmt.Sprintf("http://%s:%d%s", m.Host, m.Port, path), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("POST %s: %s", path, resp.Status)
+	}
+	return nil
+}
+
+func appendSample(samples []float64, v float64, size int) []float64 {
+	samples = append(samples, v)
+	if len(samples) > size {
+		samples = samples[len(samples)-size:]
+	}
+	return samples
+}
@@ -2,35 +2,236 @@ package pkg
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
 	"fmt"
 	"image"
-
-	// "image/gif"
-	"image/jpeg"
+	"image/color"
 	"image/png"
+	"io"
 )
 
-// ExpectImage tries to read an image from the given reader and returns it.
-func ExpectImage(reader *bufio.Reader) (image.Image, error) {
-	var image image.Image
-	header, err := reader.Peek(4)
-	if err != nil {
+// image formats that can be uploaded to ComfyUI
+const (
+	ImageFormatPNG  = "png"
+	ImageFormatJPEG = "jpeg"
+	ImageFormatGIF  = "gif"
+	ImageFormatWebP = "webp"
+	ImageFormatBMP  = "bmp"
+	ImageFormatTIFF = "tiff"
+)
+
+// number of bytes needed by ImageFormat to identify an image
+const imageHeaderSize = 12
+
+var imageExtensions = map[string]string{
+	ImageFormatPNG:  ".png",
+	ImageFormatJPEG: ".jpg",
+	ImageFormatGIF:  ".gif",
+	ImageFormatWebP: ".webp",
+	ImageFormatBMP:  ".bmp",
+	ImageFormatTIFF: ".tiff",
+}
+
+// ImageFormat identifies an image format from its first bytes, returning an empty string if
+// the format is unknown
+func ImageFormat(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, pngSignature):
+		return ImageFormatPNG
+	case bytes.HasPrefix(header, jpegSignature):
+		return ImageFormatJPEG
+	case bytes.HasPrefix(header, []byte("GIF87a")) || bytes.HasPrefix(header, []byte("GIF89a")):
+		return ImageFormatGIF
+	case len(header) >= 12 && bytes.HasPrefix(header, []byte("RIFF")) && string(header[8:12]) == "WEBP":
+		return ImageFormatWebP
+	case len(header) >= 10 && bytes.HasPrefix(header, []byte("BM")) && binary.LittleEndian.Uint32(header[6:10]) == 0:
+		// the reserved fields of the bitmap file header are always zero
+		return ImageFormatBMP
+	case bytes.HasPrefix(header, []byte("II*\x00")) || bytes.HasPrefix(header, []byte("MM\x00*")):
+		return ImageFormatTIFF
+	}
+	return ""
+}
+
+// ImageExtension returns the file extension for an image format
+func ImageExtension(format string) string {
+	if ext, ok := imageExtensions[format]; ok {
+		return ext
+	}
+	return ".png"
+}
+
+// ExpectImage reads a single image from the given reader, keeping its original bytes.  PNG,
+// JPEG, GIF, WebP and BMP images are read up to their end, so more data may follow in the
+// stream.  TIFF has no marker for the end of the image, so it is read to the end of the stream.
+func ExpectImage(reader *bufio.Reader) (*Frame, error) {
+	header, err := reader.Peek(imageHeaderSize)
+	if len(header) == 0 {
 		return nil, fmt.Errorf("failed to read header: %w", err)
 	}
 
-	if header[0] == 0x89 && header[1] == 0x50 {
-		image, err = png.Decode(reader)
-		if err != nil {
-			return nil, fmt.Errorf("failed to decode PNG image: %w", err)
+	format := ImageFormat(header)
+	var data []byte
+	switch format {
+	case ImageFormatPNG:
+		data, err = readPNG(reader)
+	case ImageFormatJPEG:
+		data, err = readJPEG(reader)
+	case ImageFormatGIF:
+		data, err = readGIF(reader)
+	case ImageFormatWebP:
+		// RIFF container: ["RIFF"][size][payload of size bytes]
+		data, err = readSized(reader, int64(binary.LittleEndian.Uint32(header[4:8]))+8, 12)
+	case ImageFormatBMP:
+		// the size includes the 14 byte bitmap file header
+		data, err = readSized(reader, int64(binary.LittleEndian.Uint32(header[2:6])), 14)
+	case ImageFormatTIFF:
+		data, err = io.ReadAll(reader)
+	default:
+		return nil, fmt.Errorf("unknown image format")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s image: %w", format, err)
+	}
+	return &Frame{Format: format, Data: data}, nil
+}
+
+// readSized reads an image whose size is given by its header.  The size comes from the stream,
+// so the buffer grows with the data read rather than being allocated up front.
+func readSized(r io.Reader, size int64, minSize int64) ([]byte, error) {
+	if size < minSize {
+		return nil, fmt.Errorf("invalid image size %d", size)
+	}
+	var buf bytes.Buffer
+	n, err := buf.ReadFrom(io.LimitReader(r, size))
+	if err != nil {
+		return nil, err
+	}
+	if n < size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), nil
+}
+
+// readGIF reads the blocks of a GIF image up to and including the trailer
+func readGIF(r *bufio.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+
+	// header and logical screen descriptor
+	screen := make([]byte, 13)
+	if _, err := io.ReadFull(r, screen); err != nil {
+		return nil, err
+	}
+	buf.Write(screen)
+	if screen[10]&0x80 != 0 {
+		// global color table
+		if _, err := io.CopyN(&buf, r, int64(3<<((screen[10]&0x07)+1))); err != nil {
+			return nil, err
+		}
+	}
+
+	subBlocks := func() error {
+		for {
+			n, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+			buf.WriteByte(n)
+			if n == 0 {
+				return nil
+			}
+			if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+				return err
+			}
 		}
-	} else if header[0] == 0xff && header[1] == 0xd8 {
-		image, err = jpeg.Decode(reader)
+	}
+
+	for {
+		b, err := r.ReadByte()
 		if err != nil {
-			return nil, fmt.Errorf("failed to decode JPEG image: %w", err)
+			return nil, err
+		}
+		buf.WriteByte(b)
+		switch b {
+		case 0x3b: // trailer
+			return buf.Bytes(), nil
+		case 0x21: // extension: [label][sub-blocks]
+			label, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			buf.WriteByte(label)
+			if err := subBlocks(); err != nil {
+				return nil, err
+			}
+		case 0x2c: // image descriptor, local color table, LZW minimum code size and sub-blocks
+			descriptor := make([]byte, 9)
+			if _, err := io.ReadFull(r, descriptor); err != nil {
+				return nil, err
+			}
+			buf.Write(descriptor)
+			if descriptor[8]&0x80 != 0 {
+				if _, err := io.CopyN(&buf, r, int64(3<<((descriptor[8]&0x07)+1))); err != nil {
+					return nil, err
+				}
+			}
+			if _, err := io.CopyN(&buf, r, 1); err != nil {
+				return nil, err
+			}
+			if err := subBlocks(); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unexpected GIF block 0x%02x", b)
+		}
+	}
+}
+
+// hasAlpha returns true if any pixel of the image is not fully opaque
+func hasAlpha(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return !o.Opaque()
+	}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MaskToAlpha encodes a mask as a PNG for ComfyUI's mask upload, which takes the mask from the
+// alpha channel.  Masks that already have transparency are kept as they are.  Opaque masks are
+// treated as grayscale, where white marks the masked area, as in ComfyUI's MASK outputs.
+func MaskToAlpha(frame *Frame) ([]byte, error) {
+	img, err := frame.Decode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s mask: %w", frame.Format, err)
+	}
+	if hasAlpha(img) {
+		if frame.Format == ImageFormatPNG {
+			return frame.Data, nil
 		}
 	} else {
-		return nil, fmt.Errorf("unknown image format")
+		// LoadImage outputs 1 - alpha as the mask
+		bounds := img.Bounds()
+		alpha := image.NewNRGBA(bounds)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				gray := color.GrayModel.Convert(img.At(x, y)).(color.Gray)
+				alpha.SetNRGBA(x, y, color.NRGBA{A: 255 - gray.Y})
+			}
+		}
+		img = alpha
 	}
 
-	return image, nil
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
@@ -0,0 +1,196 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+
+	"golang.org/x/image/bmp"
+)
+
+func testImage() image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 3))
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 4; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 60), G: uint8(y * 80), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func encodeTestImage(t *testing.T, format string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case ImageFormatPNG:
+		err = png.Encode(&buf, testImage())
+	case ImageFormatJPEG:
+		err = jpeg.Encode(&buf, testImage(), nil)
+	case ImageFormatGIF:
+		err = gif.Encode(&buf, testImage(), nil)
+	case ImageFormatBMP:
+		err = bmp.Encode(&buf, testImage())
+	case ImageFormatWebP:
+		// a RIFF container with a payload of 10 bytes is enough to split the stream
+		buf.WriteString("RIFF")
+		binary.Write(&buf, binary.LittleEndian, uint32(14))
+		buf.WriteString("WEBPVP8L")
+		buf.Write(make([]byte, 6))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestImageFormat(t *testing.T) {
+	for _, format := range []string{ImageFormatPNG, ImageFormatJPEG, ImageFormatGIF, ImageFormatBMP, ImageFormatWebP} {
+		data := encodeTestImage(t, format)
+		if got := ImageFormat(data[:imageHeaderSize]); got != format {
+			t.Errorf("ImageFormat(%s) = %q", format, got)
+		}
+	}
+	if got := ImageFormat([]byte("II*\x00\x08\x00\x00\x00")); got != ImageFormatTIFF {
+		t.Errorf("ImageFormat(tiff) = %q", got)
+	}
+	if got := ImageFormat([]byte("not an image")); got != "" {
+		t.Errorf("ImageFormat(text) = %q", got)
+	}
+}
+
+func TestExpectImageKeepsStreamPosition(t *testing.T) {
+	for _, format := range []string{ImageFormatPNG, ImageFormatJPEG, ImageFormatGIF, ImageFormatBMP, ImageFormatWebP} {
+		t.Run(format, func(t *testing.T) {
+			data := encodeTestImage(t, format)
+			r := bufio.NewReader(bytes.NewReader(append(append([]byte{}, data...), "next"...)))
+			frame, err := ExpectImage(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if frame.Format != format || !bytes.Equal(frame.Data, data) {
+				t.Errorf("read %s image of %d bytes, want %s of %d bytes", frame.Format, len(frame.Data), format, len(data))
+			}
+			rest, _ := io.ReadAll(r)
+			if string(rest) != "next" {
+				t.Errorf("stream after the image = %q, want %q", rest, "next")
+			}
+		})
+	}
+}
+
+// set the size field of the header of a bitmap
+func bmpWithSize(t *testing.T, size uint32) []byte {
+	t.Helper()
+	data := encodeTestImage(t, ImageFormatBMP)
+	binary.LittleEndian.PutUint32(data[2:6], size)
+	return data
+}
+
+func TestExpectImageInvalidSize(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"bmp size zero", bmpWithSize(t, 0), nil},
+		{"bmp size smaller than the header", bmpWithSize(t, 10), nil},
+		{"bmp size past the end of the stream", bmpWithSize(t, 0xffffffff), io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ExpectImage(bufio.NewReader(bytes.NewReader(tt.data)))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFrameReaderImages(t *testing.T) {
+	var stream bytes.Buffer
+	formats := []string{ImageFormatPNG, ImageFormatJPEG, ImageFormatBMP, ImageFormatGIF}
+	for i, format := range formats {
+		if i > 0 {
+			// multipart boundaries between frames are skipped
+			stream.WriteString("\r\n--boundary\r\n\r\n")
+		}
+		stream.Write(encodeTestImage(t, format))
+	}
+
+	r := NewFrameReader(bufio.NewReader(&stream))
+	for _, format := range formats {
+		frame, err := r.Next()
+		if err != nil {
+			t.Fatalf("reading %s frame: %v", format, err)
+		}
+		if frame.Format != format {
+			t.Errorf("frame format = %s, want %s", frame.Format, format)
+		}
+		img, err := frame.Decode()
+		if err != nil {
+			t.Fatalf("decoding %s frame: %v", format, err)
+		}
+		if img.Bounds() != image.Rect(0, 0, 4, 3) {
+			t.Errorf("%s frame bounds = %v", format, img.Bounds())
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("error at the end of the stream = %v, want io.EOF", err)
+	}
+}
+
+func TestFrameReaderStopsOnInvalidSize(t *testing.T) {
+	r := NewFrameReader(bufio.NewReader(bytes.NewReader(bmpWithSize(t, 0))))
+	if _, err := r.Next(); err == nil || err == io.EOF {
+		t.Errorf("error = %v, want an invalid size error", err)
+	}
+}
+
+func TestMaskToAlpha(t *testing.T) {
+	// an opaque grayscale mask becomes a PNG whose alpha is the inverse of the mask
+	mask := image.NewGray(image.Rect(0, 0, 2, 1))
+	mask.SetGray(0, 0, color.Gray{Y: 255})
+	mask.SetGray(1, 0, color.Gray{Y: 0})
+	var buf bytes.Buffer
+	if err := bmp.Encode(&buf, mask); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := MaskToAlpha(&Frame{Format: ImageFormatBMP, Data: buf.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, a := img.At(0, 0).RGBA(); a != 0 {
+		t.Errorf("alpha of the masked pixel = %d, want 0", a)
+	}
+	if _, _, _, a := img.At(1, 0).RGBA(); a != 0xffff {
+		t.Errorf("alpha of the unmasked pixel = %d, want 0xffff", a)
+	}
+
+	// PNG masks with transparency are uploaded unchanged
+	var alpha bytes.Buffer
+	png.Encode(&alpha, image.NewNRGBA(image.Rect(0, 0, 2, 2)))
+	data, err = MaskToAlpha(&Frame{Format: ImageFormatPNG, Data: alpha.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, alpha.Bytes()) {
+		t.Error("PNG mask with transparency was re-encoded")
+	}
+}
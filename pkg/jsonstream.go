@@ -0,0 +1,238 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// JsonStream reads a stream of JSON values.  Newline delimited JSON, concatenated values (which
+// may span any number of lines) and the elements of top level arrays are all read one value at
+// a time, in time linear to the size of the stream and without a limit on the size of a value.
+type JsonStream struct {
+	r       *bufio.Reader
+	line    int  // line of the next byte to read
+	atStart bool // the next byte starts a line
+	inArray bool // reading the elements of a top level array
+}
+
+// errValueCut is a value cut short by an object starting a line where the value can't have one,
+// as when a record of newline delimited JSON is truncated.  The object starts the next value.
+var errValueCut = errors.New("value is cut short by the object on the next line")
+
+// JsonRecordError is a malformed value in a JsonStream.  The stream skips the value, so the
+// next call to Next reads the value after it.
+type JsonRecordError struct {
+	Line int
+	Err  error
+}
+
+func (e *JsonRecordError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *JsonRecordError) Unwrap() error {
+	return e.Err
+}
+
+func NewJsonStream(r io.Reader) *JsonStream {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &JsonStream{r: br, line: 1, atStart: true}
+}
+
+func (s *JsonStream) readByte() (byte, error) {
+	b, err := s.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	s.atStart = b == '\n'
+	if s.atStart {
+		s.line++
+	}
+	return b, nil
+}
+
+// resync skips to the next line that starts with an object, after a malformed value
+func (s *JsonStream) resync() {
+	for {
+		if !s.atStart {
+			if _, err := s.readByte(); err != nil {
+				return
+			}
+			continue
+		}
+		b, err := s.r.Peek(1)
+		if err != nil || b[0] == '{' {
+			return
+		}
+		if b[0] == ' ' || b[0] == '\t' {
+			// skip the indentation of the line
+			s.r.ReadByte()
+			continue
+		}
+		s.atStart = false
+	}
+}
+
+// Next returns the next value of the stream, or io.EOF at the end of the stream.  A malformed
+// value returns a *JsonRecordError.
+func (s *JsonStream) Next() (interface{}, error) {
+	for {
+		b, err := s.readByte()
+		if err != nil {
+			if err == io.EOF && s.inArray {
+				s.inArray = false
+				return nil, &JsonRecordError{Line: s.line, Err: errors.New("unexpected end of array")}
+			}
+			return nil, err
+		}
+
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case ',':
+			if s.inArray {
+				continue
+			}
+		case '[':
+			if !s.inArray {
+				s.inArray = true
+				continue
+			}
+		case ']':
+			if s.inArray {
+				s.inArray = false
+				continue
+			}
+		}
+
+		line := s.line
+		data, err := s.readValue(b)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			if err != errValueCut {
+				s.resync()
+			}
+			return nil, &JsonRecordError{Line: line, Err: err}
+		}
+
+		var value interface{}
+		if err := json.Unmarshal(data, &value); err != nil {
+			var serr *json.SyntaxError
+			if errors.As(err, &serr) && serr.Offset > 0 && int(serr.Offset) <= len(data) {
+				// report the line of the error rather than the start of the value
+				line += bytes.Count(data[:serr.Offset-1], []byte{'\n'})
+			}
+			return nil, &JsonRecordError{Line: line, Err: err}
+		}
+		return value, nil
+	}
+}
+
+// readValue reads the bytes of the value starting with first.  Objects and arrays are read up
+// to their matching close, other values up to the next delimiter.  A string broken by a new
+// line can't be valid JSON and returns an error.  An object starting a line where no value can
+// follow ends the value with errValueCut, and is left to be read as the next value, so a
+// truncated record does not swallow the records after it.
+func (s *JsonStream) readValue(first byte) ([]byte, error) {
+	buf := bytes.NewBuffer([]byte{first})
+
+	switch first {
+	case '{', '[':
+	case '}', ']':
+		return nil, fmt.Errorf("unexpected '%c'", first)
+	case '"':
+		// read to the closing quote
+		for {
+			c, err := s.readByte()
+			if err != nil {
+				return nil, err
+			}
+			if c == '\n' {
+				return nil, errors.New("unterminated string")
+			}
+			buf.WriteByte(c)
+			if c == '"' {
+				return buf.Bytes(), nil
+			}
+			if c == '\\' {
+				// keep the escaped character
+				if c, err = s.readByte(); err != nil {
+					return nil, err
+				}
+				buf.WriteByte(c)
+			}
+		}
+	default:
+		// numbers and literals end at the next delimiter
+		for {
+			b, err := s.r.Peek(1)
+			if err != nil || bytes.IndexByte([]byte(" \t\r\n,[]{}\""), b[0]) >= 0 {
+				return buf.Bytes(), nil
+			}
+			c, _ := s.readByte()
+			buf.WriteByte(c)
+		}
+	}
+
+	containers := []byte{first} // the open objects and arrays
+	prev := first               // the last byte outside strings and white space
+	blank := false              // only white space since the last new line
+	inString := false
+	for len(containers) > 0 {
+		b, err := s.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == '{' && blank && !inString {
+			// a value follows a key, or starts or follows another element of an array
+			open := containers[len(containers)-1]
+			if prev != ':' && !(open == '[' && (prev == '[' || prev == ',')) {
+				s.r.UnreadByte()
+				return nil, errValueCut
+			}
+		}
+		buf.WriteByte(b)
+
+		if inString {
+			switch b {
+			case '\\':
+				c, err := s.readByte()
+				if err != nil {
+					return nil, err
+				}
+				buf.WriteByte(c)
+			case '"':
+				inString = false
+			case '\n':
+				return nil, errors.New("unterminated string")
+			}
+			continue
+		}
+
+		switch b {
+		case ' ', '\t', '\r':
+			continue
+		case '\n':
+			blank = true
+			continue
+		case '"':
+			inString = true
+		case '{', '[':
+			containers = append(containers, b)
+		case '}', ']':
+			containers = containers[:len(containers)-1]
+		}
+		prev = b
+		blank = false
+	}
+	return buf.Bytes(), nil
+}
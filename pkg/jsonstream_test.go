@@ -0,0 +1,126 @@
+package pkg
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// read every value and error of a stream, with errors recorded by their line
+func readJsonStream(t *testing.T, input string) ([]interface{}, []int) {
+	t.Helper()
+	s := NewJsonStream(strings.NewReader(input))
+	var values []interface{}
+	var errLines []int
+	for i := 0; ; i++ {
+		if i > 100 {
+			t.Fatal("stream did not end")
+		}
+		value, err := s.Next()
+		if err == io.EOF {
+			return values, errLines
+		}
+		if err != nil {
+			var rerr *JsonRecordError
+			if !errors.As(err, &rerr) {
+				t.Fatalf("unexpected error type %T: %v", err, err)
+			}
+			errLines = append(errLines, rerr.Line)
+			continue
+		}
+		values = append(values, value)
+	}
+}
+
+func TestJsonStream(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		values   []interface{}
+		errLines []int
+	}{
+		{
+			name:   "ndjson",
+			input:  "{\"a\":1}\n{\"b\":\"x\"}\n",
+			values: []interface{}{map[string]interface{}{"a": 1.0}, map[string]interface{}{"b": "x"}},
+		},
+		{
+			name:   "concatenated objects",
+			input:  `{"a":1}{"b":2} {"c":3}`,
+			values: []interface{}{map[string]interface{}{"a": 1.0}, map[string]interface{}{"b": 2.0}, map[string]interface{}{"c": 3.0}},
+		},
+		{
+			name:   "top level array",
+			input:  "[\n  {\"a\":1},\n  {\"b\":2}\n]\n",
+			values: []interface{}{map[string]interface{}{"a": 1.0}, map[string]interface{}{"b": 2.0}},
+		},
+		{
+			name:  "pretty printed nested objects",
+			input: "{\"a\":\n{\"b\":1}}\n{\n  \"c\": {\n    \"d\": [1, 2]\n  }\n}\n",
+			values: []interface{}{
+				map[string]interface{}{"a": map[string]interface{}{"b": 1.0}},
+				map[string]interface{}{"c": map[string]interface{}{"d": []interface{}{1.0, 2.0}}},
+			},
+		},
+		{
+			name:   "braces in strings",
+			input:  "{\"a\":\"}{\\\"\"}\n{\"b\":\"[\"}\n",
+			values: []interface{}{map[string]interface{}{"a": "}{\""}, map[string]interface{}{"b": "["}},
+		},
+		{
+			name:     "bad record in the middle",
+			input:    "{\"a\":1}\n{\"b\":,}\n{\"c\":3}\n",
+			values:   []interface{}{map[string]interface{}{"a": 1.0}, map[string]interface{}{"c": 3.0}},
+			errLines: []int{2},
+		},
+		{
+			name:     "unterminated string resyncs at the next object",
+			input:    "{\"a\":1}\n{\"b\":\"x}\n{\"c\":3}\n",
+			values:   []interface{}{map[string]interface{}{"a": 1.0}, map[string]interface{}{"c": 3.0}},
+			errLines: []int{2},
+		},
+		{
+			name:     "truncated record in the middle",
+			input:    "{\"a\":1}\n{\"b\":2\n{\"c\":3}\n{\"d\":4}\n",
+			values:   []interface{}{map[string]interface{}{"a": 1.0}, map[string]interface{}{"c": 3.0}, map[string]interface{}{"d": 4.0}},
+			errLines: []int{2},
+		},
+		{
+			name:     "truncated record after a comma",
+			input:    "{\"a\":1,\n  {\"c\":3}\n",
+			values:   []interface{}{map[string]interface{}{"c": 3.0}},
+			errLines: []int{1},
+		},
+		{
+			name:   "objects starting lines inside arrays",
+			input:  "{\"a\": [\n{\"b\":1},\n{\"c\":2}\n]}\n",
+			values: []interface{}{map[string]interface{}{"a": []interface{}{map[string]interface{}{"b": 1.0}, map[string]interface{}{"c": 2.0}}}},
+		},
+		{
+			name:     "stray close",
+			input:    "}\n{\"a\":1}\n",
+			values:   []interface{}{map[string]interface{}{"a": 1.0}},
+			errLines: []int{1},
+		},
+		{
+			name:     "unterminated object at the end",
+			input:    "{\"a\":1}\n{\"b\":2\n",
+			values:   []interface{}{map[string]interface{}{"a": 1.0}},
+			errLines: []int{2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, errLines := readJsonStream(t, tt.input)
+			if !reflect.DeepEqual(values, tt.values) {
+				t.Errorf("values = %v, want %v", values, tt.values)
+			}
+			if !reflect.DeepEqual(errLines, tt.errLines) {
+				t.Errorf("error lines = %v, want %v", errLines, tt.errLines)
+			}
+		})
+	}
+}
@@ -0,0 +1,413 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/richinsley/comfy2go/client"
+)
+
+// states of a custom node pack
+const (
+	PackEnabled      = "enabled"
+	PackDisabled     = "disabled"
+	PackNotInstalled = "not-installed"
+	PackUpdate       = "update" // installed and enabled, with an update available
+	PackImportFailed = "import-fail"
+)
+
+// legacyActionPaths are the routes of the actions of ComfyUI-Manager versions without an install
+// queue
+var legacyActionPaths = map[string]string{
+	"install":   "/customnode/install",
+	"update":    "/customnode/update",
+	"disable":   "/customnode/toggle_active",
+	"uninstall": "/customnode/uninstall",
+}
+
+// CustomNodePack is a custom node pack as listed by ComfyUI-Manager
+type CustomNodePack struct {
+	ID          string   `json:"id"`
+	Title       string   `json:"title"`
+	Author      string   `json:"author,omitempty"`
+	Reference   string   `json:"reference,omitempty"`
+	Files       []string `json:"files,omitempty"`
+	InstallType string   `json:"install_type,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Version     string   `json:"version,omitempty"`
+	State       string   `json:"state"`
+	// the pack as the manager lists it, which is posted back to act on the pack
+	item map[string]interface{}
+}
+
+// Installed returns true if the pack is installed, enabled or not
+func (p *CustomNodePack) Installed() bool {
+	return p.State != PackNotInstalled
+}
+
+// Repo returns the git url of the pack
+func (p *CustomNodePack) Repo() string {
+	if p.InstallType == "git-clone" && len(p.Files) > 0 {
+		return p.Files[0]
+	}
+	return p.Reference
+}
+
+// ManagerClient talks to the ComfyUI-Manager of a ComfyUI instance
+type ManagerClient struct {
+	Host string
+	Port int
+	http *http.Client
+}
+
+// ManagerResult is the result of an action on a custom node pack of a host
+type ManagerResult struct {
+	Host   string `json:"host"`
+	Action string `json:"action"`
+	Pack   string `json:"pack"`
+	Title  string `json:"title,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// NewManagerClient returns a client of the ComfyUI-Manager of a host
+func NewManagerClient(host string, port int) *ManagerClient {
+	return &ManagerClient{Host: host, Port: port, http: &http.Client{Timeout: 60 * time.Second}}
+}
+
+// Address returns the host and port of the manager
+func (m *ManagerClient) Address() string {
+	return fmt.Sprintf("%s:%d", m.Host, m.Port)
+}
+
+// request sends a request to the manager and decodes the response into v when v is not nil.
+// It returns the status code of the response.
+func (m *ManagerClient) request(method string, path string, body interface{}, v interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		switch b := body.(type) {
+		case string:
+			reader = strings.NewReader(b)
+		default:
+			data, err := json.Marshal(body)
+			if err != nil {
+				return 0, err
+			}
+			reader = bytes.NewReader(data)
+		}
+	}
+	req, err := http.NewRequest(method, fmt.Sprintf("http://%s:%d%s", m.Host, m.Port, path), reader)
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := m.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return resp.StatusCode, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		message := strings.TrimSpace(string(data))
+		if message == "" {
+			message = resp.Status
+		}
+		return resp.StatusCode, fmt.Errorf("%s %s: %s", method, path, message)
+	}
+	if v != nil && len(data) > 0 {
+		return resp.StatusCode, json.Unmarshal(data, v)
+	}
+	return resp.StatusCode, nil
+}
+
+// List returns the custom node packs the manager knows, sorted by title.  mode is where the
+// manager reads its list from (local, cache or remote).
+func (m *ManagerClient) List(mode string) ([]*CustomNodePack, error) {
+	var list struct {
+		NodePacks   map[string]map[string]interface{} `json:"node_packs"`
+		CustomNodes []map[string]interface{}          `json:"custom_nodes"`
+	}
+	status, err := m.request("GET", "/customnode/getlist?skip_update=true&mode="+url.QueryEscape(mode), nil, &list)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return nil, fmt.Errorf("ComfyUI-Manager is not installed on %s", m.Address())
+	}
+
+	packs := make([]*CustomNodePack, 0)
+	for id, item := range list.NodePacks {
+		if _, ok := item["id"]; !ok {
+			item["id"] = id
+		}
+		packs = append(packs, newCustomNodePack(item))
+	}
+	// older managers list the packs with their install state in "installed"
+	for _, item := range list.CustomNodes {
+		packs = append(packs, newCustomNodePack(item))
+	}
+	sort.Slice(packs, func(i, j int) bool {
+		return strings.ToLower(packs[i].Title) < strings.ToLower(packs[j].Title)
+	})
+	return packs, nil
+}
+
+func newCustomNodePack(item map[string]interface{}) *CustomNodePack {
+	str := func(key string) string {
+		if v, ok := item[key]; ok && v != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return ""
+	}
+	p := &CustomNodePack{
+		ID:          str("id"),
+		Title:       str("title"),
+		Author:      str("author"),
+		Reference:   str("reference"),
+		InstallType: str("install_type"),
+		Description: str("description"),
+		Version:     str("version"),
+		State:       str("state"),
+		item:        item,
+	}
+	if files, ok := item["files"].([]interface{}); ok {
+		for _, f := range files {
+			p.Files = append(p.Files, fmt.Sprintf("%v", f))
+		}
+	}
+	if p.ID == "" {
+		p.ID = p.Repo()
+	}
+	if p.Title == "" {
+		p.Title = p.ID
+	}
+
+	switch strings.ToLower(str("installed")) {
+	case "true":
+		p.State = PackEnabled
+	case "false":
+		p.State = PackNotInstalled
+	case "update":
+		p.State = PackUpdate
+	case "disabled":
+		p.State = PackDisabled
+	case "fail":
+		p.State = PackImportFailed
+	}
+	if p.State == "" {
+		p.State = PackNotInstalled
+	}
+	if p.State == PackEnabled && str("update-state") == "true" {
+		p.State = PackUpdate
+	}
+	return p
+}
+
+// FindPack returns the pack with an id, title or git url
+func FindPack(packs []*CustomNodePack, name string) *CustomNodePack {
+	for _, p := range packs {
+		if p.ID == name {
+			return p
+		}
+	}
+	repo := NormalizeRepoURL(name)
+	for _, p := range packs {
+		if strings.EqualFold(p.Title, name) || strings.EqualFold(p.ID, name) {
+			return p
+		}
+		if strings.Contains(name, "/") && (NormalizeRepoURL(p.Repo()) == repo || NormalizeRepoURL(p.Reference) == repo) {
+			return p
+		}
+	}
+	return nil
+}
+
+// Act queues an action (install, update, disable or uninstall) on a pack, or runs it on
+// managers without an install queue.  It returns true if the action was queued, and the queue
+// must be started with Start.
+func (m *ManagerClient) Act(action string, pack *CustomNodePack) (bool, error) {
+	item := make(map[string]interface{}, len(pack.item)+4)
+	for k, v := range pack.item {
+		item[k] = v
+	}
+	item["ui_id"] = pack.ID
+	item["skip_post_install"] = false
+	if _, ok := item["selected_version"]; !ok {
+		item["selected_version"] = "latest"
+	}
+	if _, ok := item["channel"]; !ok {
+		item["channel"] = "default"
+	}
+	if _, ok := item["mode"]; !ok {
+		item["mode"] = "cache"
+	}
+
+	status, err := m.request("POST", "/manager/queue/"+action, item, nil)
+	if err != nil {
+		return true, err
+	}
+	if status != http.StatusNotFound {
+		return true, nil
+	}
+	status, err = m.request("POST", legacyActionPaths[action], item, nil)
+	if err == nil && status == http.StatusNotFound {
+		err = fmt.Errorf("ComfyUI-Manager of %s can't %s custom nodes", m.Address(), action)
+	}
+	return false, err
+}
+
+// InstallGitURL installs a custom node from a git url the manager doesn't list.  The security
+// level of the manager must allow it.
+func (m *ManagerClient) InstallGitURL(gitURL string) error {
+	status, err := m.request("POST", "/customnode/install/git_url", gitURL, nil)
+	if status == http.StatusForbidden {
+		err = fmt.Errorf("the security level of ComfyUI-Manager of %s does not allow installing from git urls", m.Address())
+	} else if err == nil && status == http.StatusNotFound {
+		err = fmt.Errorf("ComfyUI-Manager of %s can't install from git urls", m.Address())
+	}
+	return err
+}
+
+// UpdateAll queues an update of every installed pack, or runs it on managers without an
+// install queue.  It returns true if the update was queued.
+func (m *ManagerClient) UpdateAll() (bool, error) {
+	status, err := m.request("GET", "/manager/queue/update_all?mode=cache", nil, nil)
+	if err != nil {
+		return true, err
+	}
+	if status != http.StatusNotFound {
+		return true, nil
+	}
+	status, err = m.request("GET", "/customnode/update_all?mode=cache", nil, nil)
+	if err == nil && status == http.StatusNotFound {
+		err = fmt.Errorf("ComfyUI-Manager of %s can't update custom nodes", m.Address())
+	}
+	return false, err
+}
+
+// Start starts the install queue and waits until it is done
+func (m *ManagerClient) Start(timeout time.Duration) error {
+	if _, err := m.request("GET", "/manager/queue/start", nil, nil); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		var status struct {
+			Total        int  `json:"total_count"`
+			Done         int  `json:"done_count"`
+			InProgress   int  `json:"in_progress_count"`
+			IsProcessing bool `json:"is_processing"`
+		}
+		if _, err := m.request("GET", "/manager/queue/status", nil, &status); err != nil {
+			return err
+		}
+		if !status.IsProcessing && status.InProgress == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("ComfyUI-Manager of %s is still working after %v", m.Address(), timeout)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// Restart restarts the ComfyUI instance, and waits until it answers again when wait is more
+// than zero
+func (m *ManagerClient) Restart(wait time.Duration) error {
+	status, err := m.request("GET", "/manager/reboot", nil, nil)
+	if err == nil && status == http.StatusNotFound {
+		return fmt.Errorf("ComfyUI-Manager is not installed on %s", m.Address())
+	}
+	// the server may go away before it answers, but a request that could not be sent did not
+	// restart it
+	var opErr *net.OpError
+	if err != nil && (status != 0 || errors.As(err, &opErr) && opErr.Op == "dial") {
+		return err
+	}
+	if wait <= 0 {
+		return nil
+	}
+
+	// give the server time to go down before waiting for it to come up
+	time.Sleep(2 * time.Second)
+	deadline := time.Now().Add(wait)
+	c := client.NewComfyClient(m.Host, m.Port, nil)
+	for {
+		if _, err := c.GetSystemStats(); err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s did not come back after %v", m.Address(), wait)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// MissingNodePacks finds the node types of a workflow a host does not have, and the packs of the
+// manager that provide them.  It returns the packs to install, the node types each pack
+// provides, and the missing node types no pack provides.
+func (m *ManagerClient) MissingNodePacks(workflowpath string, packs []*CustomNodePack) ([]*CustomNodePack, map[string][]string, []string, error) {
+	req, err := GetWorkflowRequirements(workflowpath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	objects, err := client.NewComfyClient(m.Host, m.Port, nil).GetObjectInfos()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	mappings, err := GetNodeMappings(m.Host, m.Port)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if mappings == nil {
+		mappings = NewNodeMappings()
+	}
+	for t, repo := range req.Repos {
+		mappings.Add(t, repo)
+	}
+
+	toInstall := make([]*CustomNodePack, 0)
+	provides := make(map[string][]string)
+	unresolved := make([]string, 0)
+	for _, t := range append(append([]string{}, req.NodeTypes...), req.CoreTypes...) {
+		if _, ok := objects.Objects[t]; ok {
+			continue
+		}
+		var pack *CustomNodePack
+		for _, repo := range mappings.Repos(t) {
+			if pack = FindPack(packs, repo); pack != nil {
+				break
+			}
+		}
+		if pack == nil {
+			if id, ok := req.Packs[t]; ok {
+				pack = FindPack(packs, id)
+			}
+		}
+		if pack == nil {
+			unresolved = append(unresolved, t)
+			continue
+		}
+		if _, ok := provides[pack.ID]; !ok {
+			toInstall = append(toInstall, pack)
+		}
+		provides[pack.ID] = append(provides[pack.ID], t)
+	}
+	return toInstall, provides, unresolved, nil
+}
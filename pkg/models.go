@@ -0,0 +1,271 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/richinsley/comfy2go/client"
+	"github.com/richinsley/comfy2go/graphapi"
+)
+
+// HostModels are the model files of a host by folder
+type HostModels struct {
+	Host    string              `json:"host"`
+	Source  string              `json:"source,omitempty"` // "models" for the models endpoint, "object_info" for the combos of loaders
+	Error   string              `json:"error,omitempty"`
+	Folders map[string][]string `json:"folders"`
+}
+
+// ModelsReport are the models of several hosts, and the models each host is missing
+type ModelsReport struct {
+	Hosts []*HostModels `json:"hosts"`
+	// folder to model to the hosts without the model, when there is more than one host
+	Missing map[string]map[string][]string `json:"missing,omitempty"`
+}
+
+// modelFolderAliases are other names of the model folders
+var modelFolderAliases = map[string]string{
+	"checkpoint":        "checkpoints",
+	"ckpt":              "checkpoints",
+	"lora":              "loras",
+	"vaes":              "vae",
+	"controlnets":       "controlnet",
+	"control_net":       "controlnet",
+	"clip":              "text_encoders",
+	"text_encoder":      "text_encoders",
+	"unet":              "diffusion_models",
+	"diffusion_model":   "diffusion_models",
+	"upscale":           "upscale_models",
+	"upscale_model":     "upscale_models",
+	"embedding":         "embeddings",
+	"hypernetwork":      "hypernetworks",
+	"style_model":       "style_models",
+	"clip_vision_model": "clip_vision",
+	"unclip":            "checkpoints",
+	"t2i-adapter":       "controlnet",
+	"t2i-style":         "style_models",
+	"taesd":             "vae_approx",
+}
+
+// modelInputFolders are the folders of the combo inputs of loaders, for servers without the
+// models endpoint
+var modelInputFolders = map[string]string{
+	"ckpt_name":             "checkpoints",
+	"lora_name":             "loras",
+	"vae_name":              "vae",
+	"control_net_name":      "controlnet",
+	"unet_name":             "diffusion_models",
+	"clip_name":             "text_encoders",
+	"clip_name1":            "text_encoders",
+	"clip_name2":            "text_encoders",
+	"clip_name3":            "text_encoders",
+	"style_model_name":      "style_models",
+	"gligen_name":           "gligen",
+	"hypernetwork_name":     "hypernetworks",
+	"photomaker_model_name": "photomaker",
+}
+
+// modelNodeFolders are the folders of the combo inputs of loaders whose input names are used by
+// other loaders for other folders
+var modelNodeFolders = map[string]map[string]string{
+	"CLIPVisionLoader":   {"clip_name": "clip_vision"},
+	"UpscaleModelLoader": {"model_name": "upscale_models"},
+	"CheckpointLoader":   {"config_name": "configs"},
+}
+
+// ModelFolder returns the folder name of a model type given as a folder or one of its aliases
+func ModelFolder(name string) string {
+	if folder, ok := modelFolderAliases[name]; ok {
+		return folder
+	}
+	return name
+}
+
+// ModelInputFolder returns the model folder of the combo input of a loader node, or an empty
+// string if the input is not known to select a model
+func ModelInputFolder(nodeType string, input string) string {
+	if folder, ok := modelNodeFolders[nodeType][input]; ok {
+		return folder
+	}
+	return modelInputFolders[input]
+}
+
+// GetHostModels returns the models of a host in a folder, or in every folder when folder is
+// empty.  The folders are read from the server's models endpoint, or from the combo inputs of
+// its loaders when the server has no models endpoint.
+func GetHostModels(host string, port int, folder string) *HostModels {
+	models := &HostModels{Host: fmt.Sprintf("%s:%d", host, port), Folders: make(map[string][]string)}
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	get := func(path string, v interface{}) (bool, error) {
+		resp, err := httpClient.Get(fmt.Sprintf("http://%s:%d%s", host, port, path))
+		if err != nil {
+			return false, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		if resp.StatusCode != http.StatusOK {
+			return false, fmt.Errorf("GET %s: %s", path, resp.Status)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return false, err
+		}
+		return true, json.Unmarshal(body, v)
+	}
+
+	var folders []string
+	found, err := get("/models", &folders)
+	if err != nil {
+		models.Error = err.Error()
+		return models
+	}
+	if found {
+		models.Source = "models"
+		for _, f := range folders {
+			// custom node folders are not model folders
+			if f == "custom_nodes" || (folder != "" && f != folder) {
+				continue
+			}
+			var files []string
+			if _, err := get("/models/"+url.PathEscape(f), &files); err != nil {
+				models.Error = err.Error()
+				return models
+			}
+			models.Folders[f] = files
+		}
+		if folder != "" {
+			if _, ok := models.Folders[folder]; !ok {
+				models.Folders[folder] = make([]string, 0)
+			}
+		}
+		return models
+	}
+
+	objects, err := client.NewComfyClient(host, port, nil).GetObjectInfos()
+	if err != nil {
+		models.Error = err.Error()
+		return models
+	}
+	models.Source = "object_info"
+	for f, files := range loaderModels(objects) {
+		if folder == "" || f == folder {
+			models.Folders[f] = files
+		}
+	}
+	if folder != "" {
+		if _, ok := models.Folders[folder]; !ok {
+			models.Folders[folder] = make([]string, 0)
+		}
+	}
+	return models
+}
+
+// loaderModels reads the model folders from the combo inputs of the loader nodes
+func loaderModels(objects *graphapi.NodeObjects) map[string][]string {
+	seen := make(map[string]map[string]bool)
+	for _, o := range objects.Objects {
+		for _, in := range GetNodeSchema(o).Inputs {
+			if in.Type != "COMBO" {
+				continue
+			}
+			folder := ModelInputFolder(o.Name, in.Name)
+			if folder == "" {
+				continue
+			}
+			if seen[folder] == nil {
+				seen[folder] = make(map[string]bool)
+			}
+			for _, c := range in.Choices {
+				seen[folder][c] = true
+			}
+		}
+	}
+
+	folders := make(map[string][]string)
+	for folder, files := range seen {
+		list := make([]string, 0, len(files))
+		for f := range files {
+			list = append(list, f)
+		}
+		sort.Strings(list)
+		folders[folder] = list
+	}
+	return folders
+}
+
+// GetModelsReport reads the models of every host at the same time, and finds the models each
+// host is missing
+func GetModelsReport(options *ComfyOptions, folder string) *ModelsReport {
+	report := &ModelsReport{Hosts: make([]*HostModels, len(options.Host))}
+	done := make(chan struct{})
+	for i := range options.Host {
+		go func(i int) {
+			report.Hosts[i] = GetHostModels(options.Host[i], options.Port[i], folder)
+			done <- struct{}{}
+		}(i)
+	}
+	for range options.Host {
+		<-done
+	}
+
+	if len(report.Hosts) < 2 {
+		return report
+	}
+	report.Missing = make(map[string]map[string][]string)
+	for folder, models := range report.AllModels() {
+		for _, model := range models {
+			for _, h := range report.Hosts {
+				if h.Error != "" || h.Has(folder, model) {
+					continue
+				}
+				if report.Missing[folder] == nil {
+					report.Missing[folder] = make(map[string][]string)
+				}
+				report.Missing[folder][model] = append(report.Missing[folder][model], h.Host)
+			}
+		}
+	}
+	return report
+}
+
+// AllModels returns the models of every host by folder, sorted
+func (r *ModelsReport) AllModels() map[string][]string {
+	seen := make(map[string]map[string]bool)
+	for _, h := range r.Hosts {
+		for folder, files := range h.Folders {
+			if seen[folder] == nil {
+				seen[folder] = make(map[string]bool)
+			}
+			for _, f := range files {
+				seen[folder][f] = true
+			}
+		}
+	}
+	all := make(map[string][]string)
+	for folder, files := range seen {
+		list := make([]string, 0, len(files))
+		for f := range files {
+			list = append(list, f)
+		}
+		sort.Strings(list)
+		all[folder] = list
+	}
+	return all
+}
+
+// Has returns true if the host has a model in a folder
+func (h *HostModels) Has(folder string, model string) bool {
+	for _, f := range h.Folders[folder] {
+		if f == model {
+			return true
+		}
+	}
+	return false
+}
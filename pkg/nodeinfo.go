@@ -0,0 +1,172 @@
+package pkg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/richinsley/comfy2go/graphapi"
+)
+
+// NodeInputSchema is an input of a node class
+type NodeInputSchema struct {
+	Name     string                 `json:"name"`
+	Type     string                 `json:"type"`
+	Required bool                   `json:"required"`
+	Choices  []string               `json:"choices,omitempty"` // the values of a COMBO input
+	Options  map[string]interface{} `json:"options,omitempty"` // default, min, max, step and the like
+}
+
+// NodeOutputSchema is an output of a node class
+type NodeOutputSchema struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	IsList bool   `json:"is_list"`
+}
+
+// NodeSchema describes the inputs and outputs of a node class
+type NodeSchema struct {
+	Name        string             `json:"name"`
+	DisplayName string             `json:"display_name"`
+	Category    string             `json:"category"`
+	Description string             `json:"description,omitempty"`
+	OutputNode  bool               `json:"output_node"`
+	Inputs      []NodeInputSchema  `json:"inputs"`
+	Outputs     []NodeOutputSchema `json:"outputs"`
+}
+
+// FindNodeObject returns the node class with a name, or with a display name when no class has
+// the name
+func FindNodeObject(objects *graphapi.NodeObjects, name string) *graphapi.NodeObject {
+	if o, ok := objects.Objects[name]; ok {
+		return o
+	}
+	for _, o := range objects.Objects {
+		if strings.EqualFold(o.DisplayName, name) {
+			return o
+		}
+	}
+	return nil
+}
+
+// FilterNodeObjects returns the node classes, sorted by name, whose name, display name, category
+// or description contains search and whose category starts with category.  Both are case
+// insensitive and match every class when empty.
+func FilterNodeObjects(objects *graphapi.NodeObjects, search string, category string) []*graphapi.NodeObject {
+	search = strings.ToLower(search)
+	category = strings.ToLower(category)
+	nodes := make([]*graphapi.NodeObject, 0)
+	for _, o := range objects.Objects {
+		if category != "" && !strings.HasPrefix(strings.ToLower(o.Category), category) {
+			continue
+		}
+		if search != "" {
+			text := strings.ToLower(strings.Join([]string{o.Name, o.DisplayName, o.Category, o.Description}, "\n"))
+			if !strings.Contains(text, search) {
+				continue
+			}
+		}
+		nodes = append(nodes, o)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return nodes
+}
+
+// GetNodeSchema returns the inputs and outputs of a node class, in the order ComfyUI lists them
+func GetNodeSchema(object *graphapi.NodeObject) *NodeSchema {
+	schema := &NodeSchema{
+		Name:        object.Name,
+		DisplayName: object.DisplayName,
+		Category:    object.Category,
+		Description: object.Description,
+		OutputNode:  object.OutputNode,
+		Inputs:      make([]NodeInputSchema, 0),
+		Outputs:     make([]NodeOutputSchema, 0),
+	}
+
+	if object.Input != nil {
+		for _, name := range object.Input.OrderedRequired {
+			schema.Inputs = append(schema.Inputs, inputSchema(name, object.Input.Required[name], true))
+		}
+		for _, name := range object.Input.OrderedOptional {
+			schema.Inputs = append(schema.Inputs, inputSchema(name, object.Input.Optional[name], false))
+		}
+	}
+
+	if object.Output != nil {
+		var names []interface{}
+		if object.OutputName != nil {
+			names, _ = (*object.OutputName).([]interface{})
+		}
+		for i, t := range *object.Output {
+			output := NodeOutputSchema{Type: slotType(t)}
+			if i < len(names) {
+				output.Name = fmt.Sprintf("%v", names[i])
+			}
+			if output.Name == "" {
+				output.Name = output.Type
+			}
+			if object.OutputIsList != nil && i < len(*object.OutputIsList) {
+				output.IsList = (*object.OutputIsList)[i]
+			}
+			schema.Outputs = append(schema.Outputs, output)
+		}
+	}
+	return schema
+}
+
+// inputSchema reads an input spec, [type, options] where the type of a combo is its list of
+// values, or "COMBO" with the values in the "options" option
+func inputSchema(name string, input *interface{}, required bool) NodeInputSchema {
+	schema := NodeInputSchema{Name: name, Type: "*", Required: required}
+	if input == nil {
+		return schema
+	}
+	spec, ok := (*input).([]interface{})
+	if !ok || len(spec) == 0 {
+		return schema
+	}
+
+	var choices []interface{}
+	schema.Type = slotType(spec[0])
+	if values, ok := spec[0].([]interface{}); ok {
+		choices = values
+	}
+	if len(spec) > 1 {
+		if options, ok := spec[1].(map[string]interface{}); ok && len(options) > 0 {
+			schema.Options = make(map[string]interface{}, len(options))
+			for k, v := range options {
+				if values, ok := v.([]interface{}); ok && k == "options" && schema.Type == "COMBO" {
+					choices = values
+					continue
+				}
+				schema.Options[k] = v
+			}
+		}
+	}
+	for _, c := range choices {
+		schema.Choices = append(schema.Choices, fmt.Sprintf("%v", c))
+	}
+	return schema
+}
+
+// ComboChoices returns the values of the COMBO inputs with a name in the node classes, without
+// duplicates, in the order they are found
+func ComboChoices(objects []*graphapi.NodeObject, input string) []string {
+	seen := make(map[string]bool)
+	choices := make([]string, 0)
+	for _, o := range objects {
+		for _, in := range GetNodeSchema(o).Inputs {
+			if in.Name != input || in.Type != "COMBO" {
+				continue
+			}
+			for _, c := range in.Choices {
+				if !seen[c] {
+					seen[c] = true
+					choices = append(choices, c)
+				}
+			}
+		}
+	}
+	return choices
+}
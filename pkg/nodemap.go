@@ -0,0 +1,141 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// NodeMappings maps node types to the custom node repos that provide them.  It is read from
+// the extension-node-map.json format of ComfyUI-Manager:
+//
+//	{
+//		"https://github.com/author/repo": [
+//			["NodeTypeA", "NodeTypeB"],
+//			{"title_aux": "Repo", "nodename_pattern": "^Prefix"}
+//		]
+//	}
+type NodeMappings struct {
+	types    map[string][]string
+	patterns []nodePattern
+}
+
+// nodePattern matches the node types of a repo that provides more nodes than it lists
+type nodePattern struct {
+	repo    string
+	pattern *regexp.Regexp
+}
+
+// NewNodeMappings returns empty node mappings
+func NewNodeMappings() *NodeMappings {
+	return &NodeMappings{types: make(map[string][]string)}
+}
+
+// ParseNodeMappings reads node mappings in the extension-node-map.json format
+func ParseNodeMappings(data []byte) (*NodeMappings, error) {
+	var raw map[string][]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	m := NewNodeMappings()
+	for repo, entry := range raw {
+		if len(entry) == 0 {
+			continue
+		}
+		var types []string
+		if err := json.Unmarshal(entry[0], &types); err != nil {
+			return nil, fmt.Errorf("node types of %s: %v", repo, err)
+		}
+		for _, t := range types {
+			m.Add(t, repo)
+		}
+		if len(entry) > 1 {
+			var meta struct {
+				Pattern string `json:"nodename_pattern"`
+			}
+			if json.Unmarshal(entry[1], &meta) == nil && meta.Pattern != "" {
+				if re, err := regexp.Compile(meta.Pattern); err == nil {
+					m.patterns = append(m.patterns, nodePattern{repo: repo, pattern: re})
+				}
+			}
+		}
+	}
+	return m, nil
+}
+
+// GetNodeMappings reads the node mappings of the ComfyUI-Manager installed on a host.  It
+// returns nil mappings and no error when the host has no ComfyUI-Manager.
+func GetNodeMappings(host string, port int) (*NodeMappings, error) {
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	// newer versions of the manager serve their routes under /v2
+	for _, path := range []string{"/customnode/getmappings", "/v2/customnode/getmappings"} {
+		resp, err := httpClient.Get(fmt.Sprintf("http://%s:%d%s?mode=local", host, port, path))
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("GET %s: %s", path, resp.Status)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return ParseNodeMappings(body)
+	}
+	return nil, nil
+}
+
+// Add records that a repo provides a node type
+func (m *NodeMappings) Add(nodeType string, repo string) {
+	if !containsString(m.types[nodeType], repo) {
+		m.types[nodeType] = append(m.types[nodeType], repo)
+	}
+}
+
+// Merge adds the node types and patterns of other mappings
+func (m *NodeMappings) Merge(other *NodeMappings) {
+	if other == nil {
+		return
+	}
+	for t, repos := range other.types {
+		for _, repo := range repos {
+			m.Add(t, repo)
+		}
+	}
+	m.patterns = append(m.patterns, other.patterns...)
+}
+
+// Repos returns the repos that provide a node type, sorted.  Repos that list the node type are
+// preferred over repos whose name pattern matches it.
+func (m *NodeMappings) Repos(nodeType string) []string {
+	repos := append([]string{}, m.types[nodeType]...)
+	if len(repos) == 0 {
+		for _, p := range m.patterns {
+			if p.pattern.MatchString(nodeType) && !containsString(repos, p.repo) {
+				repos = append(repos, p.repo)
+			}
+		}
+	}
+	sort.Strings(repos)
+	return repos
+}
+
+// NormalizeRepoURL returns a git url in a form that can be compared with other urls of the
+// same repo
+func NormalizeRepoURL(url string) string {
+	url = strings.ToLower(strings.TrimSpace(url))
+	url = strings.TrimSuffix(url, "/")
+	url = strings.TrimSuffix(url, ".git")
+	url = strings.TrimPrefix(url, "http://")
+	url = strings.TrimPrefix(url, "https://")
+	return url
+}
@@ -2,6 +2,7 @@ package pkg
 
 import (
 	"bufio"
+	"fmt"
 	"os"
 	"sync"
 
@@ -10,14 +11,16 @@ import (
 )
 
 type ComfyOptions struct {
-	Host           []string
-	Port           []int
-	Json           bool
-	PrettyJson     bool
-	API            string
-	APIValues      string
-	GraphOutPath   string
-	InlineImages   bool
+	Host         []string
+	Port         []int
+	Json         bool
+	PrettyJson   bool
+	API          string
+	APIValues    string
+	GraphOutPath string
+	InlineImages bool
+	// protocol used to output images to the terminal (auto, iterm, sixel, kitty, ansi)
+	ImageProtocol  string
 	NoSaveData     bool
 	DataToStdout   bool
 	HomePath       string
@@ -27,14 +30,39 @@ type ComfyOptions struct {
 	GetVersion     bool
 	OutputNodes    string
 	NoSharedModels bool
+	// render sampling previews in the terminal with an image protocol (auto, iterm, sixel, kitty, ansi)
+	Preview string
+	// folder to write sampling preview frames to
+	PreviewDir string
 	// path to a file to read from stdin
 	StdinFile string
+	// format of the stream written with DataToStdout (raw, png, mjpeg, y4m)
+	StdoutFormat string
+	// CSV or TSV file with a job on each row
+	BatchFile string
+	// folder or glob pattern of input files, one job per file
+	BatchDir string
+	// state of a resumable run
+	Resume *ResumeState
+	// format of the per node profile of each prompt (table, json, trace)
+	Profile string
+	// path to write the profiles to, stderr when empty
+	ProfileFile string
 	// API sub command options
 	APIValuesOnly    bool // only output the values of the API nodes
 	Stdin            *bufio.Reader
 	Clients          []*client.ComfyClient
-	JsonScanner      *bufio.Scanner
-	JsonScannerMutex *sync.Mutex
+	JsonStream       *JsonStream
+	JsonStreamMutex  *sync.Mutex
+	PreviewSockets   []*PreviewSocket
+	Frames           *FrameReader
+	sources          map[string]ParameterSource
+	replay           *inputReplay   // inputs replayed for every host, nil unless set by ClientsWithWorkflows
+	workItemInput    *WorkItemInput // input of the work item being applied in a resumable run
+	frameWriter      *FrameWriter
+	frameWriterMutex sync.Mutex
+	profiles         []*PromptProfile
+	profilesMutex    sync.Mutex
 }
 
 func (o *ComfyOptions) ApplyEnvironment() {
@@ -58,6 +86,15 @@ func (o *ComfyOptions) ApplyEnvironment() {
 	if viper.IsSet("inlineimages") {
 		o.InlineImages = viper.GetBool("inlineimages")
 	}
+	if viper.IsSet("imageprotocol") {
+		o.ImageProtocol = viper.GetString("imageprotocol")
+	}
+	if viper.IsSet("preview") {
+		o.Preview = viper.GetString("preview")
+	}
+	if viper.IsSet("previewdir") {
+		o.PreviewDir = viper.GetString("previewdir")
+	}
 	if viper.IsSet("nosavedata") {
 		o.NoSaveData = viper.GetBool("nosavedata")
 	}
@@ -86,3 +123,40 @@ func (o *ComfyOptions) GetStdinReader() *bufio.Reader {
 	o.Stdin = bufio.NewReader(os.Stdin)
 	return o.Stdin
 }
+
+// GetFrameReader returns the reader for image frames streamed through stdin
+func (o *ComfyOptions) GetFrameReader() *FrameReader {
+	if o.Frames == nil {
+		o.Frames = NewFrameReader(o.GetStdinReader())
+	}
+	return o.Frames
+}
+
+// GetFrameWriter returns the writer for output data written to stdout
+func (o *ComfyOptions) GetFrameWriter() (*FrameWriter, error) {
+	o.frameWriterMutex.Lock()
+	defer o.frameWriterMutex.Unlock()
+	if o.frameWriter == nil {
+		rate := ""
+		if o.Frames != nil {
+			// keep the frame rate of a y4m input stream
+			rate = o.Frames.Rate()
+		}
+		w, err := NewFrameWriter(os.Stdout, o.StdoutFormat, rate)
+		if err != nil {
+			return nil, err
+		}
+		o.frameWriter = w
+	}
+	return o.frameWriter, nil
+}
+
+// ClientAddress returns the host and port of a client
+func (o *ComfyOptions) ClientAddress(c *client.ComfyClient) (string, int, error) {
+	for i, oc := range o.Clients {
+		if oc == c {
+			return o.Host[i], o.Port[i], nil
+		}
+	}
+	return "", 0, fmt.Errorf("unknown client %s", c.ClientID())
+}
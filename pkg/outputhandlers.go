@@ -0,0 +1,332 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/richinsley/comfy2go/client"
+)
+
+// NodeOutput is the output of a single kind from a node.  The kind is the key in the node's
+// output map, e.g. "images", "gifs", "audio" or "text"
+type NodeOutput struct {
+	PromptID string
+	NodeID   int
+	Kind     string
+	Items    []client.DataOutput
+	// Raw holds the output as ComfyUI sent it when it could not be parsed into Items, such as
+	// JSON objects without a file reference or values that are not lists
+	Raw json.RawMessage
+}
+
+// OutputHandler processes a node output
+type OutputHandler func(c *client.ComfyClient, options *ComfyOptions, output *NodeOutput) error
+
+var (
+	outputHandlers = map[string]OutputHandler{
+		"images":  FileOutputHandler,
+		"gifs":    FileOutputHandler,
+		"video":   FileOutputHandler,
+		"videos":  FileOutputHandler,
+		"audio":   FileOutputHandler,
+		"audios":  FileOutputHandler,
+		"latents": FileOutputHandler,
+		"mesh":    FileOutputHandler,
+		"meshes":  FileOutputHandler,
+		"text":    TextOutputHandler,
+		"string":  TextOutputHandler,
+		"json":    JsonOutputHandler,
+	}
+	outputHandlersMutex sync.RWMutex
+)
+
+// RegisterOutputHandler sets the handler for an output kind, replacing any existing handler
+func RegisterOutputHandler(kind string, handler OutputHandler) {
+	outputHandlersMutex.Lock()
+	defer outputHandlersMutex.Unlock()
+	outputHandlers[kind] = handler
+}
+
+// GetOutputHandler returns the handler for an output kind, or UnknownOutputHandler when no
+// handler is registered for the kind
+func GetOutputHandler(kind string) OutputHandler {
+	outputHandlersMutex.RLock()
+	defer outputHandlersMutex.RUnlock()
+	if h, ok := outputHandlers[kind]; ok {
+		return h
+	}
+	return UnknownOutputHandler
+}
+
+// HandleNodeOutput dispatches a node output to the handler registered for its kind
+func HandleNodeOutput(c *client.ComfyClient, options *ComfyOptions, output *NodeOutput) {
+	if err := GetOutputHandler(output.Kind)(c, options, output); err != nil {
+		slog.Error(fmt.Sprintf("Failed to handle %s output of node %d", output.Kind, output.NodeID), "error", err)
+		os.Exit(1)
+	}
+}
+
+// FileOutputHandler downloads the files a node output references, saving them to disk and/or
+// writing them to stdout.  Images are output to the terminal when inline images are enabled
+func FileOutputHandler(c *client.ComfyClient, options *ComfyOptions, output *NodeOutput) error {
+	if len(output.Raw) > 0 {
+		// the output could not be parsed as file references
+		return UnknownOutputHandler(c, options, output)
+	}
+
+	for _, item := range output.Items {
+		if item.Filename == "" {
+			// raw text mixed in with the file references
+			fmt.Fprintln(textOutput(options), item.Text)
+			continue
+		}
+
+		data, err := c.GetImage(item)
+		if err != nil {
+			return fmt.Errorf("failed to get %s: %w", item.Filename, err)
+		}
+
+		// what to do with the data
+		if options.InlineImages {
+			if _, _, err := image.DecodeConfig(bytes.NewReader(*data)); err == nil {
+				// print the image to the terminal
+				if err := OutputImageToStd(data, item.Filename, options.ImageProtocol); err != nil {
+					slog.Warn("Failed to output image to terminal", "error", err)
+				}
+			}
+		}
+
+		if !options.NoSaveData {
+			if err := saveOutput(options, output.PromptID, data, item.Filename); err != nil {
+				return err
+			}
+		}
+
+		if options.DataToStdout {
+			w, err := options.GetFrameWriter()
+			if err != nil {
+				return err
+			}
+			if err := w.WriteFrame(*data); err != nil {
+				return fmt.Errorf("failed to write data to stdout: %w", err)
+			}
+			os.Stdout.Sync()
+		}
+		slog.Debug(fmt.Sprintf("Got data file: %s", item.Filename))
+	}
+	return nil
+}
+
+// TextOutputHandler prints text outputs
+func TextOutputHandler(c *client.ComfyClient, options *ComfyOptions, output *NodeOutput) error {
+	if len(output.Raw) > 0 {
+		return JsonOutputHandler(c, options, output)
+	}
+	for _, item := range output.Items {
+		fmt.Fprintln(textOutput(options), item.Text)
+	}
+	return nil
+}
+
+// textOutput returns where text and JSON outputs are printed.  They go to stderr when stdout
+// carries a stream of frames, so they don't corrupt it.
+func textOutput(options *ComfyOptions) io.Writer {
+	if options.DataToStdout && options.StdoutFormat != "" && options.StdoutFormat != FrameFormatRaw {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// JsonOutputHandler saves a JSON blob output to "<prompt id>_<node id>_<kind>.json" and/or
+// writes it to stdout, or to stderr when stdout carries a stream of frames
+func JsonOutputHandler(c *client.ComfyClient, options *ComfyOptions, output *NodeOutput) error {
+	data := []byte(output.Raw)
+	if len(data) == 0 {
+		// comfy2go parsed the values as text
+		values := make([]string, 0, len(output.Items))
+		for _, item := range output.Items {
+			values = append(values, item.Text)
+		}
+		var err error
+		if data, err = json.Marshal(values); err != nil {
+			return err
+		}
+	}
+
+	if !options.NoSaveData {
+		if err := saveOutput(options, output.PromptID, &data, outputMetadataFilename(output)); err != nil {
+			return err
+		}
+	}
+
+	if options.DataToStdout {
+		w := textOutput(options)
+		if _, err := w.Write(append(data, '\n')); err != nil {
+			return fmt.Errorf("failed to write data to stdout: %w", err)
+		}
+		if f, ok := w.(*os.File); ok {
+			f.Sync()
+		}
+	}
+	return nil
+}
+
+// UnknownOutputHandler handles output kinds without a registered handler.  The output's
+// metadata is saved to "<prompt id>_<node id>_<kind>.json" and any files it references are
+// downloaded
+func UnknownOutputHandler(c *client.ComfyClient, options *ComfyOptions, output *NodeOutput) error {
+	refs := output.Items
+	metadata := []byte(output.Raw)
+	if len(metadata) > 0 {
+		// pick out anything that looks like a file reference
+		var items []map[string]interface{}
+		if json.Unmarshal(metadata, &items) == nil {
+			refs = nil
+			for _, item := range items {
+				filename, _ := item["filename"].(string)
+				if filename == "" {
+					continue
+				}
+				ref := client.DataOutput{Filename: filename, Type: "output"}
+				if subfolder, ok := item["subfolder"].(string); ok {
+					ref.Subfolder = subfolder
+				}
+				if t, ok := item["type"].(string); ok {
+					ref.Type = t
+				}
+				refs = append(refs, ref)
+			}
+		}
+	} else {
+		type itemMetadata struct {
+			client.DataOutput
+			Text string `json:"text,omitempty"`
+		}
+		items := make([]itemMetadata, 0, len(output.Items))
+		for _, item := range output.Items {
+			items = append(items, itemMetadata{DataOutput: item, Text: item.Text})
+		}
+		var err error
+		if metadata, err = json.MarshalIndent(items, "", "  "); err != nil {
+			return err
+		}
+	}
+
+	slog.Debug(fmt.Sprintf("No output handler for %s, saving metadata", output.Kind))
+	if !options.NoSaveData {
+		if err := saveOutput(options, output.PromptID, &metadata, outputMetadataFilename(output)); err != nil {
+			return err
+		}
+	}
+
+	var files []client.DataOutput
+	for _, ref := range refs {
+		if ref.Filename != "" {
+			files = append(files, ref)
+		}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	return FileOutputHandler(c, options, &NodeOutput{
+		PromptID: output.PromptID,
+		NodeID:   output.NodeID,
+		Kind:     output.Kind,
+		Items:    files,
+	})
+}
+
+// saveOutput saves output data to path, recording it for a resumable run
+func saveOutput(options *ComfyOptions, promptID string, data *[]byte, path string) error {
+	if err := SaveData(data, path); err != nil {
+		return err
+	}
+	if options.Resume != nil {
+		options.Resume.AddOutput(promptID, path)
+	}
+	return nil
+}
+
+func outputMetadataFilename(output *NodeOutput) string {
+	return filepath.Base(fmt.Sprintf("%s_%d_%s.json", output.PromptID, output.NodeID, output.Kind))
+}
+
+// HandleDataOutput dispatches each kind of a node's output to its handler
+func HandleDataOutput(c *client.ComfyClient, options *ComfyOptions, promptID string, data *client.PromptMessageData) {
+	for kind, items := range data.Data {
+		HandleNodeOutput(c, options, &NodeOutput{
+			PromptID: promptID,
+			NodeID:   data.NodeID,
+			Kind:     kind,
+			Items:    items,
+		})
+	}
+}
+
+// UnparsedOutputs fetches the history of a finished prompt and returns the outputs that
+// comfy2go could not parse, and were therefore never delivered as data messages
+func UnparsedOutputs(host string, port int, promptID string) ([]*NodeOutput, error) {
+	resp, err := http.Get(fmt.Sprintf("http://%s:%d/history/%s", host, port, url.PathEscape(promptID)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var history map[string]struct {
+		Outputs map[string]map[string]json.RawMessage `json:"outputs"`
+	}
+	if err := json.Unmarshal(body, &history); err != nil {
+		return nil, err
+	}
+
+	var retv []*NodeOutput
+	for nodeid, kinds := range history[promptID].Outputs {
+		var id int
+		if _, err := fmt.Sscanf(nodeid, "%d", &id); err != nil {
+			continue
+		}
+		for kind, raw := range kinds {
+			var items []json.RawMessage
+			if json.Unmarshal(raw, &items) != nil {
+				// not a list, comfy2go drops it entirely
+				retv = append(retv, &NodeOutput{PromptID: promptID, NodeID: id, Kind: kind, Raw: raw})
+				continue
+			}
+
+			// comfy2go keeps strings and objects with a filename and type
+			var unparsed []json.RawMessage
+			for _, item := range items {
+				var s string
+				if json.Unmarshal(item, &s) == nil {
+					continue
+				}
+				var ref struct {
+					Filename *string `json:"filename"`
+					Type     *string `json:"type"`
+				}
+				if json.Unmarshal(item, &ref) == nil && ref.Filename != nil && ref.Type != nil {
+					continue
+				}
+				unparsed = append(unparsed, item)
+			}
+			if len(unparsed) > 0 {
+				raw, _ = json.Marshal(unparsed)
+				retv = append(retv, &NodeOutput{PromptID: promptID, NodeID: id, Kind: kind, Raw: raw})
+			}
+		}
+	}
+	return retv, nil
+}
@@ -0,0 +1,297 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/richinsley/comfy2go/graphapi"
+)
+
+// CLIParameter is a parameter given on the command line, addressing a Simple API value or the
+// property of one or more nodes:
+//
+//	seed=5                 the "seed" value of the Simple API
+//	KSampler:seed=5        the first node with the title "KSampler"
+//	(3)seed=5              the node with id 3
+//	@KSampler:seed=5       all nodes of type "KSampler"
+//	*Sampler:seed=5        all nodes with the title "Sampler"
+//	[Group]/Sampler:seed=5 node selectors limited to the nodes in a group
+//	seed:int=5             a typed literal, checked before it is set (int, float, bool, string or json)
+//	prompt=@file:prompt.txt  the contents of a file
+//
+// The address ends at the first "=", and the property name follows the last ":" of the address
+// that is not a type, so titles can contain ":".  A property named like a type is set with its
+// type, as in "Primitive:int:int=5".  Use "\=" for an "=" and "\:" for a ":" ending a title.
+type CLIParameter struct {
+	NodeID    int    // -1 for unset
+	NodeTitle string // empty for unset
+	NodeType  string // empty for unset
+	AllNodes  bool   // is true if the parameter sets every node with NodeTitle
+	Group     string // limits the nodes to those in the group, empty for unset
+	API       bool   // is true if the parameter is an API parameter
+	Name      string // the name of the parameter
+	Type      string // the type of a typed literal, empty for untyped
+	Value     string // the value of the parameter
+}
+
+// types of typed literals
+var literalTypes = map[string]bool{"int": true, "float": true, "bool": true, "string": true, "json": true}
+
+// ParseParameters parses "address=value" parameters, logging and dropping invalid parameters
+func ParseParameters(params []string) []CLIParameter {
+	var parsedParams []CLIParameter
+	for _, param := range params {
+		p, err := ParseParameter(param)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Invalid parameter %s", param), "error", err)
+			continue
+		}
+		parsedParams = append(parsedParams, p)
+	}
+	return parsedParams
+}
+
+// ParseParameter parses a single "address=value" parameter
+func ParseParameter(param string) (CLIParameter, error) {
+	p := CLIParameter{NodeID: -1}
+
+	split := indexUnescaped(param, '=')
+	if split == -1 {
+		return p, fmt.Errorf("expected address=value")
+	}
+	address := param[:split]
+	p.Value = param[split+1:]
+
+	// [Group]/
+	if strings.HasPrefix(address, "[") {
+		end := strings.Index(address, "]/")
+		if end == -1 {
+			return p, fmt.Errorf("expected [group]/ before the node")
+		}
+		p.Group = address[1:end]
+		address = address[end+2:]
+	}
+
+	// split the address at the unescaped colons.  The name follows the last colon, and a typed
+	// literal ends with ":type"
+	var colons []int
+	for i := 0; i < len(address); i++ {
+		if address[i] == '\\' {
+			i++
+		} else if address[i] == ':' {
+			colons = append(colons, i)
+		}
+	}
+	if n := len(colons); n > 0 && literalTypes[address[colons[n-1]+1:]] {
+		p.Type = address[colons[n-1]+1:]
+		address = address[:colons[n-1]]
+		colons = colons[:n-1]
+	}
+
+	node := ""
+	if n := len(colons); n > 0 {
+		node = unescapeParameter(address[:colons[n-1]])
+		p.Name = unescapeParameter(address[colons[n-1]+1:])
+	} else if strings.HasPrefix(address, "(") && strings.Contains(address, ")") {
+		// (id)name
+		end := strings.Index(address, ")")
+		node = address[:end+1]
+		p.Name = address[end+1:]
+	} else {
+		p.Name = unescapeParameter(address)
+	}
+	if p.Name == "" {
+		return p, fmt.Errorf("missing parameter name")
+	}
+
+	if node == "" {
+		if p.Group != "" {
+			return p, fmt.Errorf("a group needs a node selector")
+		}
+		p.API = true
+		return p, nil
+	}
+	return p, p.setNode(node)
+}
+
+// ParseNodeSelector parses a node selector without a property, such as "(3)", "12", "@KSampler",
+// "*Sampler" or "[Group]/Sampler".  A bare number is a node id.
+func ParseNodeSelector(selector string) (CLIParameter, error) {
+	p := CLIParameter{NodeID: -1}
+	if strings.HasPrefix(selector, "[") {
+		end := strings.Index(selector, "]/")
+		if end == -1 {
+			return p, fmt.Errorf("expected [group]/ before the node")
+		}
+		p.Group = selector[1:end]
+		selector = selector[end+2:]
+	}
+	if selector == "" {
+		return p, fmt.Errorf("missing node selector")
+	}
+	if id, err := strconv.Atoi(selector); err == nil {
+		p.NodeID = id
+		return p, nil
+	}
+	return p, p.setNode(selector)
+}
+
+// setNode sets the node selector of the parameter
+func (p *CLIParameter) setNode(node string) error {
+	switch {
+	case strings.HasPrefix(node, "(") && strings.HasSuffix(node, ")"):
+		id, err := strconv.Atoi(node[1 : len(node)-1])
+		if err != nil {
+			return fmt.Errorf("invalid node id %s", node)
+		}
+		p.NodeID = id
+	case strings.HasPrefix(node, "@") && len(node) > 1:
+		p.NodeType = node[1:]
+	case strings.HasPrefix(node, "*") && len(node) > 1:
+		p.AllNodes = true
+		p.NodeTitle = node[1:]
+	default:
+		p.NodeTitle = node
+	}
+	return nil
+}
+
+// String returns the address of the parameter
+func (p CLIParameter) String() string {
+	var b strings.Builder
+	if p.Group != "" {
+		fmt.Fprintf(&b, "[%s]/", p.Group)
+	}
+	switch {
+	case p.API:
+	case p.NodeID != -1:
+		fmt.Fprintf(&b, "(%d)", p.NodeID)
+	case p.NodeType != "":
+		fmt.Fprintf(&b, "@%s", p.NodeType)
+	case p.AllNodes:
+		fmt.Fprintf(&b, "*%s", p.NodeTitle)
+	default:
+		b.WriteString(p.NodeTitle)
+	}
+	if p.Name == "" {
+		// a node selector
+		return b.String()
+	}
+	if !p.API && p.NodeID == -1 {
+		b.WriteString(":")
+	}
+	b.WriteString(p.Name)
+	if p.Type != "" {
+		b.WriteString(":" + p.Type)
+	}
+	return b.String()
+}
+
+// Nodes returns the nodes of the graph a node parameter addresses
+func (p CLIParameter) Nodes(graph *graphapi.Graph) ([]*graphapi.GraphNode, error) {
+	candidates := graph.Nodes
+	if p.Group != "" {
+		group := graph.GetGroupWithTitle(p.Group)
+		if group == nil {
+			return nil, fmt.Errorf("group %s not found in graph", p.Group)
+		}
+		candidates = graph.GetNodesInGroup(group)
+	}
+
+	var nodes []*graphapi.GraphNode
+	for _, n := range candidates {
+		var match bool
+		switch {
+		case p.NodeID != -1:
+			match = n.ID == p.NodeID
+		case p.NodeType != "":
+			match = n.Type == p.NodeType
+		default:
+			// nodes without a title match their display name
+			match = n.Title == p.NodeTitle || (n.Title == "" && n.DisplayName == p.NodeTitle)
+		}
+		if match {
+			nodes = append(nodes, n)
+		}
+	}
+
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no node matches %s", p)
+	}
+	if p.NodeID == -1 && p.NodeType == "" && !p.AllNodes {
+		// a plain title selects the first node with the title
+		nodes = nodes[:1]
+	}
+	return nodes, nil
+}
+
+// ResolveValue returns the value to set for the parameter.  "@file:path" values are replaced by
+// the contents of the file, except for file uploads which upload the file, and typed literals
+// are checked.  Parameter sources are returned as they are, to be read when the value is set.
+func (p CLIParameter) ResolveValue(upload bool) (string, error) {
+	value := p.Value
+	if p.Type == "string" || IsSourceSpec(value) {
+		return value, nil
+	}
+
+	if path, ok := strings.CutPrefix(value, "@file:"); ok {
+		if upload {
+			return path, nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("parameter %s: %w", p, err)
+		}
+		// editors end text files with a new line
+		value = strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r")
+	}
+
+	var err error
+	switch p.Type {
+	case "int":
+		_, err = strconv.ParseInt(value, 10, 64)
+	case "float":
+		_, err = strconv.ParseFloat(value, 64)
+	case "bool":
+		_, err = strconv.ParseBool(value)
+	case "json":
+		if !json.Valid([]byte(value)) {
+			err = errors.New("invalid json")
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("parameter %s: %q is not a valid %s", p, value, p.Type)
+	}
+	return value, nil
+}
+
+// indexUnescaped returns the index of the first c not escaped with a backslash, or -1
+func indexUnescaped(s string, c byte) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+		} else if s[i] == c {
+			return i
+		}
+	}
+	return -1
+}
+
+func unescapeParameter(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,165 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseParameter(t *testing.T) {
+	tests := []struct {
+		param string
+		want  CLIParameter
+	}{
+		{"seed=5", CLIParameter{NodeID: -1, API: true, Name: "seed", Value: "5"}},
+		{"KSampler:seed=5", CLIParameter{NodeID: -1, NodeTitle: "KSampler", Name: "seed", Value: "5"}},
+		{"(3)seed=5", CLIParameter{NodeID: 3, Name: "seed", Value: "5"}},
+		{"@KSampler:seed=5", CLIParameter{NodeID: -1, NodeType: "KSampler", Name: "seed", Value: "5"}},
+		{"*Sampler:seed=5", CLIParameter{NodeID: -1, NodeTitle: "Sampler", AllNodes: true, Name: "seed", Value: "5"}},
+		{"[Upscale]/Sampler:seed=5", CLIParameter{NodeID: -1, Group: "Upscale", NodeTitle: "Sampler", Name: "seed", Value: "5"}},
+		{"[Upscale]/@KSampler:seed=5", CLIParameter{NodeID: -1, Group: "Upscale", NodeType: "KSampler", Name: "seed", Value: "5"}},
+		{"[Upscale]/*Sampler:seed=5", CLIParameter{NodeID: -1, Group: "Upscale", NodeTitle: "Sampler", AllNodes: true, Name: "seed", Value: "5"}},
+		{"[Upscale]/(3)seed=5", CLIParameter{NodeID: 3, Group: "Upscale", Name: "seed", Value: "5"}},
+		{"prompt=@file:prompt.txt", CLIParameter{NodeID: -1, API: true, Name: "prompt", Value: "@file:prompt.txt"}},
+
+		// typed literals
+		{"seed:int=5", CLIParameter{NodeID: -1, API: true, Name: "seed", Type: "int", Value: "5"}},
+		{"cfg:float=7.5", CLIParameter{NodeID: -1, API: true, Name: "cfg", Type: "float", Value: "7.5"}},
+		{"extra:json={\"a\":1}", CLIParameter{NodeID: -1, API: true, Name: "extra", Type: "json", Value: "{\"a\":1}"}},
+		{"KSampler:seed:int=5", CLIParameter{NodeID: -1, NodeTitle: "KSampler", Name: "seed", Type: "int", Value: "5"}},
+		{"@KSampler:seed:int=5", CLIParameter{NodeID: -1, NodeType: "KSampler", Name: "seed", Type: "int", Value: "5"}},
+		{"*Sampler:seed:int=5", CLIParameter{NodeID: -1, NodeTitle: "Sampler", AllNodes: true, Name: "seed", Type: "int", Value: "5"}},
+		{"[Upscale]/Sampler:seed:int=5", CLIParameter{NodeID: -1, Group: "Upscale", NodeTitle: "Sampler", Name: "seed", Type: "int", Value: "5"}},
+		{"(3)text:string=@x", CLIParameter{NodeID: 3, Name: "text", Type: "string", Value: "@x"}},
+
+		// a property named like a type is set with its type
+		{"Primitive:int=5", CLIParameter{NodeID: -1, API: true, Name: "Primitive", Type: "int", Value: "5"}},
+		{"Primitive:int:int=5", CLIParameter{NodeID: -1, NodeTitle: "Primitive", Name: "int", Type: "int", Value: "5"}},
+		{"My String:string:string=hello", CLIParameter{NodeID: -1, NodeTitle: "My String", Name: "string", Type: "string", Value: "hello"}},
+
+		// ":" in titles and "=" or ":" in values
+		{"Step 1: Sample:seed=5", CLIParameter{NodeID: -1, NodeTitle: "Step 1: Sample", Name: "seed", Value: "5"}},
+		{"Ratio::text=a", CLIParameter{NodeID: -1, NodeTitle: "Ratio:", Name: "text", Value: "a"}},
+		{`Ratio\::text:string=a`, CLIParameter{NodeID: -1, NodeTitle: "Ratio:", Name: "text", Type: "string", Value: "a"}},
+		{`a\=b:text=x=y:z`, CLIParameter{NodeID: -1, NodeTitle: "a=b", Name: "text", Value: "x=y:z"}},
+		{"text=", CLIParameter{NodeID: -1, API: true, Name: "text", Value: ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.param, func(t *testing.T) {
+			got, err := ParseParameter(tt.param)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseParameter(%q) = %+v, want %+v", tt.param, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseParameterErrors(t *testing.T) {
+	for _, param := range []string{
+		"seed",             // no value
+		"=5",               // no name
+		"KSampler:=5",      // no property
+		"[Upscale]/seed=5", // group without a node
+		"[Upscale:seed=5",  // unterminated group
+		"(x)seed=5",        // invalid node id
+		":int=5",           // typed literal without a name
+		"KSampler::int=5",  // typed literal without a property
+	} {
+		if p, err := ParseParameter(param); err == nil {
+			t.Errorf("ParseParameter(%q) = %+v, expected an error", param, p)
+		}
+	}
+}
+
+func TestParameterStringRoundTrip(t *testing.T) {
+	for _, param := range []string{
+		"seed",
+		"KSampler:seed",
+		"(3)seed",
+		"@KSampler:seed",
+		"*Sampler:seed",
+		"[Upscale]/Sampler:seed",
+		"seed:int",
+		"KSampler:seed:int",
+		"Primitive:int:int",
+	} {
+		p, err := ParseParameter(param + "=1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := p.String(); s != param {
+			t.Errorf("String() = %q, want %q", s, param)
+		}
+	}
+}
+
+func TestParseNodeSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		want     CLIParameter
+	}{
+		{"12", CLIParameter{NodeID: 12}},
+		{"(3)", CLIParameter{NodeID: 3}},
+		{"@KSampler", CLIParameter{NodeID: -1, NodeType: "KSampler"}},
+		{"*Sampler", CLIParameter{NodeID: -1, NodeTitle: "Sampler", AllNodes: true}},
+		{"[Upscale]/Sampler", CLIParameter{NodeID: -1, Group: "Upscale", NodeTitle: "Sampler"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseNodeSelector(tt.selector)
+		if err != nil {
+			t.Fatalf("ParseNodeSelector(%q): %v", tt.selector, err)
+		}
+		if got != tt.want {
+			t.Errorf("ParseNodeSelector(%q) = %+v, want %+v", tt.selector, got, tt.want)
+		}
+	}
+	if _, err := ParseNodeSelector(""); err == nil {
+		t.Error("expected an error for an empty selector")
+	}
+}
+
+func TestResolveValue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prompt.txt")
+	if err := os.WriteFile(path, []byte("a cat\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		param   string
+		upload  bool
+		want    string
+		wantErr bool
+	}{
+		{"prompt=@file:" + path, false, "a cat", false},
+		{"image=@file:" + path, true, path, false},
+		{"prompt=@cat", false, "@cat", false},
+		{"prompt=@@cat", false, "@@cat", false},
+		{"prompt:string=@file:cat", false, "@file:cat", false},
+		{"seed:int=5", false, "5", false},
+		{"seed:int=five", false, "", true},
+		{"cfg:float=7.5", false, "7.5", false},
+		{"cfg:float=x", false, "", true},
+		{"flag:bool=true", false, "true", false},
+		{"flag:bool=maybe", false, "", true},
+		{"extra:json=[1, 2]", false, "[1, 2]", false},
+		{"extra:json={", false, "", true},
+		{"prompt=@file:" + path + ".missing", false, "", true},
+	}
+	for _, tt := range tests {
+		p, err := ParseParameter(tt.param)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := p.ResolveValue(tt.upload)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ResolveValue(%q) error = %v, want error %v", tt.param, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ResolveValue(%q) = %q, want %q", tt.param, got, tt.want)
+		}
+	}
+}
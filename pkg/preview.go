@@ -0,0 +1,240 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/richinsley/comfy2go/client"
+	"github.com/richinsley/comfy2go/graphapi"
+)
+
+// binary event types sent by ComfyUI over the websocket
+const (
+	previewEventImage             = 1
+	previewEventImageWithMetadata = 4
+)
+
+// number of terminal rows reserved for rendering previews in place
+const previewRows = 16
+
+// PromptMessagePreview is delivered on a QueueItem's message channel with the
+// type "preview" whenever ComfyUI sends a latent preview frame while sampling
+type PromptMessagePreview struct {
+	NodeID   int
+	Format   string // "jpeg" or "png"
+	Image    []byte
+	Sequence int
+	PromptID string
+}
+
+// PreviewSocket takes over the websocket of a ComfyClient so that the binary preview frames
+// ComfyUI sends during sampling can be surfaced.  comfy2go only understands text messages, so
+// text frames are forwarded to the client untouched and binary frames are decoded here.
+// ComfyUI keeps a single socket per client ID, so connecting with the client's ID redirects
+// all of the client's messages to this socket.
+type PreviewSocket struct {
+	Client   *client.ComfyClient
+	conn     *websocket.Conn
+	mu       sync.Mutex // prevents a race between queuing a prompt and forwarding its messages
+	promptID string
+	sequence int
+}
+
+// NewPreviewSocket connects a preview socket for an initialized ComfyClient
+func NewPreviewSocket(c *client.ComfyClient, host string, port int) (*PreviewSocket, error) {
+	url := fmt.Sprintf("ws://%s:%d/ws?clientId=%s", host, port, c.ClientID())
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	retv := &PreviewSocket{
+		Client: c,
+		conn:   conn,
+	}
+	go retv.handleMessages()
+	return retv, nil
+}
+
+// QueuePrompt queues the graph with the socket's client
+func (p *PreviewSocket) QueuePrompt(graph *graphapi.Graph) (*client.QueueItem, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.Client.QueuePrompt(graph)
+}
+
+func (p *PreviewSocket) handleMessages() {
+	defer p.conn.Close()
+	for {
+		mtype, message, err := p.conn.ReadMessage()
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Preview socket read error: %v", err))
+			return
+		}
+
+		if mtype == websocket.BinaryMessage {
+			p.handleBinaryMessage(message)
+			continue
+		}
+
+		// keep track of the prompt that is executing so previews can be routed to it
+		var status struct {
+			Type string `json:"type"`
+			Data struct {
+				PromptID string `json:"prompt_id"`
+			} `json:"data"`
+		}
+		if json.Unmarshal(message, &status) == nil && status.Type == "execution_start" {
+			p.promptID = status.Data.PromptID
+			p.sequence = 0
+		}
+
+		p.mu.Lock()
+		p.Client.OnWindowSocketMessage(string(message))
+		p.mu.Unlock()
+	}
+}
+
+func (p *PreviewSocket) handleBinaryMessage(message []byte) {
+	if len(message) < 8 {
+		return
+	}
+
+	preview := &PromptMessagePreview{
+		NodeID:   -1,
+		PromptID: p.promptID,
+	}
+
+	switch binary.BigEndian.Uint32(message[0:4]) {
+	case previewEventImage:
+		// [event type][image type][image data]
+		if binary.BigEndian.Uint32(message[4:8]) == 2 {
+			preview.Format = "png"
+		} else {
+			preview.Format = "jpeg"
+		}
+		preview.Image = message[8:]
+	case previewEventImageWithMetadata:
+		// [event type][metadata length][metadata json][image data]
+		mlen := int(binary.BigEndian.Uint32(message[4:8]))
+		if len(message) < 8+mlen {
+			return
+		}
+		var metadata struct {
+			NodeID    string `json:"node_id"`
+			PromptID  string `json:"prompt_id"`
+			ImageType string `json:"image_type"`
+		}
+		if err := json.Unmarshal(message[8:8+mlen], &metadata); err != nil {
+			slog.Debug(fmt.Sprintf("Failed to decode preview metadata: %v", err))
+			return
+		}
+		if metadata.ImageType == "image/png" {
+			preview.Format = "png"
+		} else {
+			preview.Format = "jpeg"
+		}
+		if id, err := strconv.Atoi(metadata.NodeID); err == nil {
+			preview.NodeID = id
+		}
+		if metadata.PromptID != "" {
+			preview.PromptID = metadata.PromptID
+		}
+		preview.Image = message[8+mlen:]
+	default:
+		return
+	}
+
+	qi := p.Client.GetQueuedItem(preview.PromptID)
+	if qi == nil {
+		return
+	}
+	preview.Sequence = p.sequence
+	p.sequence++
+	qi.Messages <- client.PromptMessage{
+		Type:    "preview",
+		Message: preview,
+	}
+}
+
+// ToPromptMessagePreview casts the message of a "preview" PromptMessage
+func ToPromptMessagePreview(msg client.PromptMessage) *PromptMessagePreview {
+	return msg.Message.(*PromptMessagePreview)
+}
+
+// WantsPreviews returns true if preview frames should be received from ComfyUI
+func (o *ComfyOptions) WantsPreviews() bool {
+	return (o.Preview != "" && o.Preview != "none") || o.PreviewDir != ""
+}
+
+// PreviewRenderer displays and/or saves the preview frames for a queued item
+type PreviewRenderer struct {
+	Options *ComfyOptions
+	// render previews in the terminal. When false, previews are only saved
+	Render   bool
+	reserved bool
+}
+
+// HandlePreview renders a preview frame in place and writes it to the preview folder
+func (r *PreviewRenderer) HandlePreview(preview *PromptMessagePreview) {
+	if r.Options.PreviewDir != "" {
+		err := os.MkdirAll(r.Options.PreviewDir, 0755)
+		if err == nil {
+			ext := "jpg"
+			if preview.Format == "png" {
+				ext = "png"
+			}
+			name := fmt.Sprintf("%s_%05d.%s", preview.PromptID, preview.Sequence, ext)
+			err = SaveData(&preview.Image, filepath.Join(r.Options.PreviewDir, name))
+		}
+		if err != nil {
+			slog.Warn("Failed to save preview", "error", err)
+		}
+	}
+
+	if !r.Render || r.Options.DataToStdout {
+		return
+	}
+
+	protocol, err := ResolveImageProtocol(r.Options.Preview)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Failed to render preview: %v", err))
+		return
+	}
+	out, err := FormatTerminalImage(preview.Image, "preview", protocol, 0, previewRows)
+	if err != nil {
+		slog.Debug(fmt.Sprintf("Failed to render preview: %v", err))
+		return
+	}
+
+	if !r.reserved {
+		// reserve space below the cursor so that drawing the preview never scrolls the screen,
+		// then save the cursor position so each new frame is drawn over the last
+		os.Stdout.WriteString(fmt.Sprintf("%s\033[%dA\0337", strings.Repeat("\n", previewRows+1), previewRows))
+		r.reserved = true
+	} else {
+		os.Stdout.WriteString("\0338\033[J")
+		if protocol == ImageProtocolKitty {
+			// kitty images are not removed by clearing the screen
+			os.Stdout.WriteString("\033_Ga=d,q=2\033\\")
+		}
+	}
+	os.Stdout.WriteString(out)
+	os.Stdout.Sync()
+}
+
+// Finish moves the cursor below the preview area
+func (r *PreviewRenderer) Finish() {
+	if r.reserved {
+		os.Stdout.WriteString(fmt.Sprintf("\0338\033[%dB\n", previewRows))
+		r.reserved = false
+	}
+}
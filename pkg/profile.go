@@ -0,0 +1,472 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/richinsley/comfy2go/client"
+)
+
+// interval between the system stats samples of a profiled prompt
+const profileSampleInterval = 250 * time.Millisecond
+
+// NodeProfile is the execution of a node of a profiled prompt
+type NodeProfile struct {
+	NodeID   int           `json:"node_id"`
+	Title    string        `json:"title"`
+	Type     string        `json:"type"`
+	Cached   bool          `json:"cached"`  // the node's outputs were cached, it never executed
+	Skipped  bool          `json:"skipped"` // the node is not on the path of an output, it never executed
+	Error    bool          `json:"error,omitempty"`
+	Start    time.Duration `json:"-"` // from the start of the prompt
+	Duration time.Duration `json:"-"`
+	PeakVRAM int64         `json:"peak_vram"` // peak VRAM in use while the node executed, 0 when it was not sampled
+}
+
+// MarshalJSON writes the times of the node in milliseconds
+func (n NodeProfile) MarshalJSON() ([]byte, error) {
+	type node NodeProfile
+	return json.Marshal(struct {
+		node
+		Start    float64 `json:"start_ms"`
+		Duration float64 `json:"duration_ms"`
+	}{node(n), milliseconds(n.Start), milliseconds(n.Duration)})
+}
+
+// VRAMSample is the VRAM in use on the first device of the host
+type VRAMSample struct {
+	Time time.Duration `json:"-"` // from the start of the prompt
+	Used int64         `json:"used"`
+}
+
+// MarshalJSON writes the time of the sample in milliseconds
+func (s VRAMSample) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Time float64 `json:"time_ms"`
+		Used int64   `json:"used"`
+	}{milliseconds(s.Time), s.Used})
+}
+
+// PromptProfile is the per node execution of a prompt
+type PromptProfile struct {
+	PromptID  string         `json:"prompt_id"`
+	Host      string         `json:"host"`
+	Started   time.Time      `json:"started"`
+	Duration  time.Duration  `json:"-"`
+	VRAMTotal int64          `json:"vram_total"`
+	PeakVRAM  int64          `json:"peak_vram"`
+	Nodes     []*NodeProfile `json:"nodes"` // the executed nodes in order, then the cached and skipped nodes
+	Samples   []VRAMSample   `json:"vram_samples"`
+}
+
+// MarshalJSON writes the duration of the prompt in milliseconds
+func (p PromptProfile) MarshalJSON() ([]byte, error) {
+	type prompt PromptProfile
+	return json.Marshal(struct {
+		prompt
+		Duration float64 `json:"duration_ms"`
+	}{prompt(p), milliseconds(p.Duration)})
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Profiler records the execution of each node of a queued prompt.  A node runs from its
+// "executing" message to the next "executing" or "stopped" message.  The nodes of the prompt
+// that never execute were either cached, as listed in the prompt's history, or skipped.  The VRAM in use is sampled from the system stats while the
+// prompt runs.
+type Profiler struct {
+	options  *ComfyOptions
+	workflow *Workflow
+	profile  *PromptProfile
+	nodes    []int // ids of the nodes of the prompt
+	mu       sync.Mutex
+	current  *NodeProfile
+	stop     chan struct{}
+	stopped  bool
+}
+
+// CheckProfileFormat returns an error for an unknown profile format
+func CheckProfileFormat(format string) error {
+	switch format {
+	case "", "table", "json", "trace":
+		return nil
+	}
+	return fmt.Errorf("unknown profile format %s, expected table, json or trace", format)
+}
+
+// NewProfiler creates a profiler for a queued prompt, or nil when profiling is off.  The methods
+// of a nil profiler do nothing.
+func NewProfiler(workflow *Workflow, options *ComfyOptions, promptID string) *Profiler {
+	if options.Profile == "" {
+		return nil
+	}
+	return newProfiler(workflow, options, promptID)
+}
+
+func newProfiler(workflow *Workflow, options *ComfyOptions, promptID string) *Profiler {
+	p := &Profiler{
+		options:  options,
+		workflow: workflow,
+		profile: &PromptProfile{
+			PromptID: promptID,
+			Host:     fmt.Sprintf("%s:%d", options.Host[workflow.ClientIndex], options.Port[workflow.ClientIndex]),
+			Nodes:    make([]*NodeProfile, 0),
+			Samples:  make([]VRAMSample, 0),
+		},
+	}
+	// the nodes serialized into the prompt
+	for _, n := range workflow.Graph.Nodes {
+		if !n.IsVirtual() && n.Mode != NodeModeNever && n.Mode != NodeModeBypass {
+			p.nodes = append(p.nodes, n.ID)
+		}
+	}
+	sort.Ints(p.nodes)
+	return p
+}
+
+// Started starts timing the prompt and sampling the host's VRAM
+func (p *Profiler) Started() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.start()
+}
+
+func (p *Profiler) start() {
+	if p.stop != nil {
+		return
+	}
+	p.profile.Started = time.Now()
+	p.stop = make(chan struct{})
+	go p.sample(p.stop)
+}
+
+// Executing ends the executing node and starts the next one
+func (p *Profiler) Executing(nodeID int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.start()
+	p.endNode()
+
+	node := &NodeProfile{NodeID: nodeID, Start: time.Since(p.profile.Started)}
+	if n := p.workflow.Graph.GetNodeById(nodeID); n != nil {
+		node.Title = n.Title
+		if node.Title == "" {
+			node.Title = n.DisplayName
+		}
+		node.Type = n.Type
+	}
+	p.current = node
+	p.profile.Nodes = append(p.profile.Nodes, node)
+}
+
+// Stopped ends the prompt, writes its profile when profiling is on and returns it.  The
+// executing node is marked as failed when the prompt stopped for an exception.
+func (p *Profiler) Stopped(exception *client.PromptMessageStoppedException) *PromptProfile {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	p.start()
+	close(p.stop)
+	p.stopped = true
+	if exception != nil && p.current != nil {
+		p.current.Error = true
+	}
+	p.endNode()
+	p.profile.Duration = time.Since(p.profile.Started)
+
+	executed := make(map[int]bool)
+	for _, n := range p.profile.Nodes {
+		executed[n.NodeID] = true
+	}
+	p.mu.Unlock()
+
+	// comfy2go drops the "execution_cached" message, the history of the prompt has it.  A
+	// prompt stopped by an error may not be in the history yet.
+	index := p.workflow.ClientIndex
+	cached, err := CachedNodes(p.options.Host[index], p.options.Port[index], p.profile.PromptID)
+	for retry := 0; err != nil && retry < 5; retry++ {
+		time.Sleep(200 * time.Millisecond)
+		cached, err = CachedNodes(p.options.Host[index], p.options.Port[index], p.profile.PromptID)
+	}
+	if err != nil {
+		slog.Debug("Failed to read the cached nodes of the prompt", "error", err)
+	}
+
+	p.mu.Lock()
+	for _, id := range p.nodes {
+		if executed[id] {
+			continue
+		}
+		node := &NodeProfile{NodeID: id, Cached: cached[id], Skipped: !cached[id]}
+		if n := p.workflow.Graph.GetNodeById(id); n != nil {
+			node.Title = n.Title
+			if node.Title == "" {
+				node.Title = n.DisplayName
+			}
+			node.Type = n.Type
+		}
+		p.profile.Nodes = append(p.profile.Nodes, node)
+	}
+	p.mu.Unlock()
+
+	if p.options.Profile != "" {
+		p.write()
+	}
+	return p.profile
+}
+
+// CachedNodes fetches the history of a finished prompt and returns the nodes whose outputs
+// were cached
+func CachedNodes(host string, port int, promptID string) (map[int]bool, error) {
+	resp, err := http.Get(fmt.Sprintf("http://%s:%d/history/%s", host, port, url.PathEscape(promptID)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var history map[string]struct {
+		Status struct {
+			// [event, data] from the start to the end of the prompt
+			Messages [][]json.RawMessage `json:"messages"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal(body, &history); err != nil {
+		return nil, err
+	}
+
+	entry, ok := history[promptID]
+	if !ok {
+		return nil, fmt.Errorf("prompt %s is not in the history", promptID)
+	}
+	retv := make(map[int]bool)
+	for _, msg := range entry.Status.Messages {
+		var event string
+		if len(msg) < 2 || json.Unmarshal(msg[0], &event) != nil || event != "execution_cached" {
+			continue
+		}
+		var data struct {
+			Nodes []string `json:"nodes"`
+		}
+		if err := json.Unmarshal(msg[1], &data); err != nil {
+			return nil, err
+		}
+		for _, nodeid := range data.Nodes {
+			var id int
+			if _, err := fmt.Sscanf(nodeid, "%d", &id); err == nil {
+				retv[id] = true
+			}
+		}
+	}
+	return retv, nil
+}
+
+func (p *Profiler) endNode() {
+	if p.current == nil {
+		return
+	}
+	p.current.Duration = time.Since(p.profile.Started) - p.current.Start
+	p.current = nil
+}
+
+// sample records the VRAM in use on the first device of the host until stop is closed
+func (p *Profiler) sample(stop chan struct{}) {
+	ticker := time.NewTicker(profileSampleInterval)
+	defer ticker.Stop()
+	for {
+		stats, err := p.workflow.Client.GetSystemStats()
+		if err == nil && len(stats.Devices) > 0 {
+			device := stats.Devices[0]
+			used := device.VRAM_Total - device.VRAM_Free
+			p.mu.Lock()
+			if p.stopped {
+				p.mu.Unlock()
+				return
+			}
+			p.profile.VRAMTotal = device.VRAM_Total
+			p.profile.PeakVRAM = max(p.profile.PeakVRAM, used)
+			if p.current != nil {
+				p.current.PeakVRAM = max(p.current.PeakVRAM, used)
+			}
+			p.profile.Samples = append(p.profile.Samples, VRAMSample{Time: time.Since(p.profile.Started), Used: used})
+			p.mu.Unlock()
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// write writes the profile to stderr, or rewrites the profile file with the profiles of every
+// prompt of the run
+func (p *Profiler) write() {
+	o := p.options
+	o.profilesMutex.Lock()
+	defer o.profilesMutex.Unlock()
+	o.profiles = append(o.profiles, p.profile)
+
+	if o.ProfileFile == "" {
+		WriteProfiles(os.Stderr, o.Profile, []*PromptProfile{p.profile})
+		return
+	}
+	f, err := os.Create(o.ProfileFile)
+	if err != nil {
+		slog.Warn("Failed to write profile", "error", err)
+		return
+	}
+	defer f.Close()
+	WriteProfiles(f, o.Profile, o.profiles)
+}
+
+// WriteProfiles writes prompt profiles as a table, json, or a Chrome trace event file
+func WriteProfiles(w io.Writer, format string, profiles []*PromptProfile) {
+	switch format {
+	case "json":
+		j, err := ToJson(profiles, true)
+		if err != nil {
+			slog.Warn("Failed to format profile", "error", err)
+			return
+		}
+		fmt.Fprintln(w, j)
+	case "trace":
+		j, err := json.Marshal(traceEvents(profiles))
+		if err != nil {
+			slog.Warn("Failed to format profile", "error", err)
+			return
+		}
+		fmt.Fprintln(w, string(j))
+	default:
+		for _, profile := range profiles {
+			writeProfileTable(w, profile)
+		}
+	}
+}
+
+func writeProfileTable(w io.Writer, profile *PromptProfile) {
+	fmt.Fprintf(w, "Prompt %s on %s: %s", profile.PromptID, profile.Host, profile.Duration.Round(time.Millisecond))
+	if profile.VRAMTotal > 0 {
+		fmt.Fprintf(w, ", peak VRAM %s", memoryUse(profile.VRAMTotal, profile.VRAMTotal-profile.PeakVRAM))
+	}
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNODE\tTYPE\tSTART\tTIME\t%\tPEAK VRAM")
+	for _, n := range profile.Nodes {
+		if n.Cached || n.Skipped {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t-\t%s\t-\t-\n", n.NodeID, n.Title, n.Type, nodeState(n))
+			continue
+		}
+		percent := 0.0
+		if profile.Duration > 0 {
+			percent = float64(n.Duration) * 100 / float64(profile.Duration)
+		}
+		duration := n.Duration.Round(time.Millisecond).String()
+		if n.Error {
+			duration += " (error)"
+		}
+		vram := "-"
+		if n.PeakVRAM > 0 {
+			vram = fmt.Sprintf("%.2f GB", float64(n.PeakVRAM)/(1<<30))
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%.1f\t%s\n", n.NodeID, n.Title, n.Type, n.Start.Round(time.Millisecond), duration, percent, vram)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+}
+
+// nodeState is "cached" or "skipped" for a node that never executed
+func nodeState(n *NodeProfile) string {
+	if n.Cached {
+		return "cached"
+	}
+	return "skipped"
+}
+
+// traceEvent is an event of the Chrome trace event format, loaded by Perfetto and chrome://tracing
+type traceEvent struct {
+	Name      string                 `json:"name"`
+	Category  string                 `json:"cat,omitempty"`
+	Phase     string                 `json:"ph"`
+	Timestamp int64                  `json:"ts"` // microseconds
+	Duration  int64                  `json:"dur,omitempty"`
+	PID       int                    `json:"pid"`
+	TID       int                    `json:"tid"`
+	Scope     string                 `json:"s,omitempty"`
+	Args      map[string]interface{} `json:"args,omitempty"`
+}
+
+// traceEvents lays out the profiles with a process for each host, a track for the prompts, a
+// track for their nodes and a counter of the VRAM in use
+func traceEvents(profiles []*PromptProfile) map[string]interface{} {
+	events := make([]traceEvent, 0)
+	pids := make(map[string]int)
+	for _, profile := range profiles {
+		pid, ok := pids[profile.Host]
+		if !ok {
+			pid = len(pids) + 1
+			pids[profile.Host] = pid
+			events = append(events,
+				traceEvent{Name: "process_name", Phase: "M", PID: pid, Args: map[string]interface{}{"name": profile.Host}},
+				traceEvent{Name: "thread_name", Phase: "M", PID: pid, TID: 1, Args: map[string]interface{}{"name": "prompts"}},
+				traceEvent{Name: "thread_name", Phase: "M", PID: pid, TID: 2, Args: map[string]interface{}{"name": "nodes"}})
+		}
+
+		start := profile.Started.UnixMicro()
+		events = append(events, traceEvent{
+			Name:      "prompt " + profile.PromptID,
+			Category:  "prompt",
+			Phase:     "X",
+			Timestamp: start,
+			Duration:  profile.Duration.Microseconds(),
+			PID:       pid,
+			TID:       1,
+			Args:      map[string]interface{}{"prompt_id": profile.PromptID, "peak_vram": profile.PeakVRAM},
+		})
+		for _, n := range profile.Nodes {
+			args := map[string]interface{}{"node_id": n.NodeID, "type": n.Type}
+			if n.Cached || n.Skipped {
+				events = append(events, traceEvent{Name: n.Title + " (" + nodeState(n) + ")", Category: n.Type, Phase: "i", Timestamp: start, PID: pid, TID: 2, Scope: "t", Args: args})
+				continue
+			}
+			args["peak_vram"] = n.PeakVRAM
+			args["error"] = n.Error
+			events = append(events, traceEvent{
+				Name:      n.Title,
+				Category:  n.Type,
+				Phase:     "X",
+				Timestamp: start + n.Start.Microseconds(),
+				Duration:  n.Duration.Microseconds(),
+				PID:       pid,
+				TID:       2,
+				Args:      args,
+			})
+		}
+		for _, s := range profile.Samples {
+			events = append(events, traceEvent{Name: "VRAM", Phase: "C", Timestamp: start + s.Time.Microseconds(), PID: pid, Args: map[string]interface{}{"used": s.Used}})
+		}
+	}
+	return map[string]interface{}{"traceEvents": events, "displayTimeUnit": "ms"}
+}
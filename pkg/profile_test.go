@@ -0,0 +1,62 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCachedNodes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/history/p1" {
+			fmt.Fprint(w, "{}")
+			return
+		}
+		fmt.Fprint(w, `{"p1": {"status": {"status_str": "success", "messages": [
+			["execution_start", {"prompt_id": "p1", "timestamp": 1000}],
+			["execution_cached", {"nodes": ["4", "7"], "prompt_id": "p1", "timestamp": 1001}],
+			["execution_success", {"prompt_id": "p1", "timestamp": 3000}]]}}}`)
+	}))
+	defer server.Close()
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	p, _ := strconv.Atoi(port)
+
+	cached, err := CachedNodes(host, p, "p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int]bool{4: true, 7: true}; !reflect.DeepEqual(cached, want) {
+		t.Errorf("cached nodes = %v, want %v", cached, want)
+	}
+	if _, err := CachedNodes(host, p, "p2"); err == nil {
+		t.Error("expected an error for a prompt that is not in the history")
+	}
+}
+
+func TestProfileJsonMilliseconds(t *testing.T) {
+	profile := &PromptProfile{
+		PromptID: "p1",
+		Duration: 1500 * time.Millisecond,
+		Nodes:    []*NodeProfile{{NodeID: 3, Start: 250 * time.Microsecond, Duration: 2 * time.Millisecond}},
+		Samples:  []VRAMSample{{Time: 250 * time.Millisecond, Used: 1024}},
+	}
+	j, err := json.Marshal(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"duration_ms":1500`, `"start_ms":0.25`, `"duration_ms":2`, `"time_ms":250`, `"node_id":3`, `"skipped":false`} {
+		if !strings.Contains(string(j), want) {
+			t.Errorf("profile json %s does not contain %s", j, want)
+		}
+	}
+	if strings.Contains(string(j), `"duration":`) {
+		t.Errorf("profile json %s has a duration without a unit", j)
+	}
+}
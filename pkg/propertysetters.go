@@ -1,7 +1,14 @@
 package pkg
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"strings"
 
 	"github.com/richinsley/comfy2go/client"
 	"github.com/richinsley/comfy2go/graphapi"
@@ -29,19 +36,7 @@ func SetfileUploadPropertyValue(c *client.ComfyClient, options *ComfyOptions, pr
 		return false, fmt.Errorf("expected string value for file upload property")
 	}
 
-	if filename == "-" {
-		// if the filename is "-" then we read an image from stdin
-		img, err := ExpectImage(options.GetStdinReader())
-		if err != nil {
-			return false, err
-		}
-
-		_, err = c.UploadImage(img, "image_"+c.ClientID()+".png", true, client.InputImageType, "", uploadprop)
-		if err != nil {
-			return false, err
-		}
-		return true, nil
-	} else {
+	if !isPipeSource(filename) {
 		// because we set it to not overwrite existing, the returned filename may
 		// be different than the one we provided
 		_, err := c.UploadFileFromPath(filename, true, client.InputImageType, "", uploadprop)
@@ -50,4 +45,167 @@ func SetfileUploadPropertyValue(c *client.ComfyClient, options *ComfyOptions, pr
 		}
 		return false, nil
 	}
+
+	// if the filename is "-" or another source then we read the next image frame from it
+	frame, err := readPipeSource(options, filename)
+	if err != nil {
+		return false, err
+	}
+
+	// upload the original bytes when we have them, so nothing is lost to re-encoding
+	name := uploadName(c, "image", uploadprop)
+	if frame.Data == nil {
+		_, err = c.UploadImage(frame.Image, name+".png", true, client.InputImageType, "", uploadprop)
+	} else {
+		_, err = c.UploadFileFromReader(bytes.NewReader(frame.Data), name+ImageExtension(frame.Format), true, client.InputImageType, "", uploadprop)
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetMaskUploadPropertyValue uploads a mask for the image of a file upload property.  ComfyUI
+// applies the mask as the alpha channel of the image and stores the result in the "clipspace"
+// input folder, the same as the mask editor does.
+func SetMaskUploadPropertyValue(c *client.ComfyClient, options *ComfyOptions, prop graphapi.Property, value interface{}) (bool, error) {
+	uploadprop, ok := prop.ToImageUploadProperty()
+	if !ok || uploadprop.TargetProperty == nil {
+		return false, fmt.Errorf("mask requires an image upload property")
+	}
+
+	// a frame already read from a source is shared by the nodes of a parameter
+	frame, readFromPipe := value.(*Frame)
+	if !readFromPipe {
+		filename, ok := value.(string)
+		if !ok {
+			return false, fmt.Errorf("expected string value for mask upload property")
+		}
+		readFromPipe = isPipeSource(filename)
+		if readFromPipe {
+			var err error
+			if frame, err = readPipeSource(options, filename); err != nil {
+				return false, err
+			}
+		} else {
+			data, err := os.ReadFile(filename)
+			if err != nil {
+				return false, err
+			}
+			frame = &Frame{Format: ImageFormat(data), Data: data}
+		}
+	}
+
+	mask, err := MaskToAlpha(frame)
+	if err != nil {
+		return false, err
+	}
+
+	// the mask is applied to the image the property currently refers to
+	original, _ := uploadprop.TargetProperty.GetValue().(string)
+	host, port, err := options.ClientAddress(c)
+	if err != nil {
+		return false, err
+	}
+	name, subfolder, err := uploadMask(host, port, mask, uploadName(c, "mask", uploadprop)+".png", original)
+	if err != nil {
+		return false, err
+	}
+	uploadprop.SetFilename(path.Join(subfolder, name) + " [input]")
+	return readFromPipe, nil
+}
+
+// uploadName returns the name, without an extension, of the images a client uploads for a
+// property.  Each node and property gets its own name, so the uploads of different properties
+// don't overwrite each other, while the uploads of one property are replaced by each work item.
+func uploadName(c *client.ComfyClient, prefix string, uploadprop *graphapi.ImageUploadProperty) string {
+	name := uploadprop.Name()
+	node := uploadprop.GetTargetNode()
+	if uploadprop.TargetProperty != nil {
+		// the name of the image property, rather than "choose file to upload"
+		name = uploadprop.TargetProperty.Name()
+		if node == nil {
+			node = uploadprop.TargetProperty.GetTargetNode()
+		}
+	}
+	name = strings.ReplaceAll(name, " ", "_")
+	if node == nil {
+		return fmt.Sprintf("%s_%s_%s", prefix, c.ClientID(), name)
+	}
+	return fmt.Sprintf("%s_%s_%d_%s", prefix, c.ClientID(), node.ID, name)
+}
+
+// isPipeSource returns true if an upload value reads from a ParameterSource
+func isPipeSource(filename string) bool {
+	return IsSourceSpec(filename) || isNamedPipe(filename)
+}
+
+// readPipeSource reads the next image frame from a ParameterSource
+func readPipeSource(options *ComfyOptions, filename string) (*Frame, error) {
+	source, err := options.GetSource(filename)
+	if err != nil {
+		return nil, err
+	}
+	frame, err := source.NextFrame()
+	if err != nil {
+		return nil, err
+	}
+	if err := options.recordFrame(filename, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// uploadMask uploads a mask with ComfyUI's mask upload, returning the name and subfolder of
+// the masked image.  original is the value of the image property, which may be annotated with
+// its folder type, e.g. "clipspace/image.png [input]"
+func uploadMask(host string, port int, mask []byte, filename string, original string) (string, string, error) {
+	// split the annotated filename into its parts
+	ftype := "input"
+	for _, t := range []string{"input", "output", "temp"} {
+		if strings.HasSuffix(original, " ["+t+"]") {
+			ftype = t
+			original = strings.TrimSuffix(original, " ["+t+"]")
+		}
+	}
+	subfolder, name := path.Split(original)
+	ref, err := json.Marshal(map[string]string{
+		"filename":  name,
+		"subfolder": strings.TrimSuffix(subfolder, "/"),
+		"type":      ftype,
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	formFile, err := writer.CreateFormFile("image", filename)
+	if err != nil {
+		return "", "", err
+	}
+	formFile.Write(mask)
+	writer.WriteField("original_ref", string(ref))
+	writer.WriteField("type", "input")
+	writer.WriteField("subfolder", "clipspace")
+	writer.WriteField("overwrite", "true")
+	writer.Close()
+
+	resp, err := http.Post(fmt.Sprintf("http://%s:%d/upload/mask", host, port), writer.FormDataContentType(), &body)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("mask upload failed: %s", resp.Status)
+	}
+
+	var result struct {
+		Name      string `json:"name"`
+		Subfolder string `json:"subfolder"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", "", err
+	}
+	return result.Name, result.Subfolder, nil
 }
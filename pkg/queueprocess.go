@@ -1,28 +1,42 @@
 package pkg
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"strings"
+	"sync"
 
 	"github.com/richinsley/comfy2go/client"
-	// "github.com/richinsley/comfy2go/graphapi"
+	"github.com/richinsley/comfy2go/graphapi"
 	"github.com/schollz/progressbar/v3"
 )
 
 type WorkflowQueueProcessor struct {
-	Workflow *Workflow
-	HasLoop  bool
-	Missing  *[]string
+	Workflow     *Workflow
+	WorkflowPath string
+	HasLoop      bool
+	Missing      *[]string
 }
 
 type WorkflowQueueDataOutputItems struct {
 	WorkItem int
-	Outputs  []map[string][]client.DataOutput
+	PromptID string
+	Outputs  []*NodeOutput
 	Client   *client.ComfyClient
 }
 
+// HandleWorkItemOutputs handles the outputs of a work item collected to keep their order
+func HandleWorkItemOutputs(options *ComfyOptions, item WorkflowQueueDataOutputItems) {
+	for _, v := range item.Outputs {
+		HandleNodeOutput(item.Client, options, v)
+	}
+	if item.PromptID != "" {
+		finishWorkItem(options, item.PromptID)
+	}
+}
+
 func ClientWithWorkflow(client_index int, options *ComfyOptions, workflowpath string, parameters []CLIParameter, callbacks *client.ComfyClientCallbacks, applyparams bool) (*Workflow, bool, *[]string, error) {
 	workflow, missing, err := GetFullWorkflow(client_index, options, workflowpath, callbacks)
 	if missing != nil {
@@ -38,13 +52,49 @@ func ClientWithWorkflow(client_index int, options *ComfyOptions, workflowpath st
 		if err != nil {
 			return nil, false, nil, err
 		}
-	} else {
-		// if parameters is nil, assume this is a pipe loop
+	} else if !applyparams {
+		// the parameters are applied for each work item, assume this is a pipe loop
 		hasPipeLoop = true
 	}
 	return workflow, hasPipeLoop, nil, nil
 }
 
+// ClientsWithWorkflows loads the workflow on every host at the same time, then applies the
+// parameters to each host in turn.  Values read from parameter sources and API values are read
+// once and set on every host, so every host runs the same job.  The results are in the order
+// of the hosts.
+func ClientsWithWorkflows(options *ComfyOptions, workflowpath string, parameters []CLIParameter) ([]*Workflow, []*[]string, []error) {
+	workflows := make([]*Workflow, len(options.Host))
+	missing := make([]*[]string, len(options.Host))
+	errs := make([]error, len(options.Host))
+	if options.Clients == nil {
+		options.Clients = make([]*client.ComfyClient, len(options.Host))
+	}
+
+	var wg sync.WaitGroup
+	for i := range options.Host {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			workflows[i], _, missing[i], errs[i] = ClientWithWorkflow(i, options, workflowpath, parameters, nil, false)
+		}(i)
+	}
+	wg.Wait()
+
+	options.replay = &inputReplay{}
+	defer func() { options.replay = nil }()
+	for i, workflow := range workflows {
+		if workflow == nil || (parameters == nil && workflow.SimpleAPI == nil) {
+			continue
+		}
+		options.replay.rewind(options)
+		if _, err := ApplyParameters(workflow.Client, options, workflow.Graph, workflow.SimpleAPI, parameters); err != nil {
+			workflows[i], errs[i] = nil, err
+		}
+	}
+	return workflows, missing, errs
+}
+
 // GetWorkflowsAsync returns a channel of WorkflowQueueProcessor
 // that can be used to get the workflows asynchronously
 // nil is returned if there was an error creating the client
@@ -59,9 +109,10 @@ func GetWorkflowsAsync(options *ComfyOptions, workflowpath string, parameters []
 				return
 			}
 			w := &WorkflowQueueProcessor{
-				Workflow: workflow,
-				HasLoop:  hasPipeLoop,
-				Missing:  missing,
+				Workflow:     workflow,
+				WorkflowPath: workflowpath,
+				HasLoop:      hasPipeLoop,
+				Missing:      missing,
 			}
 			retv <- w
 		}(i)
@@ -75,76 +126,106 @@ func GetWorkflows(options *ComfyOptions, workflowpath string, parameters []CLIPa
 	for i := 0; i < len(options.Host); i++ {
 		workflow, hasPipeLoop, missing, err := ClientWithWorkflow(i, options, workflowpath, parameters, nil, true)
 		if err != nil {
-			slog.Error("Failed to create comfyui client", err)
+			slog.Error("Failed to create comfyui client", "error", err)
 			continue
 		}
 		w := &WorkflowQueueProcessor{
-			Workflow: workflow,
-			HasLoop:  hasPipeLoop,
-			Missing:  missing,
+			Workflow:     workflow,
+			WorkflowPath: workflowpath,
+			HasLoop:      hasPipeLoop,
+			Missing:      missing,
 		}
 		retv = append(retv, w)
 	}
 	return retv
 }
 
-func HandleDataOutput(client *client.ComfyClient, options *ComfyOptions, output map[string][]client.DataOutput) {
-	// data objects have the fields: Filename, Subfolder, Type
-	// * Subfolder is the subfolder in the output directory
-	// * Type is the type of the image temp/
-	for k, v := range output {
-		if k == "images" || k == "gifs" {
-			for _, output := range v {
-				img_data, err := client.GetImage(output)
-				if err != nil {
-					slog.Error("Failed to get image", err)
-					os.Exit(1)
-				}
+// unparsedOutputs returns the outputs of a finished prompt that were never delivered as data messages
+func unparsedOutputs(workflow *Workflow, options *ComfyOptions, promptID string) []*NodeOutput {
+	outputs, err := UnparsedOutputs(options.Host[workflow.ClientIndex], options.Port[workflow.ClientIndex], promptID)
+	if err != nil {
+		slog.Warn("Failed to get prompt history", "error", err)
+		return nil
+	}
+	return outputs
+}
 
-				// what to do with the image data
-				if options.InlineImages {
-					// print the image to the terminal
-					OutputInlineToStd(img_data, output.Filename, -1, -1)
-				}
+// resumeWorkItem returns the key of the work item whose parameters were just applied, for a
+// resumable run, and true if the work item finished in an earlier run
+func resumeWorkItem(options *ComfyOptions) (string, bool) {
+	if options.Resume == nil || options.workItemInput == nil {
+		return "", false
+	}
+	key := options.workItemInput.Key()
+	if options.Resume.IsDone(key) {
+		slog.Info("Skipping finished work item", "key", key[:12])
+		return key, true
+	}
+	return key, false
+}
 
-				if !options.NoSaveData {
-					SaveData(img_data, output.Filename)
-				}
+// queuedWorkItem records a work item of a resumable run as in flight
+func queuedWorkItem(workflow *Workflow, options *ComfyOptions, key string, promptID string) {
+	if options.Resume == nil || key == "" {
+		return
+	}
+	host := fmt.Sprintf("%s:%d", options.Host[workflow.ClientIndex], options.Port[workflow.ClientIndex])
+	if err := options.Resume.Queued(key, promptID, host); err != nil {
+		slog.Warn("Failed to save resume state", "error", err)
+	}
+}
 
-				if options.DataToStdout {
-					_, err := os.Stdout.Write(*img_data)
-					if err != nil {
-						slog.Error("Failed to write data to stdout", err)
-						os.Exit(1)
-					}
-					os.Stdout.Sync()
-				}
-				slog.Debug(fmt.Sprintf("Got data file: %s", output.Filename))
-			}
-		} else if k == "text" {
-			for _, output := range v {
-				fmt.Println(output.Text)
-			}
-		}
+// finishWorkItem records a work item of a resumable run as done
+func finishWorkItem(options *ComfyOptions, promptID string) {
+	if options.Resume == nil {
+		return
+	}
+	if err := options.Resume.Finish(promptID); err != nil {
+		slog.Warn("Failed to save resume state", "error", err)
 	}
 }
 
 func ProcessWorkerQueue(worker *WorkflowQueueProcessor, options *ComfyOptions, parameters []CLIParameter, workers chan *WorkflowQueueProcessor, workitem int, dataitems chan WorkflowQueueDataOutputItems) {
-	var dataouts []map[string][]client.DataOutput = nil
+	var dataouts []*NodeOutput = nil
+	if options.IsBatch() {
+		// start each batch job from the workflow file, so cells left empty keep its values
+		workflow, _, err := GetFullWorkflow(worker.Workflow.ClientIndex, options, worker.WorkflowPath, nil)
+		if err != nil {
+			slog.Error("Failed to load workflow", "error", err)
+			os.Exit(1)
+		}
+		worker.Workflow = workflow
+	}
 	loop, err := ApplyParameters(worker.Workflow.Client, options, worker.Workflow.Graph, worker.Workflow.SimpleAPI, parameters)
 	if err != nil {
-		if err.Error() != "no JSON object found in the input" {
-			slog.Error("Failed to apply parameters", err)
+		if !errors.Is(err, ErrEndOfInput) {
+			slog.Error("Failed to apply parameters", "error", err)
+		}
+		if dataitems != nil {
+			// keep the ordered outputs from waiting on this work item
+			dataitems <- WorkflowQueueDataOutputItems{WorkItem: workitem}
 		}
 		workers <- nil
 		return
 	}
 
-	if !loop {
+	if !loop && !options.IsBatch() {
+		if dataitems != nil {
+			dataitems <- WorkflowQueueDataOutputItems{WorkItem: workitem}
+		}
 		workers <- nil
 		return
 	}
 
+	key, done := resumeWorkItem(options)
+	if done {
+		if dataitems != nil {
+			dataitems <- WorkflowQueueDataOutputItems{WorkItem: workitem}
+		}
+		workers <- worker
+		return
+	}
+
 	workflow := worker.Workflow
 	// get any output nodes that were specified in the api
 	var outputnodes map[string]bool = make(map[string]bool)
@@ -171,15 +252,20 @@ func ProcessWorkerQueue(worker *WorkflowQueueProcessor, options *ComfyOptions, p
 
 	// run the queuprompt in a goroutine
 	go func() {
-		item, err := workflow.Client.QueuePrompt(workflow.Graph)
+		item, err := queuePrompt(workflow, options)
 		if err != nil {
-			slog.Error("Failed to queue prompt", err)
+			slog.Error("Failed to queue prompt", "error", err)
 			os.Exit(1)
 		}
+		queuedWorkItem(workflow, options, key, item.PromptID)
+		profiler := NewProfiler(workflow, options, item.PromptID)
 
 		// we'll provide a progress bar
 		var bar *progressbar.ProgressBar = nil
 
+		// previews from concurrent workers are only saved, never rendered
+		previews := &PreviewRenderer{Options: options, Render: false}
+
 		// continuously read messages from the QueuedItem until we get the "stopped" message type
 		var currentNodeTitle string
 		for continueLoop := true; continueLoop; {
@@ -187,10 +273,12 @@ func ProcessWorkerQueue(worker *WorkflowQueueProcessor, options *ComfyOptions, p
 			switch msg.Type {
 			case "started":
 				qm := msg.ToPromptMessageStarted()
+				profiler.Started()
 				slog.Debug(fmt.Sprintf("Start executing prompt ID %s\n", qm.PromptID))
 			case "executing":
 				bar = nil
 				qm := msg.ToPromptMessageExecuting()
+				profiler.Executing(qm.NodeID)
 				// store the node's title so we can use it in the progress bar
 				currentNodeTitle = qm.Title
 				slog.Debug(fmt.Sprintf("Executing Node: %d", qm.NodeID))
@@ -204,19 +292,38 @@ func ProcessWorkerQueue(worker *WorkflowQueueProcessor, options *ComfyOptions, p
 			case "stopped":
 				// if we were stopped for an exception, display the exception message
 				qm := msg.ToPromptMessageStopped()
+				profiler.Stopped(qm.Exception)
 				if qm.Exception != nil {
 					slog.Error(fmt.Sprintf("ComfyUI exception in node %s", qm.Exception.NodeName))
 					slog.Error(qm.Exception.ExceptionMessage)
 					os.Exit(1)
 				}
 				continueLoop = false
+
+				// pick up any outputs that comfy2go could not parse
+				for _, output := range unparsedOutputs(workflow, options, item.PromptID) {
+					if dataitems != nil {
+						dataouts = append(dataouts, output)
+					} else {
+						HandleNodeOutput(workflow.Client, options, output)
+					}
+				}
 			case "data":
 				qm := msg.ToPromptMessageData()
 				if dataitems != nil {
-					dataouts = append(dataouts, qm.Data)
+					for kind, items := range qm.Data {
+						dataouts = append(dataouts, &NodeOutput{
+							PromptID: item.PromptID,
+							NodeID:   qm.NodeID,
+							Kind:     kind,
+							Items:    items,
+						})
+					}
 				} else {
-					HandleDataOutput(workflow.Client, options, qm.Data)
+					HandleDataOutput(workflow.Client, options, item.PromptID, qm)
 				}
+			case "preview":
+				previews.HandlePreview(ToPromptMessagePreview(msg))
 			default:
 				slog.Warn(fmt.Sprintf("Unknown message type: %s", msg.Type))
 			}
@@ -226,9 +333,12 @@ func ProcessWorkerQueue(worker *WorkflowQueueProcessor, options *ComfyOptions, p
 			// we want the outputs to be processed in the order they were received
 			dataitems <- WorkflowQueueDataOutputItems{
 				WorkItem: workitem,
+				PromptID: item.PromptID,
 				Outputs:  dataouts,
 				Client:   workflow.Client,
 			}
+		} else {
+			finishWorkItem(options, item.PromptID)
 		}
 		workers <- worker
 	}()
@@ -253,11 +363,11 @@ func ProcessQueue(options *ComfyOptions, workflowpath string, parameters []CLIPa
 
 	workflow, hasPipeLoop, missing, err := ClientWithWorkflow(0, options, workflowpath, parameters, callbacks, true)
 	if err != nil {
-		if err.Error() == "no JSON object found in the input" {
+		if errors.Is(err, ErrEndOfInput) {
 			return false, err
 		}
 
-		slog.Error("Failed to create comfyui client", err)
+		slog.Error("Failed to create comfyui client", "error", err)
 		os.Exit(1)
 	}
 
@@ -289,19 +399,29 @@ func ProcessQueue(options *ComfyOptions, workflowpath string, parameters []CLIPa
 		os.Exit(1)
 	}
 	if err != nil {
-		slog.Error("Failed to create comfyui client", err)
+		slog.Error("Failed to create comfyui client", "error", err)
 		os.Exit(1)
 	}
 
-	item, err := workflow.Client.QueuePrompt(workflow.Graph)
+	key, done := resumeWorkItem(options)
+	if done {
+		return hasPipeLoop, nil
+	}
+
+	item, err := queuePrompt(workflow, options)
 	if err != nil {
-		slog.Error("Failed to queue prompt", err)
+		slog.Error("Failed to queue prompt", "error", err)
 		os.Exit(1)
 	}
+	queuedWorkItem(workflow, options, key, item.PromptID)
+	profiler := NewProfiler(workflow, options, item.PromptID)
 
 	// we'll provide a progress bar
 	var bar *progressbar.ProgressBar = nil
 
+	// render sampling previews in place when requested
+	previews := &PreviewRenderer{Options: options, Render: options.Preview != "" && options.Preview != "none"}
+
 	// continuously read messages from the QueuedItem until we get the "stopped" message type
 	var currentNodeTitle string
 	for continueLoop := true; continueLoop; {
@@ -309,10 +429,12 @@ func ProcessQueue(options *ComfyOptions, workflowpath string, parameters []CLIPa
 		switch msg.Type {
 		case "started":
 			qm := msg.ToPromptMessageStarted()
+			profiler.Started()
 			slog.Debug(fmt.Sprintf("Start executing prompt ID %s\n", qm.PromptID))
 		case "executing":
 			bar = nil
 			qm := msg.ToPromptMessageExecuting()
+			profiler.Executing(qm.NodeID)
 			// store the node's title so we can use it in the progress bar
 			currentNodeTitle = qm.Title
 			slog.Debug(fmt.Sprintf("Executing Node: %d", qm.NodeID))
@@ -326,20 +448,128 @@ func ProcessQueue(options *ComfyOptions, workflowpath string, parameters []CLIPa
 		case "stopped":
 			// if we were stopped for an exception, display the exception message
 			qm := msg.ToPromptMessageStopped()
+			profiler.Stopped(qm.Exception)
 			if qm.Exception != nil {
 				slog.Error(fmt.Sprintf("ComfyUI exception in node %s", qm.Exception.NodeName))
 				slog.Error(qm.Exception.ExceptionMessage)
 				os.Exit(1)
 			}
 			continueLoop = false
+
+			// pick up any outputs that comfy2go could not parse
+			previews.Finish()
+			for _, output := range unparsedOutputs(workflow, options, item.PromptID) {
+				HandleNodeOutput(workflow.Client, options, output)
+			}
 		case "data":
+			previews.Finish()
 			qm := msg.ToPromptMessageData()
-			HandleDataOutput(workflow.Client, options, qm.Data)
+			HandleDataOutput(workflow.Client, options, item.PromptID, qm)
+		case "preview":
+			previews.HandlePreview(ToPromptMessagePreview(msg))
 		default:
 			slog.Warn(fmt.Sprintf("Unknown message type: %s", msg.Type))
 		}
 	}
+	previews.Finish()
+	finishWorkItem(options, item.PromptID)
 
 	// return true if we read from a pipe
 	return hasPipeLoop, nil
 }
+
+// queuePrompt queues a workflow, using the client's preview socket when previews are enabled
+func queuePrompt(workflow *Workflow, options *ComfyOptions) (*client.QueueItem, error) {
+	// comfy2go has no bypass mode, pass the bypassed nodes' inputs through while the prompt is built
+	restore := bypassNodes(workflow.Graph)
+	defer restore()
+
+	if options.PreviewSockets != nil && options.PreviewSockets[workflow.ClientIndex] != nil {
+		return options.PreviewSockets[workflow.ClientIndex].QueuePrompt(workflow.Graph)
+	}
+	return workflow.Client.QueuePrompt(workflow.Graph)
+}
+
+// bypassNodes prepares the graph so the prompt built from it passes the inputs of bypassed
+// nodes through to the nodes linked to their outputs, the way the ComfyUI frontend does.  Each
+// output takes the input at the same index if it has the output's type, or else the first input
+// of that type.  The prompt embeds the graph as its workflow, so only the link and execution
+// order lookups the prompt is built from are changed, never the serialized links or node modes.
+// The returned function restores the graph.
+func bypassNodes(graph *graphapi.Graph) func() {
+	var bypassed []*graphapi.GraphNode
+	for _, n := range graph.Nodes {
+		if n.Mode == NodeModeBypass {
+			bypassed = append(bypassed, n)
+		}
+	}
+	if len(bypassed) == 0 {
+		return func() {}
+	}
+
+	links := graph.LinksByID
+	order := graph.NodesInExecutionOrder
+	rewired := make(map[int]*graphapi.Link, len(links))
+	for id, link := range links {
+		rewired[id] = link
+	}
+
+	for _, link := range graph.Links {
+		origin := graph.GetNodeById(link.OriginID)
+		if origin == nil || origin.Mode != NodeModeBypass {
+			continue
+		}
+
+		originID, originSlot := link.OriginID, link.OriginSlot
+		for origin != nil && origin.Mode == NodeModeBypass {
+			upstream := passThroughLink(graph, origin, originSlot, link.Type)
+			if upstream == nil {
+				origin = nil
+				break
+			}
+			originID, originSlot = upstream.OriginID, upstream.OriginSlot
+			origin = graph.GetNodeById(originID)
+		}
+
+		if origin == nil {
+			// nothing to pass through, the input is left unlinked
+			delete(rewired, link.ID)
+			continue
+		}
+		passed := *link
+		passed.OriginID, passed.OriginSlot = originID, originSlot
+		rewired[link.ID] = &passed
+	}
+
+	// bypassed nodes are left out of the prompt, frontend only nodes still apply to the graph
+	var executed []*graphapi.GraphNode
+	for _, n := range order {
+		if n.Mode != NodeModeBypass || n.IsVirtual() {
+			executed = append(executed, n)
+		}
+	}
+
+	graph.LinksByID = rewired
+	graph.NodesInExecutionOrder = executed
+	return func() {
+		graph.LinksByID = links
+		graph.NodesInExecutionOrder = order
+	}
+}
+
+// passThroughLink returns the link of the bypassed node's input that passes through to its output
+func passThroughLink(graph *graphapi.Graph, node *graphapi.GraphNode, output int, outputType string) *graphapi.Link {
+	if output < len(node.Inputs) && node.Inputs[output].Type == outputType {
+		if link := graph.GetLinkById(node.Inputs[output].Link); link != nil {
+			return link
+		}
+	}
+	for _, input := range node.Inputs {
+		if input.Type == outputType {
+			if link := graph.GetLinkById(input.Link); link != nil {
+				return link
+			}
+		}
+	}
+	return nil
+}
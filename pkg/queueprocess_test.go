@@ -0,0 +1,65 @@
+package pkg
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/richinsley/comfy2go/graphapi"
+)
+
+// LoadImage -> ImageScale (bypassed) -> SaveImage
+const bypassWorkflow = `{
+	"last_node_id": 3, "last_link_id": 2, "version": 0.4, "groups": [],
+	"nodes": [
+		{"id": 1, "type": "LoadImage", "order": 0, "mode": 0,
+		 "outputs": [{"name": "IMAGE", "type": "IMAGE", "links": [1]}]},
+		{"id": 2, "type": "ImageScale", "order": 1, "mode": 4,
+		 "inputs": [{"name": "image", "type": "IMAGE", "link": 1}],
+		 "outputs": [{"name": "IMAGE", "type": "IMAGE", "links": [2]}]},
+		{"id": 3, "type": "SaveImage", "order": 2, "mode": 0,
+		 "inputs": [{"name": "images", "type": "IMAGE", "link": 2}]}
+	],
+	"links": [[1, 1, 0, 2, 0, "IMAGE"], [2, 2, 0, 3, 0, "IMAGE"]]
+}`
+
+func TestBypassNodes(t *testing.T) {
+	var graph graphapi.Graph
+	if err := json.Unmarshal([]byte(bypassWorkflow), &graph); err != nil {
+		t.Fatal(err)
+	}
+	before, err := json.Marshal(&graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restore := bypassNodes(&graph)
+	prompt, err := graph.GraphToPrompt("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the embedded workflow is marshaled while the graph is still bypassed
+	embedded, err := json.Marshal(prompt.ExtraData.PngInfo.Workflow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restore()
+
+	if _, ok := prompt.Nodes[2]; ok {
+		t.Error("the bypassed node is in the prompt")
+	}
+	save, ok := prompt.Nodes[3]
+	if !ok {
+		t.Fatal("the node after the bypassed node is missing from the prompt")
+	}
+	link, _ := save.Inputs["images"].([]interface{})
+	if len(link) != 2 || link[0] != "1" || link[1] != 0 {
+		t.Errorf("images input = %v, want the output of node 1", save.Inputs["images"])
+	}
+
+	if string(embedded) != string(before) {
+		t.Errorf("embedded workflow changed by the bypass:\n%s\nwant\n%s", embedded, before)
+	}
+	if graph.GetLinkById(2).OriginID != 2 || len(graph.NodesInExecutionOrder) != 3 {
+		t.Error("the graph was not restored")
+	}
+}
@@ -0,0 +1,204 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/richinsley/comfy2go/client"
+)
+
+// WorkflowModel is a model file a workflow references, with the download url the workflow
+// records for it, if any
+type WorkflowModel struct {
+	Name      string `json:"name"`
+	URL       string `json:"url,omitempty"`
+	Directory string `json:"directory,omitempty"` // the model folder
+}
+
+// WorkflowRequirements are the node types and model files a workflow references.  They are read
+// from the workflow alone, without a ComfyUI instance.
+type WorkflowRequirements struct {
+	// node types that are not known to be ComfyUI core nodes
+	NodeTypes []string `json:"node_types"`
+	// node types the workflow records as ComfyUI core nodes
+	CoreTypes []string `json:"core_types,omitempty"`
+	// node type to the git repo the workflow records for it
+	Repos map[string]string `json:"repos,omitempty"`
+	// node type to the comfy registry id the workflow records for it
+	Packs  map[string]string `json:"packs,omitempty"`
+	Models []WorkflowModel   `json:"models"`
+}
+
+// modelExtensions are the extensions of model files
+var modelExtensions = []string{".safetensors", ".ckpt", ".pt", ".pth", ".bin", ".gguf", ".sft", ".onnx", ".pkl"}
+
+// virtualNodeTypes are node types of the frontend that are never run by the server
+var virtualNodeTypes = []string{"PrimitiveNode", "Reroute", "Note", "MarkdownNote"}
+
+// workflowNode is a node of a workflow in the UI format
+type workflowNode struct {
+	Type          string                 `json:"type"`
+	Properties    map[string]interface{} `json:"properties"`
+	WidgetsValues interface{}            `json:"widgets_values"`
+}
+
+// GetWorkflowRequirements reads the node types and model files of a workflow in the UI or API
+// format, or of the workflow in the metadata of a png file
+func GetWorkflowRequirements(workflowpath string) (*WorkflowRequirements, error) {
+	var data []byte
+	if strings.HasSuffix(strings.ToLower(workflowpath), ".png") {
+		f, err := os.Open(workflowpath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		metadata, err := client.GetPngMetadata(f)
+		if err != nil {
+			return nil, err
+		}
+		workflow, ok := metadata["workflow"]
+		if !ok {
+			if workflow, ok = metadata["prompt"]; !ok {
+				return nil, fmt.Errorf("png does not contain workflow metadata")
+			}
+		}
+		data = []byte(workflow)
+	} else {
+		var err error
+		if data, err = os.ReadFile(workflowpath); err != nil {
+			return nil, err
+		}
+	}
+
+	req := &WorkflowRequirements{
+		NodeTypes: make([]string, 0),
+		Repos:     make(map[string]string),
+		Packs:     make(map[string]string),
+		Models:    make([]WorkflowModel, 0),
+	}
+	var ui struct {
+		Nodes       []workflowNode `json:"nodes"`
+		Definitions struct {
+			Subgraphs []struct {
+				ID    string         `json:"id"`
+				Nodes []workflowNode `json:"nodes"`
+			} `json:"subgraphs"`
+		} `json:"definitions"`
+	}
+	if err := json.Unmarshal(data, &ui); err != nil {
+		return nil, err
+	}
+	if ui.Nodes != nil {
+		// nodes of subgraphs have the id of the subgraph as their type
+		subgraphs := make([]string, 0)
+		nodes := ui.Nodes
+		for _, s := range ui.Definitions.Subgraphs {
+			subgraphs = append(subgraphs, s.ID)
+			nodes = append(nodes, s.Nodes...)
+		}
+		for _, n := range nodes {
+			if containsString(subgraphs, n.Type) {
+				continue
+			}
+			req.addNode(n)
+		}
+	} else {
+		// the API format is a map of node ids to nodes
+		var api map[string]struct {
+			ClassType string                 `json:"class_type"`
+			Inputs    map[string]interface{} `json:"inputs"`
+		}
+		if err := json.Unmarshal(data, &api); err != nil {
+			return nil, fmt.Errorf("not a workflow: %v", err)
+		}
+		for _, n := range api {
+			if n.ClassType == "" {
+				continue
+			}
+			req.addNode(workflowNode{Type: n.ClassType, WidgetsValues: n.Inputs})
+		}
+	}
+
+	sort.Strings(req.NodeTypes)
+	sort.Strings(req.CoreTypes)
+	sort.Slice(req.Models, func(i, j int) bool { return req.Models[i].Name < req.Models[j].Name })
+	return req, nil
+}
+
+func (req *WorkflowRequirements) addNode(n workflowNode) {
+	if n.Type == "" || containsString(virtualNodeTypes, n.Type) {
+		return
+	}
+
+	// recent frontends record the node pack of each node, as a comfy registry id and for packs
+	// outside of the registry as the github repo
+	pack, _ := n.Properties["cnr_id"].(string)
+	repo, _ := n.Properties["aux_id"].(string)
+	if pack == "comfy-core" {
+		if !containsString(req.CoreTypes, n.Type) {
+			req.CoreTypes = append(req.CoreTypes, n.Type)
+		}
+	} else if !containsString(req.NodeTypes, n.Type) {
+		req.NodeTypes = append(req.NodeTypes, n.Type)
+	}
+	if repo != "" && !strings.Contains(repo, "://") {
+		repo = "https://github.com/" + repo
+	}
+	if repo != "" {
+		req.Repos[n.Type] = repo
+	} else if pack != "" && pack != "comfy-core" {
+		req.Packs[n.Type] = pack
+	}
+
+	// the models of a node with their download urls
+	if models, ok := n.Properties["models"].([]interface{}); ok {
+		for _, m := range models {
+			if m, ok := m.(map[string]interface{}); ok {
+				name, _ := m["name"].(string)
+				url, _ := m["url"].(string)
+				directory, _ := m["directory"].(string)
+				req.addModel(WorkflowModel{Name: name, URL: url, Directory: directory})
+			}
+		}
+	}
+
+	var values []interface{}
+	switch v := n.WidgetsValues.(type) {
+	case []interface{}:
+		values = v
+	case map[string]interface{}:
+		for _, value := range v {
+			values = append(values, value)
+		}
+	}
+	for _, v := range values {
+		if s, ok := v.(string); ok && IsModelFile(s) {
+			req.addModel(WorkflowModel{Name: s})
+		}
+	}
+}
+
+func (req *WorkflowRequirements) addModel(m WorkflowModel) {
+	if m.Name == "" {
+		return
+	}
+	for i, have := range req.Models {
+		if have.Name == m.Name {
+			if have.URL == "" {
+				req.Models[i] = m
+			}
+			return
+		}
+	}
+	req.Models = append(req.Models, m)
+}
+
+// IsModelFile returns true if a value names a model file
+func IsModelFile(value string) bool {
+	ext := strings.ToLower(path.Ext(value))
+	return ext != "" && containsString(modelExtensions, ext)
+}
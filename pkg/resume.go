@@ -0,0 +1,202 @@
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// work item states recorded in a ResumeState
+const (
+	WorkItemQueued = "queued"
+	WorkItemDone   = "done"
+)
+
+// ResumeState records the work items of a run that have been queued and finished, so an
+// interrupted run can be restarted without repeating finished work.  Work items are keyed by a
+// WorkItemInput, the input they read.
+type ResumeState struct {
+	Items map[string]*ResumeItem `json:"items"`
+
+	path    string
+	prompts map[string]string // prompt id to work item key
+	mutex   sync.Mutex
+}
+
+// ResumeItem is a work item recorded in a ResumeState
+type ResumeItem struct {
+	Status   string    `json:"status"`
+	PromptID string    `json:"prompt_id,omitempty"`
+	Host     string    `json:"host,omitempty"`
+	Outputs  []string  `json:"outputs,omitempty"`
+	Updated  time.Time `json:"updated"`
+}
+
+// LoadResumeState loads the state file at path, or starts a new state if the file does not exist
+func LoadResumeState(path string) (*ResumeState, error) {
+	state := &ResumeState{
+		Items:   make(map[string]*ResumeItem),
+		path:    path,
+		prompts: make(map[string]string),
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	if state.Items == nil {
+		state.Items = make(map[string]*ResumeItem)
+	}
+	return state, nil
+}
+
+// WorkItemInput records the input a work item reads while its parameters are applied: the
+// parameters, the object of API values, and the values and images read from parameter sources.
+// It is recorded before anything is uploaded, so the same input gets the same key in every run.
+type WorkItemInput struct {
+	hash hash.Hash
+}
+
+// NewWorkItemInput starts the input record of a work item
+func NewWorkItemInput() *WorkItemInput {
+	return &WorkItemInput{hash: sha256.New()}
+}
+
+// Add records a value of the work item's input
+func (in *WorkItemInput) Add(kind string, value []byte) {
+	// the length keeps one value from running into the next
+	fmt.Fprintf(in.hash, "%s %d\n", kind, len(value))
+	in.hash.Write(value)
+}
+
+// AddFrame records an image read from a parameter source, by its original bytes when it has them
+func (in *WorkItemInput) AddFrame(kind string, frame *Frame) error {
+	if frame.Data != nil {
+		in.Add(kind, frame.Data)
+		return nil
+	}
+	fmt.Fprintf(in.hash, "%s image\n", kind)
+	return png.Encode(in.hash, frame.Image)
+}
+
+// Key returns the key of the work item in a ResumeState
+func (in *WorkItemInput) Key() string {
+	return hex.EncodeToString(in.hash.Sum(nil))
+}
+
+// startWorkItemInput starts the input record of the work item being applied, in a resumable run.
+// Each parameter is recorded by its address and its value, once placeholders have been
+// substituted and files read, so work items that differ only in their values get their own key.
+func (o *ComfyOptions) startWorkItemInput(parameters []CLIParameter) error {
+	o.workItemInput = nil
+	if o.Resume == nil {
+		return nil
+	}
+	o.workItemInput = NewWorkItemInput()
+	for _, param := range parameters {
+		// values read from sources are recorded as they are read
+		value, err := param.ResolveValue(false)
+		if err != nil {
+			return err
+		}
+		o.recordInput("parameter", []byte(param.String()))
+		o.recordInput("value", []byte(value))
+	}
+	return nil
+}
+
+// recordInput records a value read for the work item being applied, in a resumable run
+func (o *ComfyOptions) recordInput(kind string, value []byte) {
+	if o.workItemInput != nil {
+		o.workItemInput.Add(kind, value)
+	}
+}
+
+// recordFrame records an image read for the work item being applied, in a resumable run
+func (o *ComfyOptions) recordFrame(kind string, frame *Frame) error {
+	if o.workItemInput == nil {
+		return nil
+	}
+	return o.workItemInput.AddFrame(kind, frame)
+}
+
+// IsDone returns true if the work item has finished in an earlier run
+func (s *ResumeState) IsDone(key string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	item, ok := s.Items[key]
+	return ok && item.Status == WorkItemDone
+}
+
+// Queued records a work item as in flight
+func (s *ResumeState) Queued(key string, promptID string, host string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.prompts[promptID] = key
+	s.Items[key] = &ResumeItem{
+		Status:   WorkItemQueued,
+		PromptID: promptID,
+		Host:     host,
+		Updated:  time.Now(),
+	}
+	return s.save()
+}
+
+// AddOutput records a file saved for a prompt's output
+func (s *ResumeState) AddOutput(promptID string, path string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if item, ok := s.Items[s.prompts[promptID]]; ok {
+		item.Outputs = append(item.Outputs, path)
+	}
+}
+
+// Finish records a prompt's work item as done once its outputs have been handled
+func (s *ResumeState) Finish(promptID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	item, ok := s.Items[s.prompts[promptID]]
+	if !ok {
+		return nil
+	}
+	item.Status = WorkItemDone
+	item.Updated = time.Now()
+	delete(s.prompts, promptID)
+	return s.save()
+}
+
+// save writes the state to a temporary file first, so an interrupted write leaves the previous
+// state intact
+func (s *ResumeState) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,79 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// apply the parameters of a work item and return its key, and true if it finished in an earlier run
+func workItemKey(t *testing.T, options *ComfyOptions, parameters []CLIParameter) (string, bool) {
+	t.Helper()
+	if err := options.startWorkItemInput(parameters); err != nil {
+		t.Fatal(err)
+	}
+	return resumeWorkItem(options)
+}
+
+func TestResumeKeysParameterValues(t *testing.T) {
+	dir := t.TempDir()
+	state, err := LoadResumeState(filepath.Join(dir, "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := &ComfyOptions{Resume: state}
+
+	// two rows of a batch file that differ only in the seed
+	batch := filepath.Join(dir, "jobs.csv")
+	if err := os.WriteFile(batch, []byte("KSampler:seed\n1\n2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	parameters := ParseParameters([]string{"KSampler:seed=0", "Save Image:filename_prefix=out"})
+	jobs, err := ReadBatchFile(batch, parameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("got %d jobs, want 2", len(jobs))
+	}
+
+	first, done := workItemKey(t, options, jobs[0].Apply(parameters))
+	if done {
+		t.Fatal("first job is done before it ran")
+	}
+	if err := state.Queued(first, "p1", "gpu1:8188"); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Finish("p1"); err != nil {
+		t.Fatal(err)
+	}
+
+	second, done := workItemKey(t, options, jobs[1].Apply(parameters))
+	if second == first {
+		t.Fatal("jobs that differ in a parameter value have the same key")
+	}
+	if done {
+		t.Error("second job was skipped after the first finished")
+	}
+	if _, done := workItemKey(t, options, jobs[0].Apply(parameters)); !done {
+		t.Error("first job is not done after it finished")
+	}
+}
+
+func TestResumeKeysFileContents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prompt.txt")
+	options := &ComfyOptions{Resume: &ResumeState{Items: make(map[string]*ResumeItem)}}
+	parameters := ParseParameters([]string{"Prompt:text=@file:" + path})
+
+	var keys []string
+	for _, prompt := range []string{"a cat", "a dog"} {
+		if err := os.WriteFile(path, []byte(prompt), 0644); err != nil {
+			t.Fatal(err)
+		}
+		key, _ := workItemKey(t, options, parameters)
+		keys = append(keys, key)
+	}
+	if keys[0] == keys[1] {
+		t.Error("files with different contents give the same key")
+	}
+}
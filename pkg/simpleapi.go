@@ -0,0 +1,219 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/richinsley/comfy2go/graphapi"
+)
+
+// layout of a created Simple API group
+const (
+	apiGroupWidth     = 340
+	apiGroupTitleSize = 50
+	apiNodeSpacing    = 30
+	primitiveWidth    = 300
+	primitiveHeight   = 82
+)
+
+// GetSimpleAPI returns the Simple API of the graph, merging every group with the title.  The
+// first property of each node in the groups is an API value, or its image upload property.
+func GetSimpleAPI(graph *graphapi.Graph, title string) *graphapi.SimpleAPI {
+	var api *graphapi.SimpleAPI
+	for _, g := range graph.Groups {
+		if g.Title != title {
+			continue
+		}
+		if api == nil {
+			api = &graphapi.SimpleAPI{Properties: make(map[string]graphapi.Property)}
+		}
+		for _, n := range graph.GetNodesInGroup(g) {
+			if n.IsOutput {
+				api.OutputNodes = append(api.OutputNodes, n)
+			}
+			props := n.GetPropertiesByIndex()
+			if len(props) == 0 {
+				continue
+			}
+			api.Properties[n.Title] = props[0]
+			for _, p := range props {
+				if p != nil && p.TypeString() == "IMAGEUPLOAD" {
+					api.Properties[n.Title] = p
+					break
+				}
+			}
+		}
+	}
+	return api
+}
+
+// CreateSimpleAPI adds a Simple API group to the graph.  Each value is given as
+// "name=node:widget", with the node selectors of a parameter.  A Primitive node titled with
+// the name is added to the group, and linked to the widget of each selected node after
+// converting the widget to an input.  The output nodes are moved into the group.  The group
+// is placed to the left of the workflow, and is merged with any API group the workflow has.
+func CreateSimpleAPI(graph *graphapi.Graph, title string, values []string, outputs []string) error {
+	existing := GetSimpleAPI(graph, title)
+	var primitives []*graphapi.GraphNode
+	for _, v := range values {
+		name, address, ok := strings.Cut(v, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("invalid API value %s, expected name=node:widget", v)
+		}
+		if existing != nil && existing.Properties[name] != nil {
+			return fmt.Errorf("the Simple API already has a value %s", name)
+		}
+		for _, p := range primitives {
+			if p.Title == name {
+				return fmt.Errorf("the Simple API value %s is given twice", name)
+			}
+		}
+		primitive, err := addPrimitive(graph, name, address)
+		if err != nil {
+			return fmt.Errorf("API value %s: %w", name, err)
+		}
+		primitives = append(primitives, primitive)
+	}
+
+	var outputNodes []*graphapi.GraphNode
+	for _, o := range outputs {
+		nodes, err := FindNodes(graph, o)
+		if err != nil {
+			return err
+		}
+		for _, n := range nodes {
+			if !n.IsOutput {
+				return fmt.Errorf("node %s is not an output node", o)
+			}
+			if n.Title == "" {
+				// output nodes are selected by title
+				n.Title = n.DisplayName
+			}
+			outputNodes = append(outputNodes, n)
+		}
+	}
+
+	// stack the nodes in a group to the left of the workflow
+	minX, minY, _, _ := graphBounds(graph)
+	width := float64(apiGroupWidth)
+	for _, n := range outputNodes {
+		width = max(width, n.Size.Width+2*apiNodeSpacing)
+	}
+	x := minX - composeMargin - width
+	y := minY + apiGroupTitleSize
+	for _, n := range append(primitives, outputNodes...) {
+		n.Position = []interface{}{x + apiNodeSpacing, y}
+		y += n.Size.Height + apiNodeSpacing
+	}
+	graph.Groups = append(graph.Groups, &graphapi.Group{
+		Title:    title,
+		Bounding: []int{int(x), int(minY), int(width), int(y - minY)},
+		Color:    "#3f789e",
+	})
+	return nil
+}
+
+// addPrimitive adds a Primitive node linked to the widgets a "node:widget" address selects
+func addPrimitive(graph *graphapi.Graph, name string, address string) (*graphapi.GraphNode, error) {
+	p, err := ParseParameter(address + "=")
+	if err != nil {
+		return nil, err
+	}
+	if p.API {
+		return nil, fmt.Errorf("expected node:widget, got %s", address)
+	}
+	nodes, err := p.Nodes(graph)
+	if err != nil {
+		return nil, err
+	}
+
+	// the widgets linked to a primitive share its type
+	var first graphapi.Property
+	for _, n := range nodes {
+		prop := n.GetPropertyWithName(p.Name)
+		if prop == nil || !prop.Settable() {
+			return nil, fmt.Errorf("node %d has no widget %s", n.ID, p.Name)
+		}
+		switch prop.TypeString() {
+		case "IMAGEUPLOAD", "CASCADE":
+			return nil, fmt.Errorf("%s widgets can't be converted to inputs", prop.TypeString())
+		}
+		if first == nil {
+			first = prop
+		} else if prop.TypeString() != first.TypeString() {
+			return nil, fmt.Errorf("widget %s of node %d is %s, not %s", p.Name, n.ID, prop.TypeString(), first.TypeString())
+		}
+		if slot := n.GetInputWithName(p.Name); slot != nil && slot.Link != 0 {
+			return nil, fmt.Errorf("input %s of node %d is already linked", p.Name, n.ID)
+		}
+	}
+
+	widget := first.Name()
+	slotType := first.TypeString()
+	graph.LastNodeID++
+	primitive := &graphapi.GraphNode{
+		ID:    graph.LastNodeID,
+		Type:  "PrimitiveNode",
+		Size:  graphapi.Size{Width: primitiveWidth, Height: primitiveHeight},
+		Title: name,
+		Graph: graph,
+	}
+	flags := interface{}(map[string]interface{}{})
+	primitive.Flags = &flags
+	properties := map[string]interface{}{"Run widget replace on values": false}
+	primitive.InternalProperties = &properties
+
+	widgetValues := []interface{}{first.GetValue()}
+	if widget == "seed" || widget == "noise_seed" {
+		// the primitive takes over the control of the seed
+		control := interface{}("fixed")
+		if c := nodes[0].GetPropertyWithName("control_after_generate"); c != nil {
+			control = c.GetValue()
+		}
+		widgetValues = append(widgetValues, control)
+	}
+	primitive.WidgetValues = widgetValues
+
+	links := make([]int, 0)
+	for _, n := range nodes {
+		graph.LastLinkID++
+		slot := slotWithName(n.Inputs, widget)
+		if slot == -1 {
+			// convert the widget to an input
+			n.Inputs = append(n.Inputs, graphapi.Slot{Name: widget, Type: slotType})
+			slot = len(n.Inputs) - 1
+		}
+		if n.Inputs[slot].Widget == nil {
+			wname := widget
+			n.Inputs[slot].Widget = &graphapi.Widget{Name: &wname}
+		}
+		n.Inputs[slot].Link = graph.LastLinkID
+
+		link := &graphapi.Link{
+			ID:         graph.LastLinkID,
+			OriginID:   primitive.ID,
+			OriginSlot: 0,
+			TargetID:   n.ID,
+			TargetSlot: slot,
+			Type:       slotType,
+		}
+		graph.Links = append(graph.Links, link)
+		graph.LinksByID[link.ID] = link
+		links = append(links, link.ID)
+	}
+
+	index := 0
+	wname := widget
+	primitive.Outputs = []graphapi.Slot{{
+		Name:      slotType,
+		Type:      slotType,
+		Links:     &links,
+		Widget:    &graphapi.Widget{Name: &wname},
+		SlotIndex: &index,
+	}}
+
+	graph.Nodes = append(graph.Nodes, primitive)
+	graph.NodesByID[primitive.ID] = primitive
+	graph.NodesInExecutionOrder = append(graph.NodesInExecutionOrder, primitive)
+	return primitive, nil
+}
@@ -0,0 +1,257 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ParameterSource supplies a parameter with a new value for each work item of a pipe loop.
+// Image upload parameters read images from stdin with a value of "-".  Parameters reference
+// other sources with one of:
+//
+//	@fifo:<path>   images or lines read from a named pipe
+//	@dir:<path>    the files of a directory in name order
+//	@lines:<path>  the lines of a text file ("-" for stdin)
+//
+// All sources are read in lockstep, one value per parameter for each work item, and the pipe
+// loop ends when any source runs dry.
+type ParameterSource interface {
+	// NextFrame returns the next image, for image upload properties
+	NextFrame() (*Frame, error)
+	// NextValue returns the next value, for all other properties
+	NextValue() (string, error)
+}
+
+// IsSourceSpec returns true if a parameter value references a ParameterSource
+func IsSourceSpec(value string) bool {
+	if value == "-" {
+		return true
+	}
+	for _, prefix := range []string{"@fifo:", "@dir:", "@lines:"} {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetSource returns the source for a spec, opening it on first use.  Parameters with the same
+// spec share a source.  A plain path to a named pipe is the same as "@fifo:<path>"
+func (o *ComfyOptions) GetSource(spec string) (ParameterSource, error) {
+	if isNamedPipe(spec) {
+		spec = "@fifo:" + spec
+	}
+	if s, ok := o.sources[spec]; ok {
+		return s, nil
+	}
+
+	var source ParameterSource
+	switch {
+	case spec == "-":
+		source = &streamSource{r: o.GetStdinReader(), frames: o.GetFrameReader()}
+	case strings.HasPrefix(spec, "@fifo:"):
+		f, err := os.Open(strings.TrimPrefix(spec, "@fifo:"))
+		if err != nil {
+			return nil, err
+		}
+		r := bufio.NewReader(f)
+		source = &streamSource{r: r, frames: NewFrameReader(r)}
+	case strings.HasPrefix(spec, "@dir:"):
+		files, err := ListFiles(strings.TrimPrefix(spec, "@dir:"), true, false)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+		source = &dirSource{files: files}
+	case strings.HasPrefix(spec, "@lines:"):
+		path := strings.TrimPrefix(spec, "@lines:")
+		var r *bufio.Reader
+		if path == "-" {
+			r = o.GetStdinReader()
+		} else {
+			f, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			r = bufio.NewReader(f)
+		}
+		source = &linesSource{r: r}
+	default:
+		return nil, fmt.Errorf("unknown parameter source %s", spec)
+	}
+
+	if o.replay != nil {
+		source = &replaySource{source: source}
+	}
+	if o.sources == nil {
+		o.sources = make(map[string]ParameterSource)
+	}
+	o.sources[spec] = source
+	return source, nil
+}
+
+// streamSource reads images, or lines for other properties, from stdin or a named pipe
+type streamSource struct {
+	r      *bufio.Reader
+	frames *FrameReader
+}
+
+func (s *streamSource) NextFrame() (*Frame, error) {
+	frame, err := s.frames.Next()
+	if err == io.EOF {
+		return nil, ErrEndOfInput
+	}
+	return frame, err
+}
+
+func (s *streamSource) NextValue() (string, error) {
+	return readLine(s.r)
+}
+
+// dirSource supplies the files of a directory
+type dirSource struct {
+	files []string
+	next  int
+}
+
+func (s *dirSource) nextFile() (string, error) {
+	if s.next >= len(s.files) {
+		return "", ErrEndOfInput
+	}
+	s.next++
+	return s.files[s.next-1], nil
+}
+
+func (s *dirSource) NextFrame() (*Frame, error) {
+	path, err := s.nextFile()
+	if err != nil {
+		return nil, err
+	}
+	return readImageFile(path)
+}
+
+// NextValue returns the path of the next file
+func (s *dirSource) NextValue() (string, error) {
+	return s.nextFile()
+}
+
+// linesSource supplies the non-empty lines of a text file
+type linesSource struct {
+	r *bufio.Reader
+}
+
+// NextFrame reads the image file named by the next line
+func (s *linesSource) NextFrame() (*Frame, error) {
+	path, err := readLine(s.r)
+	if err != nil {
+		return nil, err
+	}
+	return readImageFile(path)
+}
+
+func (s *linesSource) NextValue() (string, error) {
+	return readLine(s.r)
+}
+
+// inputReplay reads the values of parameter sources and the API values once, while the
+// parameters are applied to the first host, and replays them in the same order for the others
+type inputReplay struct {
+	apivalues []map[string]interface{}
+	next      int
+}
+
+// rewind replays the inputs from the start, for the next host
+func (r *inputReplay) rewind(o *ComfyOptions) {
+	r.next = 0
+	for _, s := range o.sources {
+		if rs, ok := s.(*replaySource); ok {
+			rs.nextFrame, rs.nextValue = 0, 0
+		}
+	}
+}
+
+// nextAPIValues returns the next API values read, reading them on first use
+func (r *inputReplay) nextAPIValues(read func() (map[string]interface{}, error)) (map[string]interface{}, error) {
+	if r.next == len(r.apivalues) {
+		apivalues, err := read()
+		if err != nil {
+			return nil, err
+		}
+		r.apivalues = append(r.apivalues, apivalues)
+	}
+	r.next++
+	return r.apivalues[r.next-1], nil
+}
+
+// replaySource keeps the frames and values read from a source to replay them
+type replaySource struct {
+	source    ParameterSource
+	frames    []*Frame
+	values    []string
+	nextFrame int
+	nextValue int
+}
+
+func (s *replaySource) NextFrame() (*Frame, error) {
+	if s.nextFrame == len(s.frames) {
+		frame, err := s.source.NextFrame()
+		if err != nil {
+			return nil, err
+		}
+		s.frames = append(s.frames, frame)
+	}
+	s.nextFrame++
+	return s.frames[s.nextFrame-1], nil
+}
+
+func (s *replaySource) NextValue() (string, error) {
+	if s.nextValue == len(s.values) {
+		value, err := s.source.NextValue()
+		if err != nil {
+			return "", err
+		}
+		s.values = append(s.values, value)
+	}
+	s.nextValue++
+	return s.values[s.nextValue-1], nil
+}
+
+// readLine returns the next non-empty line, without its line ending
+func readLine(r *bufio.Reader) (string, error) {
+	for {
+		line, err := r.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			return line, nil
+		}
+		if err == io.EOF {
+			return "", ErrEndOfInput
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// readImageFile reads an image file, keeping its original bytes
+func readImageFile(path string) (*Frame, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	format := ImageFormat(data)
+	if format == "" {
+		return nil, fmt.Errorf("%s is not a supported image", path)
+	}
+	return &Frame{Format: format, Data: data}, nil
+}
+
+// isNamedPipe returns true if path is a named pipe
+func isNamedPipe(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode()&os.ModeNamedPipe != 0
+}
@@ -0,0 +1,254 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"os"
+	"strings"
+	"sync"
+
+	sixel "github.com/mattn/go-sixel"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+	"golang.org/x/term"
+)
+
+// terminal image protocols
+const (
+	ImageProtocolITerm = "iterm" // iTerm2 Inline Images Protocol (OSC 1337)
+	ImageProtocolSixel = "sixel"
+	ImageProtocolKitty = "kitty" // kitty graphics protocol
+	ImageProtocolANSI  = "ansi"  // 24 bit color half blocks for terminals without graphics
+)
+
+// fallback cell size in pixels when the terminal does not report its pixel dimensions
+const (
+	defaultCellWidth  = 10
+	defaultCellHeight = 20
+)
+
+var (
+	detectedProtocol     string
+	detectedProtocolOnce sync.Once
+)
+
+// ResolveImageProtocol maps an --image-protocol value to a terminal image protocol.
+// "auto" (or an empty string) detects the protocol the terminal supports, and "inline"
+// is accepted as an alias for "iterm"
+func ResolveImageProtocol(name string) (string, error) {
+	switch strings.ToLower(name) {
+	case "", "auto":
+		detectedProtocolOnce.Do(func() {
+			detectedProtocol = DetectImageProtocol()
+		})
+		return detectedProtocol, nil
+	case "inline", ImageProtocolITerm:
+		return ImageProtocolITerm, nil
+	case ImageProtocolSixel, ImageProtocolKitty, ImageProtocolANSI:
+		return strings.ToLower(name), nil
+	}
+	return "", fmt.Errorf("unknown image protocol %q (expected auto, iterm, sixel, kitty or ansi)", name)
+}
+
+// DetectImageProtocol determines the best image protocol for the terminal on stdout from the
+// environment, falling back to querying the terminal.  Terminals without graphics get "ansi"
+func DetectImageProtocol() string {
+	termname := os.Getenv("TERM")
+	program := os.Getenv("TERM_PROGRAM")
+
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "" || termname == "xterm-kitty" || program == "ghostty":
+		return ImageProtocolKitty
+	case program == "iTerm.app" || program == "WezTerm" || os.Getenv("LC_TERMINAL") == "iTerm2":
+		return ImageProtocolITerm
+	case strings.Contains(termname, "sixel") || strings.HasPrefix(termname, "foot") || strings.HasPrefix(termname, "mlterm"):
+		return ImageProtocolSixel
+	}
+
+	// ask the terminal.  A kitty graphics query is answered with "_Gi=31;OK" by terminals that
+	// support it, and attribute 4 in the primary device attributes response means sixel support.
+	// The trailing device attributes query is answered by every terminal, so we know when to stop.
+	response := queryTerminal("\033_Gi=31,s=1,v=1,a=q,t=d,f=24;AAAA\033\\\033[c", 'c')
+	if strings.Contains(response, "_Gi=31;OK") {
+		return ImageProtocolKitty
+	}
+	if da := strings.LastIndex(response, "\033[?"); da >= 0 {
+		for _, attr := range strings.Split(strings.TrimSuffix(response[da+3:], "c"), ";") {
+			if attr == "4" {
+				return ImageProtocolSixel
+			}
+		}
+	}
+	return ImageProtocolANSI
+}
+
+// terminalSize returns the size of the terminal on stdout in cells along with the size of a
+// cell in pixels
+func terminalSize() (cols int, rows int, cellWidth int, cellHeight int) {
+	cols, rows, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || cols <= 0 || rows <= 0 {
+		cols, rows = 80, 24
+	}
+	cellWidth, cellHeight = defaultCellWidth, defaultCellHeight
+	if pw, ph := terminalPixelSize(); pw > 0 && ph > 0 {
+		cellWidth, cellHeight = pw/cols, ph/rows
+	}
+	return cols, rows, cellWidth, cellHeight
+}
+
+// fitCells returns the number of cells needed to show an image at its native size, scaled down
+// to fit within maxCols x maxRows while preserving the aspect ratio
+func fitCells(width int, height int, cellWidth int, cellHeight int, maxCols int, maxRows int) (int, int) {
+	cols := (width + cellWidth - 1) / cellWidth
+	rows := (height + cellHeight - 1) / cellHeight
+	if maxCols > 0 && cols > maxCols {
+		rows = rows * maxCols / cols
+		cols = maxCols
+	}
+	if maxRows > 0 && rows > maxRows {
+		cols = cols * maxRows / rows
+		rows = maxRows
+	}
+	return max(cols, 1), max(rows, 1)
+}
+
+// FormatTerminalImage encodes image data for display with the given protocol, fitted within
+// maxCols x maxRows cells.  A max of 0 uses the terminal's size.  The cursor is left at the
+// end of the image without a trailing newline
+func FormatTerminalImage(data []byte, name string, protocol string, maxCols int, maxRows int) (string, error) {
+	termcols, termrows, cellWidth, cellHeight := terminalSize()
+	if maxCols <= 0 || maxCols > termcols {
+		maxCols = termcols
+	}
+	if maxRows <= 0 {
+		// leave room for the prompt below the image
+		maxRows = max(termrows-2, 1)
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	cols, rows := fitCells(config.Width, config.Height, cellWidth, cellHeight, maxCols, maxRows)
+
+	switch protocol {
+	case ImageProtocolITerm:
+		encoded_data := base64.StdEncoding.EncodeToString(data)
+		encoded_name := base64.StdEncoding.EncodeToString([]byte(name))
+		return fmt.Sprintf("\033]1337;File=inline=1;size=%d;name=%s;width=%d;height=%d;preserveAspectRatio=1:%s\a", len(data), encoded_name, cols, rows, encoded_data), nil
+	case ImageProtocolKitty:
+		return formatKittyImage(data, cols, rows)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+
+	switch protocol {
+	case ImageProtocolSixel:
+		var buf bytes.Buffer
+		err = sixel.NewEncoder(&buf).Encode(resizeImage(img, cols*cellWidth, rows*cellHeight))
+		return buf.String(), err
+	case ImageProtocolANSI:
+		// each cell holds two vertically stacked pixels and is roughly twice as tall as it is wide
+		cols, rows = fitCells(config.Width, config.Height, 1, 2, maxCols, maxRows)
+		return formatHalfBlockImage(resizeImage(img, cols, rows*2)), nil
+	}
+	return "", fmt.Errorf("unknown image protocol %q", protocol)
+}
+
+// OutputImageToStd writes image data to the terminal with the given protocol, scaled to fit
+// the terminal
+func OutputImageToStd(data *[]byte, name string, protocol string) error {
+	protocol, err := ResolveImageProtocol(protocol)
+	if err != nil {
+		return err
+	}
+	out, err := FormatTerminalImage(*data, name, protocol, 0, 0)
+	if err != nil {
+		return err
+	}
+	os.Stdout.WriteString(out + "\n")
+	return nil
+}
+
+// formatKittyImage transmits a PNG in chunks with the kitty graphics protocol
+func formatKittyImage(data []byte, cols int, rows int) (string, error) {
+	if !bytes.HasPrefix(data, []byte("\x89PNG")) {
+		// kitty only accepts PNG or raw pixel data
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return "", err
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return "", err
+		}
+		data = buf.Bytes()
+	}
+
+	const chunkSize = 4096
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var sb strings.Builder
+	for i := 0; i < len(encoded); i += chunkSize {
+		end := min(i+chunkSize, len(encoded))
+		more := 0
+		if end < len(encoded) {
+			more = 1
+		}
+		if i == 0 {
+			sb.WriteString(fmt.Sprintf("\033_Ga=T,f=100,q=2,c=%d,r=%d,m=%d;%s\033\\", cols, rows, more, encoded[i:end]))
+		} else {
+			sb.WriteString(fmt.Sprintf("\033_Gm=%d;%s\033\\", more, encoded[i:end]))
+		}
+	}
+	return sb.String(), nil
+}
+
+// formatHalfBlockImage renders an image with upper half block characters, using the
+// foreground color for the top pixel and the background color for the bottom pixel
+func formatHalfBlockImage(img image.Image) string {
+	bounds := img.Bounds()
+	var sb strings.Builder
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += 2 {
+		if y > bounds.Min.Y {
+			sb.WriteString("\n")
+		}
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			top := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			bottom := color.RGBA{}
+			if y+1 < bounds.Max.Y {
+				bottom = color.RGBAModel.Convert(img.At(x, y+1)).(color.RGBA)
+			}
+			sb.WriteString(fmt.Sprintf("\033[38;2;%d;%d;%dm\033[48;2;%d;%d;%dm▀", top.R, top.G, top.B, bottom.R, bottom.G, bottom.B))
+		}
+		sb.WriteString("\033[0m")
+	}
+	return sb.String()
+}
+
+// resizeImage scales an image down with nearest neighbor sampling to fit within width x height
+func resizeImage(img image.Image, width int, height int) image.Image {
+	bounds := img.Bounds()
+	if bounds.Dx() <= width && bounds.Dy() <= height {
+		return img
+	}
+	scale := min(float64(width)/float64(bounds.Dx()), float64(height)/float64(bounds.Dy()))
+	w, h := max(int(float64(bounds.Dx())*scale), 1), max(int(float64(bounds.Dy())*scale), 1)
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		sy := bounds.Min.Y + y*bounds.Dy()/h
+		for x := 0; x < w; x++ {
+			dst.Set(x, y, img.At(bounds.Min.X+x*bounds.Dx()/w, sy))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,67 @@
+//go:build !windows
+
+package pkg
+
+import (
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+// terminalPixelSize returns the size of the terminal on stdout in pixels, or 0 if unknown
+func terminalPixelSize() (int, int) {
+	ws, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return 0, 0
+	}
+	return int(ws.Xpixel), int(ws.Ypixel)
+}
+
+// queryTerminal writes a query to the terminal and reads the response up to and including
+// the terminator.  An empty string is returned if stdin/stdout are not a terminal or the
+// terminal does not answer in time
+func queryTerminal(query string, terminator byte) string {
+	in, out := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !term.IsTerminal(in) || !term.IsTerminal(out) {
+		return ""
+	}
+
+	state, err := term.MakeRaw(in)
+	if err != nil {
+		return ""
+	}
+	defer term.Restore(in, state)
+
+	if _, err := os.Stdout.WriteString(query); err != nil {
+		return ""
+	}
+
+	// poll stdin so that a terminal that never answers does not leave a pending read
+	var response []byte
+	buf := make([]byte, 256)
+	deadline := time.Now().Add(250 * time.Millisecond)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return string(response)
+		}
+		fds := []unix.PollFd{{Fd: int32(in), Events: unix.POLLIN}}
+		n, err := unix.Poll(fds, int(remaining.Milliseconds())+1)
+		if err != nil && err != unix.EINTR {
+			return string(response)
+		}
+		if n <= 0 {
+			continue
+		}
+		count, err := unix.Read(in, buf)
+		if err != nil || count <= 0 {
+			return string(response)
+		}
+		response = append(response, buf[:count]...)
+		if response[len(response)-1] == terminator {
+			return string(response)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build windows
+
+package pkg
+
+// terminalPixelSize is not available on windows consoles
+func terminalPixelSize() (int, int) {
+	return 0, 0
+}
+
+// queryTerminal is not supported on windows consoles
+func queryTerminal(query string, terminator byte) string {
+	return ""
+}
@@ -1,18 +1,14 @@
 package pkg
 
 import (
-	"bytes"
-	"encoding/base64"
 	"encoding/json"
-	"image"
+	"errors"
 	"io/fs"
 	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"path/filepath"
-	"regexp"
-	"strconv"
 	"strings"
 
 	"bufio"
@@ -20,7 +16,6 @@ import (
 	"io"
 
 	"github.com/go-git/go-git/v5"
-	sixel "github.com/mattn/go-sixel"
 	"github.com/richinsley/comfy2go/client"
 	"github.com/richinsley/comfy2go/graphapi"
 	kinda "github.com/richinsley/kinda/pkg"
@@ -43,49 +38,6 @@ func ToJson(obj interface{}, purty bool) (string, error) {
 	}
 }
 
-type CLIParameter struct {
-	NodeID    int    // -1 for unset
-	NodeTitle string // empty for unset
-	API       bool   // is true if the parameter is an API parameter
-	Name      string // the name of the parameter
-	Value     string // the value of the parameter
-}
-
-func ParseParameters(params []string) []CLIParameter {
-	var parsedParams []CLIParameter
-	// Update the regex to match the full structure of parameters
-	re := regexp.MustCompile(`^(?:(?:\((\d+)\))|([^:=]+):)?([^=]+)=(.+)$`)
-
-	for _, param := range params {
-		matches := re.FindStringSubmatch(param)
-		if matches != nil {
-			var cliParam CLIParameter
-			if matches[1] != "" { // NodeID present
-				nodeID, _ := strconv.Atoi(matches[1])
-				cliParam = CLIParameter{
-					NodeID: nodeID,
-					API:    false,
-				}
-			} else if matches[2] != "" { // NodeTitle present
-				cliParam = CLIParameter{
-					NodeTitle: matches[2],
-					API:       false,
-					NodeID:    -1,
-				}
-			} else { // API parameter
-				cliParam = CLIParameter{
-					API:    true,
-					NodeID: -1,
-				}
-			}
-			cliParam.Name = matches[3]
-			cliParam.Value = matches[4]
-			parsedParams = append(parsedParams, cliParam)
-		}
-	}
-	return parsedParams
-}
-
 func SaveData(data *[]byte, path string) error {
 	f, err := os.Create(path)
 	if err != nil {
@@ -109,31 +61,6 @@ func LoadData(path string) (*[]byte, error) {
 	return &data, nil
 }
 
-func outputSixelImageToStd(data *[]byte) {
-	// create an io.reader from the data bytes
-	r := bytes.NewReader(*data)
-	img, _, _ := image.Decode(r)
-	sixel.NewEncoder(os.Stdout).Encode(img)
-}
-
-func outputInlineImageToStd(data *[]byte, name string, width int, height int) string {
-	encoded_data := base64.StdEncoding.EncodeToString(*data)
-	encoded_name := base64.StdEncoding.EncodeToString([]byte(name))
-	sizestr := fmt.Sprintf("size=%d;", len(*data))
-	namestr := fmt.Sprintf("name=%s;", encoded_name)
-	dimstr := ""
-	if width > 0 && height > 0 {
-		dimstr = fmt.Sprintf("width=%dpx;height=%dpx;", width, height)
-	}
-	retv := fmt.Sprintf("\033]1337;File=inline=1;%s%s%spreserveAspectRatio=1:%s\a\n", sizestr, namestr, dimstr, encoded_data)
-
-	return retv
-}
-
-func OutputInlineToStd(data *[]byte, name string, width int, height int) {
-	os.Stdout.WriteString(outputInlineImageToStd(data, name, width, height))
-}
-
 type Workflow struct {
 	ClientIndex int
 	Client      *client.ComfyClient
@@ -183,7 +110,22 @@ func GetFullWorkflow(client_index int, options *ComfyOptions, workflow string, c
 		return nil, missing, err
 	}
 
-	simple_api := g.GetSimpleAPI(&options.API)
+	// previews require taking over the client's websocket
+	if options.WantsPreviews() {
+		if options.PreviewSockets == nil {
+			options.PreviewSockets = make([]*PreviewSocket, len(options.Host))
+		}
+		if options.PreviewSockets[client_index] == nil {
+			ps, err := NewPreviewSocket(c, clientaddr, clientport)
+			if err != nil {
+				slog.Warn("Failed to connect preview socket", "error", err)
+			} else {
+				options.PreviewSockets[client_index] = ps
+			}
+		}
+	}
+
+	simple_api := GetSimpleAPI(g, options.API)
 
 	// return the client and the graph
 	return &Workflow{
@@ -198,6 +140,16 @@ func setPropertValue(client *client.ComfyClient, options *ComfyOptions, prop gra
 	var readFromPipe bool = false
 	var err error = nil
 
+	// values read from a source are set as is, image uploads read their own images.  Only image
+	// uploads read stdin with "-", other properties read its lines with "@lines:-"
+	if spec, ok := value.(string); ok && spec != "-" && IsSourceSpec(spec) && prop.TypeString() != "IMAGEUPLOAD" {
+		if value, err = readSourceValue(options, spec); err != nil {
+			return false, err
+		}
+		_, err = SetGenericPropertValue(client, options, prop, value)
+		return true, err
+	}
+
 	switch prop.TypeString() {
 	// "INT"			an int64
 	// "FLOAT"			a float64
@@ -230,22 +182,40 @@ func setPropertValue(client *client.ComfyClient, options *ComfyOptions, prop gra
 	return readFromPipe, err
 }
 
+// readSourceValue reads the next value from a parameter source
+func readSourceValue(options *ComfyOptions, spec string) (string, error) {
+	source, err := options.GetSource(spec)
+	if err != nil {
+		return "", err
+	}
+	value, err := source.NextValue()
+	if err != nil {
+		return "", err
+	}
+	options.recordInput(spec, []byte(value))
+	return value, nil
+}
+
 func TestParametersHasPipeLoop(options *ComfyOptions, parameters []CLIParameter) (bool, error) {
 	retv := false
 	pipedparamcount := 0
 	for _, param := range parameters {
-		if param.Value == "-" {
+		if param.Value == "-" || param.Value == "@lines:-" {
 			pipedparamcount += 1
 		}
+		if isPipeSource(param.Value) {
+			// parameters reading from a source get a new value for each work item
+			retv = true
+		}
 	}
+
+	// parameters reading from stdin would take turns reading the same stream
 	if pipedparamcount > 1 {
-		return true, fmt.Errorf("only one parameter can read from stdin, use SimpleAPI for more")
-	} else if pipedparamcount == 1 {
-		retv = true
+		return false, fmt.Errorf("only one parameter can read from stdin")
 	}
 
 	if options.APIValues != "" {
-		if retv {
+		if pipedparamcount > 0 {
 			return true, fmt.Errorf("APIValues and parameters reading from stdin cannot be used together")
 		}
 		if options.APIValues != "-" {
@@ -265,34 +235,38 @@ func TestParametersHasPipeLoop(options *ComfyOptions, parameters []CLIParameter)
 	return retv, nil
 }
 
+// ErrEndOfInput is returned when a parameter reading from a pipe has no more input
+var ErrEndOfInput = errors.New("no more input to read")
+
 func ApplyParameters(client *client.ComfyClient, options *ComfyOptions, graph *graphapi.Graph, simple_api *graphapi.SimpleAPI, parameters []CLIParameter) (bool, error) {
 	// if we encounter any read from stdin, we need to set hasPipeLoop to true
 	hasPipeLoop := false
 
+	// a resumable run keys the work item on the input it reads
+	if err := options.startWorkItemInput(parameters); err != nil {
+		return false, err
+	}
+
 	// if APIValues is defined, load the values as a map[string]interface{} and apply those first
 	if options.APIValues != "" {
 		if simple_api == nil {
 			return false, fmt.Errorf("apivalues specified but no SimpleAPI provided or found in the graph")
 		}
 
-		// if the APIValues is set, try to read the values from stdin or the file
-		var apivalues map[string]interface{} = nil
-		if options.APIValues != "" {
-			// prevent concurrent access to the scanner
-			options.JsonScannerMutex.Lock()
-			jobj, scanner, err := ScanJsonFromReader(options.GetStdinReader(), options.JsonScanner)
-			options.JsonScanner = scanner
-			options.JsonScannerMutex.Unlock()
-
+		// read the next object of API values from stdin or the file
+		apivalues, err := options.nextAPIValues()
+		if err != nil {
+			return false, err
+		}
+		if options.workItemInput != nil {
+			// the keys are marshaled in order
+			data, err := json.Marshal(apivalues)
 			if err != nil {
 				return false, err
 			}
-			if jobj == nil {
-				return false, fmt.Errorf("no JSON object found in the input")
-			}
-			hasPipeLoop = true
-			apivalues = jobj.(map[string]interface{})
+			options.recordInput("apivalues", data)
 		}
+		hasPipeLoop = true
 
 		// if there are api values, apply them first
 		for k, v := range apivalues {
@@ -312,79 +286,150 @@ func ApplyParameters(client *client.ComfyClient, options *ComfyOptions, graph *g
 	}
 
 	// apply the parameters to the graph
+	type maskParameter struct {
+		prop  graphapi.Property
+		value interface{}
+	}
+	var masks []maskParameter
 	for _, param := range parameters {
 		if param.API {
+			if simple_api == nil {
+				return false, fmt.Errorf("parameter %s needs a Simple API, none was found in the graph", param)
+			}
 			if prop, okparam := simple_api.Properties[param.Name]; okparam {
-				var err error
-				pl, err := setPropertValue(client, options, prop, param.Value)
+				value, err := param.ResolveValue(prop.TypeString() == "IMAGEUPLOAD")
+				if err != nil {
+					return false, err
+				}
+				pl, err := setPropertValue(client, options, prop, value)
 				if err != nil {
 					return false, err
 				}
 				hasPipeLoop = hasPipeLoop || pl
+			} else if param.Type != "" {
+				slog.Error(fmt.Sprintf("Property %s not found in the SimpleAPI, set a node property named %s with %s:%s:%s", param.Name, param.Type, param.Name, param.Type, param.Type))
 			} else {
 				slog.Error(fmt.Sprintf("Property %s not found in the SimpleAPI", param.Name))
 			}
 
 		} else {
-			var node *graphapi.GraphNode = nil
-			if param.NodeID != -1 {
-				node = graph.GetNodeById(param.NodeID)
-			} else {
-				node = graph.GetFirstNodeWithTitle(param.NodeTitle)
-			}
-			if node == nil {
-				return false, fmt.Errorf("node %v not found in graph", param.NodeTitle)
-			}
-			// get the property interface for param.Name
-			prop := node.GetPropertyWithName(param.Name)
-			if prop == nil {
-				return false, fmt.Errorf("property %v not found in node %v", param.Name, param.NodeTitle)
-			}
-
-			var err error
-			hasPipeLoop, err = setPropertValue(client, options, prop, param.Value)
+			nodes, err := param.Nodes(graph)
 			if err != nil {
 				return false, err
 			}
+
+			var value *string = nil
+			var mask interface{} = nil
+			// the name of the image uploaded for the first node, shared with the other nodes
+			uploaded := ""
+			for _, node := range nodes {
+				// get the property interface for param.Name
+				prop := node.GetPropertyWithName(param.Name)
+				if prop == nil && param.Name == "mask" {
+					// masks are applied to the node's image once it has been uploaded
+					if upload := node.GetPropertyWithName("choose file to upload"); upload != nil {
+						if mask == nil {
+							m, err := param.ResolveValue(true)
+							if err != nil {
+								return false, err
+							}
+							mask = m
+							if len(nodes) > 1 && isPipeSource(m) {
+								// every node gets the same mask read from the source
+								if mask, err = readPipeSource(options, m); err != nil {
+									return false, err
+								}
+								hasPipeLoop = true
+							}
+						}
+						masks = append(masks, maskParameter{prop: upload, value: mask})
+						continue
+					}
+				}
+				if prop == nil {
+					return false, fmt.Errorf("property %v not found in node %v (%d)", param.Name, param, node.ID)
+				}
+
+				if uploaded != "" {
+					// every node gets the image read and uploaded for the first node
+					if uploadprop, ok := prop.ToImageUploadProperty(); ok {
+						uploadprop.SetFilename(uploaded)
+						continue
+					}
+				}
+
+				if value == nil {
+					v, err := param.ResolveValue(prop.TypeString() == "IMAGEUPLOAD")
+					if err != nil {
+						return false, err
+					}
+					if len(nodes) > 1 && IsSourceSpec(v) && prop.TypeString() != "IMAGEUPLOAD" {
+						// every node gets the same value read from the source
+						if v, err = readSourceValue(options, v); err != nil {
+							return false, err
+						}
+						hasPipeLoop = true
+					}
+					value = &v
+				}
+
+				pl, err := setPropertValue(client, options, prop, *value)
+				if err != nil {
+					return false, err
+				}
+				hasPipeLoop = hasPipeLoop || pl
+				if uploadprop, ok := prop.ToImageUploadProperty(); ok && uploadprop.TargetProperty != nil && len(nodes) > 1 {
+					uploaded, _ = uploadprop.TargetProperty.GetValue().(string)
+				}
+			}
+		}
+	}
+
+	for _, mask := range masks {
+		pl, err := SetMaskUploadPropertyValue(client, options, mask.prop, mask.value)
+		if err != nil {
+			return false, err
 		}
+		hasPipeLoop = hasPipeLoop || pl
 	}
 	return hasPipeLoop, nil
 }
 
-func ReadAndDeserializeJSON(dst interface{}, jsonInput string) error {
-	decoder := json.NewDecoder(strings.NewReader(jsonInput))
-	if err := decoder.Decode(dst); err != nil {
-		return fmt.Errorf("error decoding JSON: %w", err)
+// nextAPIValues reads the next object of API values, skipping malformed values.  ErrEndOfInput
+// is returned at the end of the stream.
+func (o *ComfyOptions) nextAPIValues() (map[string]interface{}, error) {
+	if o.replay != nil {
+		return o.replay.nextAPIValues(o.readAPIValues)
 	}
-	return nil
+	return o.readAPIValues()
 }
 
-func ScanJsonFromReader(r io.Reader, scanner *bufio.Scanner) (interface{}, *bufio.Scanner, error) {
-	if scanner == nil {
-		scanner = bufio.NewScanner(r)
+func (o *ComfyOptions) readAPIValues() (map[string]interface{}, error) {
+	// prevent concurrent access to the stream
+	o.JsonStreamMutex.Lock()
+	defer o.JsonStreamMutex.Unlock()
+	if o.JsonStream == nil {
+		o.JsonStream = NewJsonStream(o.GetStdinReader())
 	}
-	var jsonBlock strings.Builder
-
-	var jobj interface{}
-	for scanner.Scan() {
-		line := scanner.Text()
-		jsonBlock.WriteString(line)
 
-		// Attempt to decode the current block
-		if err := ReadAndDeserializeJSON(&jobj, jsonBlock.String()); err == nil {
-			// Successfully decoded, process the person
-			jsonBlock.Reset() // Reset the block for the next JSON object
-			break
+	for {
+		value, err := o.JsonStream.Next()
+		if err == io.EOF {
+			return nil, ErrEndOfInput
 		}
-		// If decoding fails, it might be because the JSON object is split across lines, so continue accumulating
-	}
-
-	if err := scanner.Err(); err != nil {
-		fmt.Fprintf(os.Stderr, "reading standard input: %v\n", err)
-		return nil, scanner, err
+		var recordErr *JsonRecordError
+		if errors.As(err, &recordErr) {
+			slog.Error("Skipping malformed API values", "error", err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if apivalues, ok := value.(map[string]interface{}); ok {
+			return apivalues, nil
+		}
+		slog.Error(fmt.Sprintf("Skipping API values before line %d, not a JSON object", o.JsonStream.line))
 	}
-
-	return jobj, scanner, nil
 }
 
 // func ListFiles(path string, topOnly bool) ([]string, error) {